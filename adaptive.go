@@ -0,0 +1,142 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log"
+	"net/http"
+	"sync"
+	"time"
+)
+
+const (
+	// adaptiveWindow is how many download outcomes the adaptive limiter
+	// judges the site's health by.
+	adaptiveWindow = 20
+	// adaptiveMaxErrorRate is the share of throttling or server errors in
+	// the window above which concurrency is halved.
+	adaptiveMaxErrorRate = 0.2
+	// adaptiveSlowdown is how many times slower than the best window seen
+	// the average latency may get before concurrency is halved.
+	adaptiveSlowdown = 2
+)
+
+// statusError is returned for a response with an unexpected status.
+type statusError struct {
+	code       int
+	status     string
+	url        string
+	retryAfter string // Retry-After header
+}
+
+func (e *statusError) Error() string {
+	return fmt.Sprintf("unexpected status %s for %s", e.status, e.url)
+}
+
+// throttled reports whether err suggests the site is overloaded or limiting
+// us: a 403, 429 or 5xx response, or a request failing outright. Pictures
+// that are missing or broken say nothing about the site's health.
+func throttled(err error) bool {
+	if err == nil || errors.Is(err, errKept) || errors.Is(err, errCorruptImage) || errors.Is(err, context.Canceled) {
+		return false
+	}
+	var se *statusError
+	if errors.As(err, &se) {
+		return se.code == http.StatusForbidden || se.code == http.StatusTooManyRequests || se.code >= 500
+	}
+	return true
+}
+
+// adaptiveLimiter gates downloads with a concurrency level that it halves
+// when recent downloads show throttling or rising latency, and raises one
+// step at a time back towards max while they are healthy.
+type adaptiveLimiter struct {
+	mu      sync.Mutex
+	max     int
+	level   int
+	active  int
+	wake    chan struct{} // closed when a slot may have become free
+	errors  int
+	latency []time.Duration
+	best    time.Duration // lowest average latency of a full window
+}
+
+func newAdaptiveLimiter(max int) *adaptiveLimiter {
+	return &adaptiveLimiter{max: max, level: max, wake: make(chan struct{})}
+}
+
+// acquire waits for a slot, reporting false if ctx is done first.
+func (l *adaptiveLimiter) acquire(ctx context.Context) bool {
+	for {
+		l.mu.Lock()
+		if l.active < l.level {
+			l.active++
+			l.mu.Unlock()
+			return true
+		}
+		wake := l.wake
+		l.mu.Unlock()
+		select {
+		case <-wake:
+		case <-ctx.Done():
+			return false
+		}
+	}
+}
+
+// release frees a slot and records the outcome of the download it was held
+// for, which took d.
+func (l *adaptiveLimiter) release(err error, d time.Duration) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	l.active--
+	if throttled(err) {
+		l.errors++
+	}
+	l.latency = append(l.latency, d)
+	l.adjust()
+	close(l.wake)
+	l.wake = make(chan struct{})
+}
+
+// adjust changes the level once the window is full. l.mu must be held.
+func (l *adaptiveLimiter) adjust() {
+	if len(l.latency) < adaptiveWindow {
+		return
+	}
+	var total time.Duration
+	for _, d := range l.latency {
+		total += d
+	}
+	avg := total / time.Duration(len(l.latency))
+	rate := float64(l.errors) / float64(len(l.latency))
+	l.errors, l.latency = 0, l.latency[:0]
+
+	level := l.level
+	switch {
+	case rate > adaptiveMaxErrorRate:
+		level = l.level / 2
+	case l.best > 0 && avg > adaptiveSlowdown*l.best:
+		level = l.level / 2
+	case l.level < l.max:
+		level = l.level + 1
+	}
+	if level < 1 {
+		level = 1
+	}
+	if rate <= adaptiveMaxErrorRate && (l.best == 0 || avg < l.best) {
+		l.best = avg
+	}
+	if level != l.level {
+		log.Printf("adaptive: download concurrency %d -> %d (%.0f%% errors, %v average latency)", l.level, level, rate*100, avg.Round(time.Millisecond))
+		l.level = level
+	}
+}
+
+// current returns the concurrency level.
+func (l *adaptiveLimiter) current() int {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	return l.level
+}
@@ -0,0 +1,128 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+// feed releases a slot of l for each of n downloads ending with err after d.
+func feed(t *testing.T, l *adaptiveLimiter, n int, err error, d time.Duration) {
+	t.Helper()
+	for i := 0; i < n; i++ {
+		if !l.acquire(context.Background()) {
+			t.Fatal("no slot")
+		}
+		l.release(err, d)
+	}
+}
+
+func TestAdaptiveLimiter(t *testing.T) {
+	tooMany := &statusError{code: http.StatusTooManyRequests, status: "429 Too Many Requests"}
+	notFound := &statusError{code: http.StatusNotFound, status: "404 Not Found"}
+	const fast, slow = 10 * time.Millisecond, 50 * time.Millisecond
+
+	l := newAdaptiveLimiter(8)
+	steps := []struct {
+		name string
+		n    int
+		err  error
+		d    time.Duration
+		want int
+	}{
+		{"healthy at max", adaptiveWindow, nil, fast, 8},
+		{"partial window", adaptiveWindow - 1, tooMany, fast, 8},
+		{"errors complete the window", 1, tooMany, fast, 4},
+		{"throttling", adaptiveWindow, tooMany, fast, 2},
+		{"floor", 3 * adaptiveWindow, errors.New("connection reset"), fast, 1},
+		{"missing pictures are healthy", adaptiveWindow, notFound, fast, 2},
+		{"recovering", adaptiveWindow, nil, fast, 3},
+		{"latency rising", adaptiveWindow, nil, slow, 1},
+		{"growing back", 7 * adaptiveWindow, nil, fast, 8},
+		{"capped at max", adaptiveWindow, nil, fast, 8},
+	}
+	for _, s := range steps {
+		feed(t, l, s.n, s.err, s.d)
+		if got := l.current(); got != s.want {
+			t.Fatalf("%s: level %d, want %d", s.name, got, s.want)
+		}
+	}
+}
+
+func TestAdaptiveLimiterGates(t *testing.T) {
+	l := newAdaptiveLimiter(2)
+	for i := 0; i < 2; i++ {
+		if !l.acquire(context.Background()) {
+			t.Fatal("no slot")
+		}
+	}
+	ctx, cancel := context.WithTimeout(context.Background(), 20*time.Millisecond)
+	defer cancel()
+	if l.acquire(ctx) {
+		t.Fatal("acquired a slot beyond the level")
+	}
+
+	got := make(chan bool)
+	go func() { got <- l.acquire(context.Background()) }()
+	l.release(nil, time.Millisecond)
+	select {
+	case ok := <-got:
+		if !ok {
+			t.Error("waiting acquire failed")
+		}
+	case <-time.After(5 * time.Second):
+		t.Fatal("waiting acquire not woken by release")
+	}
+}
+
+// TestDownloadPicAdaptiveStopped checks that a download given up while
+// waiting for a slot does not count against -limit.
+func TestDownloadPicAdaptiveStopped(t *testing.T) {
+	cfg := testConfig(t, "https://www.starwars.com")
+	cfg.adaptive = true
+	cfg.limit = 5
+	g := newTestGrabber(t, cfg)
+	g.state = openState(cfg.stateDir, false)
+	// Every slot is taken.
+	for i := 0; i < g.adaptive.max; i++ {
+		g.adaptive.acquire(context.Background())
+	}
+
+	work, stop := context.WithCancel(context.Background())
+	time.AfterFunc(20*time.Millisecond, stop)
+	pics := make(chan Picture, 1)
+	pics <- pic("a", "https://example.com/a.jpeg")
+	close(pics)
+	var wg sync.WaitGroup
+	wg.Add(1)
+	g.downloadPic(context.Background(), work, &wg, pics)
+	if n := atomic.LoadInt64(&g.limit.reserved); n != 0 {
+		t.Errorf("%d downloads reserved after giving up, want 0", n)
+	}
+}
+
+func TestThrottled(t *testing.T) {
+	tests := []struct {
+		err  error
+		want bool
+	}{
+		{nil, false},
+		{errKept, false},
+		{errCorruptImage, false},
+		{context.Canceled, false},
+		{&statusError{code: http.StatusNotFound}, false},
+		{&statusError{code: http.StatusForbidden}, true},
+		{&statusError{code: http.StatusTooManyRequests}, true},
+		{&statusError{code: http.StatusBadGateway}, true},
+		{errors.New("connection refused"), true},
+	}
+	for _, tt := range tests {
+		if got := throttled(tt.err); got != tt.want {
+			t.Errorf("throttled(%v) = %v, want %v", tt.err, got, tt.want)
+		}
+	}
+}
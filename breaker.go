@@ -0,0 +1,145 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"log"
+	"net/http"
+	"sync"
+	"time"
+)
+
+type breakerState int
+
+const (
+	breakerClosed   breakerState = iota // requests flow
+	breakerOpen                         // requests wait for the cool-down to end
+	breakerHalfOpen                     // a single probe is in flight
+)
+
+// breaker is a RoundTripper that stops sending requests while the servers
+// look down. After threshold consecutive requests fail with a network error
+// or a 5xx response, it opens: requests wait, rather than fail, until the
+// cool-down is over. A single probe request then decides whether to close it
+// again or wait for another cool-down.
+type breaker struct {
+	next      http.RoundTripper
+	threshold int
+	cooldown  time.Duration
+
+	mu        sync.Mutex
+	state     breakerState
+	failures  int
+	openUntil time.Time
+	wake      chan struct{} // closed when the state changes
+}
+
+// newBreaker wraps next in a circuit breaker. A threshold below 1 disables
+// it.
+func newBreaker(next http.RoundTripper, threshold int, cooldown time.Duration) http.RoundTripper {
+	if threshold < 1 {
+		return next
+	}
+	return &breaker{next: next, threshold: threshold, cooldown: cooldown, wake: make(chan struct{})}
+}
+
+func (b *breaker) RoundTrip(req *http.Request) (*http.Response, error) {
+	probe, err := b.admit(req.Context())
+	if err != nil {
+		return nil, err
+	}
+	resp, err := b.next.RoundTrip(req)
+	if err != nil && errors.Is(req.Context().Err(), context.Canceled) {
+		// The request was given up on, which says nothing of the
+		// servers. Timeouts still count as failures.
+		b.abandon(probe)
+		return nil, err
+	}
+	b.record(probe, err != nil || resp.StatusCode >= 500)
+	return resp, err
+}
+
+// admit waits until a request may be sent, reporting whether it is the probe
+// of a half-open breaker.
+func (b *breaker) admit(ctx context.Context) (probe bool, err error) {
+	for {
+		b.mu.Lock()
+		if b.state == breakerOpen && !time.Now().Before(b.openUntil) {
+			log.Printf("circuit breaker half-open: probing with a single request")
+			b.setState(breakerHalfOpen)
+			b.mu.Unlock()
+			return true, nil
+		}
+		if b.state == breakerClosed {
+			b.mu.Unlock()
+			return false, nil
+		}
+		// Wait for the cool-down to end, or for the probe's outcome.
+		wake, open, wait := b.wake, b.state == breakerOpen, time.Until(b.openUntil)
+		b.mu.Unlock()
+
+		var (
+			t      *time.Timer
+			cooled <-chan time.Time
+		)
+		if open {
+			t = time.NewTimer(wait)
+			cooled = t.C
+		}
+		select {
+		case <-wake:
+		case <-cooled:
+		case <-ctx.Done():
+		}
+		if t != nil {
+			t.Stop()
+		}
+		if err := ctx.Err(); err != nil {
+			return false, err
+		}
+	}
+}
+
+// abandon records that a request admitted by the breaker was given up on. An
+// abandoned probe leaves the breaker open, with the cool-down over, so that
+// the next request probes again.
+func (b *breaker) abandon(probe bool) {
+	if !probe {
+		return
+	}
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	log.Printf("circuit breaker open: probe abandoned, the next request probes again")
+	b.setState(breakerOpen)
+}
+
+func (b *breaker) record(probe, failed bool) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	if !failed {
+		b.failures = 0
+		if b.state != breakerClosed {
+			log.Printf("circuit breaker closed: requests resume")
+			b.setState(breakerClosed)
+		}
+		return
+	}
+	b.failures++
+	switch {
+	case probe:
+		log.Printf("circuit breaker open: probe failed, pausing requests for %v", b.cooldown)
+	case b.state == breakerClosed && b.failures >= b.threshold:
+		log.Printf("circuit breaker open: %d consecutive requests failed, pausing requests for %v", b.failures, b.cooldown)
+	default:
+		return
+	}
+	b.openUntil = time.Now().Add(b.cooldown)
+	b.setState(breakerOpen)
+}
+
+// setState changes the state and wakes waiting requests. b.mu must be held.
+func (b *breaker) setState(s breakerState) {
+	b.state = s
+	close(b.wake)
+	b.wake = make(chan struct{})
+}
@@ -0,0 +1,130 @@
+package main
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"sync"
+	"testing"
+	"time"
+)
+
+// scriptedServer answers each request with the next of codes, and with the
+// last one once they run out. A code of 0 makes the request hang until the
+// client gives up.
+func scriptedServer(t *testing.T, codes ...int) *httptest.Server {
+	var mu sync.Mutex
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		mu.Lock()
+		code := codes[0]
+		if len(codes) > 1 {
+			codes = codes[1:]
+		}
+		mu.Unlock()
+		if code == 0 {
+			<-r.Context().Done()
+			return
+		}
+		w.WriteHeader(code)
+	}))
+	t.Cleanup(srv.Close)
+	return srv
+}
+
+func breakerGet(ctx context.Context, rt http.RoundTripper, url string) (int, error) {
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, url, nil)
+	if err != nil {
+		return 0, err
+	}
+	resp, err := rt.RoundTrip(req)
+	if err != nil {
+		return 0, err
+	}
+	resp.Body.Close()
+	return resp.StatusCode, nil
+}
+
+func (b *breaker) current() breakerState {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	return b.state
+}
+
+func TestBreakerTripsAndRecovers(t *testing.T) {
+	srv := scriptedServer(t, 500, 502, 503, 500, 200)
+	const cooldown = 50 * time.Millisecond
+	b := newBreaker(http.DefaultTransport, 2, cooldown).(*breaker)
+	ctx := context.Background()
+
+	for i := 0; i < 2; i++ {
+		if _, err := breakerGet(ctx, b, srv.URL); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if s := b.current(); s != breakerOpen {
+		t.Fatalf("state after 2 failures = %v, want open", s)
+	}
+
+	// The request waits out the cool-down rather than fail, probes, fails
+	// again, and waits out another.
+	start := time.Now()
+	if code, err := breakerGet(ctx, b, srv.URL); err != nil || code != 503 {
+		t.Fatalf("probe: got %d, %v", code, err)
+	}
+	if s := b.current(); s != breakerOpen {
+		t.Fatalf("state after a failed probe = %v, want open", s)
+	}
+	breakerGet(ctx, b, srv.URL)
+	if code, err := breakerGet(ctx, b, srv.URL); err != nil || code != 200 {
+		t.Fatalf("got %d, %v, want 200", code, err)
+	}
+	if elapsed := time.Since(start); elapsed < 3*cooldown {
+		t.Errorf("recovered after %v, before three cool-downs", elapsed)
+	}
+	if s := b.current(); s != breakerClosed {
+		t.Errorf("state after a successful probe = %v, want closed", s)
+	}
+}
+
+func TestBreakerWaitCancelled(t *testing.T) {
+	srv := scriptedServer(t, 500)
+	b := newBreaker(http.DefaultTransport, 1, time.Hour).(*breaker)
+	breakerGet(context.Background(), b, srv.URL)
+
+	ctx, cancel := context.WithTimeout(context.Background(), 20*time.Millisecond)
+	defer cancel()
+	if _, err := breakerGet(ctx, b, srv.URL); err != context.DeadlineExceeded {
+		t.Errorf("waiting on an open breaker: got %v, want %v", err, context.DeadlineExceeded)
+	}
+}
+
+func TestBreakerCancelledProbe(t *testing.T) {
+	srv := scriptedServer(t, 500, 0, 200)
+	b := newBreaker(http.DefaultTransport, 1, 10*time.Millisecond).(*breaker)
+	breakerGet(context.Background(), b, srv.URL)
+
+	ctx, cancel := context.WithCancel(context.Background())
+	time.AfterFunc(50*time.Millisecond, cancel)
+	if _, err := breakerGet(ctx, b, srv.URL); err == nil {
+		t.Fatal("cancelled probe succeeded")
+	}
+	if s := b.current(); s != breakerOpen {
+		t.Fatalf("state after a cancelled probe = %v, want open", s)
+	}
+	// The next request probes straight away.
+	done := make(chan struct{})
+	go func() {
+		defer close(done)
+		if code, err := breakerGet(context.Background(), b, srv.URL); err != nil || code != 200 {
+			t.Errorf("got %d, %v, want 200", code, err)
+		}
+	}()
+	select {
+	case <-done:
+	case <-time.After(5 * time.Second):
+		t.Fatal("no probe after a cancelled one")
+	}
+	if s := b.current(); s != breakerClosed {
+		t.Errorf("state after a successful probe = %v, want closed", s)
+	}
+}
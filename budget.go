@@ -0,0 +1,51 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"log"
+	"sync/atomic"
+)
+
+// errBudgetExceeded is returned once the run has written as many bytes as
+// -max-total allows.
+var errBudgetExceeded = errors.New("download size budget exceeded")
+
+// byteBudget caps the bytes written to the output over a run. Downloads write
+// through it, so that it counts the bytes of every worker. It is safe for
+// concurrent use.
+type byteBudget struct {
+	limit int64
+	abort bool // fail writes past the limit rather than letting them finish
+
+	used int64 // accessed atomically
+}
+
+// Write counts p against the budget. Past the limit, it fails if downloads in
+// progress are to be aborted.
+func (b *byteBudget) Write(p []byte) (int, error) {
+	used := atomic.AddInt64(&b.used, int64(len(p)))
+	if b.abort && used > b.limit {
+		return 0, b.err()
+	}
+	return len(p), nil
+}
+
+// check returns an error wrapping errBudgetExceeded if no more downloads are
+// to be started.
+func (b *byteBudget) check() error {
+	if b == nil || atomic.LoadInt64(&b.used) < b.limit {
+		return nil
+	}
+	return b.err()
+}
+
+func (b *byteBudget) err() error {
+	return fmt.Errorf("%w: wrote %s of %s", errBudgetExceeded,
+		formatBytes(atomic.LoadInt64(&b.used)), formatBytes(b.limit))
+}
+
+// logUsage logs the bytes written against the budget.
+func (b *byteBudget) logUsage() {
+	log.Printf("budget: wrote %s of %s", formatBytes(atomic.LoadInt64(&b.used)), formatBytes(b.limit))
+}
@@ -0,0 +1,82 @@
+package main
+
+import (
+	"strings"
+	"unicode"
+
+	"golang.org/x/net/html"
+)
+
+// asciiPunctuation maps typographic punctuation to its ASCII look-alike.
+var asciiPunctuation = strings.NewReplacer(
+	"‘", "'", "’", "'", "‚", "'", "‛", "'", "′", "'",
+	"“", `"`, "”", `"`, "„", `"`, "‟", `"`, "″", `"`,
+	"‐", "-", "‑", "-", "‒", "-", "–", "-", "—", "-", "―", "-",
+	"…", "...",
+)
+
+// normalizeCaption returns caption with HTML entities decoded, markup reduced
+// to its text, runs of whitespace, non-breaking spaces included, collapsed to a
+// single space, and invisible characters removed. If ascii, typographic
+// quotes, dashes and ellipses are replaced by their ASCII look-alikes too.
+func normalizeCaption(caption string, ascii bool) string {
+	s := html.UnescapeString(caption)
+	if strings.Contains(s, "<") {
+		s = stripMarkup(s)
+	}
+	s = strings.Map(func(r rune) rune {
+		switch {
+		case unicode.IsSpace(r):
+			return ' '
+		case unicode.Is(unicode.Cf, r), unicode.IsControl(r):
+			return -1
+		}
+		return r
+	}, s)
+	s = strings.Join(strings.Fields(s), " ")
+	if ascii {
+		s = asciiPunctuation.Replace(s)
+	}
+	return s
+}
+
+// blockElements are the elements whose boundaries separate words, unlike
+// inline ones such as em.
+var blockElements = map[string]bool{
+	"br": true, "p": true, "div": true, "li": true, "ul": true, "ol": true,
+	"hr": true, "h1": true, "h2": true, "h3": true, "h4": true, "h5": true,
+	"h6": true, "tr": true, "td": true, "th": true, "blockquote": true,
+}
+
+// stripMarkup returns the text of the HTML fragment s, whose entities are
+// already decoded, with a space at the boundaries of block elements and of
+// line breaks. The content of scripts and styles is dropped, and a "<" not
+// starting a tag is kept as text.
+func stripMarkup(s string) string {
+	var b strings.Builder
+	z := html.NewTokenizer(strings.NewReader(s))
+	skip := ""
+	for {
+		switch z.Next() {
+		case html.ErrorToken:
+			return b.String()
+		case html.TextToken:
+			if skip == "" {
+				b.Write(z.Raw())
+			}
+		case html.StartTagToken, html.EndTagToken, html.SelfClosingTagToken:
+			name, _ := z.TagName()
+			tag := string(name)
+			switch {
+			case skip != "":
+				if tag == skip {
+					skip = ""
+				}
+			case tag == "script" || tag == "style":
+				skip = tag
+			case blockElements[tag]:
+				b.WriteByte(' ')
+			}
+		}
+	}
+}
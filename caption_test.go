@@ -0,0 +1,32 @@
+package main
+
+import "testing"
+
+func TestNormalizeCaption(t *testing.T) {
+	tests := []struct {
+		caption string
+		ascii   bool
+		want    string
+	}{
+		{"Grogu and the Mandalorian", false, "Grogu and the Mandalorian"},
+		{"  Grogu and\tthe\n Mandalorian ", false, "Grogu and the Mandalorian"},
+		{"Din\u200b Djarin\u00ad", false, "Din Djarin"},
+		{"Boba &amp; Fennec", false, "Boba & Fennec"},
+		{"Boba &lt;3 Fennec", false, "Boba <3 Fennec"},
+		{"1 < 2 and 3 > 2", false, "1 < 2 and 3 > 2"},
+		{"<p>Concept art by <em>Doug <strong>Chiang</strong></em>.</p>", false, "Concept art by Doug Chiang."},
+		{"<div><p>Nevarro</p><ul><li>Day</li><li>Night</li></ul></div>", false, "Nevarro Day Night"},
+		{"Nevarro<br/>Day<br>Night<hr />", false, "Nevarro Day Night"},
+		{"Mando<img src=\"x.jpeg\"/>lorian", false, "Mandolorian"},
+		{"Grogu<script>alert('x')</script><style>p{}</style> eats", false, "Grogu eats"},
+		{"&lt;i&gt;The Child&lt;/i&gt;", false, "The Child"},
+		{"“This is the Way” — Din’s creed…", false, "“This is the Way” — Din’s creed…"},
+		{"“This is the Way” — Din’s creed…", true, `"This is the Way" - Din's creed...`},
+		{"", false, ""},
+	}
+	for _, tt := range tests {
+		if got := normalizeCaption(tt.caption, tt.ascii); got != tt.want {
+			t.Errorf("normalizeCaption(%q, %t) = %q, want %q", tt.caption, tt.ascii, got, tt.want)
+		}
+	}
+}
@@ -0,0 +1,146 @@
+package main
+
+import (
+	"bufio"
+	"flag"
+	"fmt"
+	"io"
+	"os"
+	"sort"
+	"strconv"
+	"strings"
+)
+
+// chapterSelection is the chapters chosen with -chapters and -chapters-file.
+// Without either, every chapter is selected.
+type chapterSelection struct {
+	list string // comma-separated chapters and ranges such as 3-5, or - for standard input
+	file string // file of chapters and ranges, one per line
+}
+
+// chapterFlags defines on fs the flags selecting chapters.
+func chapterFlags(fs *flag.FlagSet, sel *chapterSelection) {
+	fs.StringVar(&sel.list, "chapters", sel.list, "chapters to fetch, such as 1,3,5-8, or - to read them from standard input one per line (default every chapter of -series)")
+	fs.StringVar(&sel.file, "chapters-file", sel.file, "file listing the chapters to fetch, one chapter or range per line; # starts a comment")
+}
+
+// all reports whether every chapter is selected, neither flag being set.
+func (s chapterSelection) all() bool {
+	return s.list == "" && s.file == ""
+}
+
+// chapters returns the selected chapters of ser in order, reading them from
+// stdin if -chapters is -.
+func (s chapterSelection) chapters(stdin io.Reader, ser series) ([]int, error) {
+	if s.all() {
+		return ser.chapterRange(), nil
+	}
+	var chapters []int
+	switch s.list {
+	case "":
+	case "-":
+		c, err := readChapters(stdin, "standard input")
+		if err != nil {
+			return nil, err
+		}
+		chapters = append(chapters, c...)
+	default:
+		for _, spec := range strings.Split(s.list, ",") {
+			c, err := parseChapterSpec(strings.TrimSpace(spec))
+			if err != nil {
+				return nil, fmt.Errorf("-chapters: %w", err)
+			}
+			chapters = append(chapters, c...)
+		}
+	}
+	if s.file != "" {
+		f, err := os.Open(s.file)
+		if err != nil {
+			return nil, fmt.Errorf("unable to read chapters: %w", err)
+		}
+		defer f.Close()
+		c, err := readChapters(f, s.file)
+		if err != nil {
+			return nil, err
+		}
+		chapters = append(chapters, c...)
+	}
+	if len(chapters) == 0 {
+		return nil, fmt.Errorf("no chapters selected")
+	}
+	return uniqueChapters(chapters), nil
+}
+
+// readChapters reads chapters and ranges from r, one per line. Blank lines
+// and anything after # are ignored. name is used in errors.
+func readChapters(r io.Reader, name string) ([]int, error) {
+	var chapters []int
+	s := bufio.NewScanner(r)
+	for n := 1; s.Scan(); n++ {
+		line := s.Text()
+		if i := strings.IndexByte(line, '#'); i >= 0 {
+			line = line[:i]
+		}
+		line = strings.TrimSpace(line)
+		if line == "" {
+			continue
+		}
+		c, err := parseChapterSpec(line)
+		if err != nil {
+			return nil, fmt.Errorf("%s:%d: %w", name, n, err)
+		}
+		chapters = append(chapters, c...)
+	}
+	if err := s.Err(); err != nil {
+		return nil, fmt.Errorf("unable to read chapters from %s: %w", name, err)
+	}
+	return chapters, nil
+}
+
+// maxChapter is the highest chapter that can be selected, far past any
+// series, so that a mistyped range cannot exhaust memory.
+const maxChapter = 10000
+
+// parseChapterSpec parses a chapter, such as 3, or an inclusive range of
+// chapters, such as 3-5.
+func parseChapterSpec(spec string) ([]int, error) {
+	from, to, err := parseRange(spec)
+	if err != nil {
+		return nil, fmt.Errorf("invalid chapter %q", spec)
+	}
+	if to > maxChapter {
+		return nil, fmt.Errorf("invalid chapter %q, chapters go up to %d", spec, maxChapter)
+	}
+	chapters := make([]int, 0, to-from+1)
+	for c := from; c <= to; c++ {
+		chapters = append(chapters, c)
+	}
+	return chapters, nil
+}
+
+// parseRange parses a positive number, such as 3, or an inclusive range of
+// them, such as 3-5, returning its bounds.
+func parseRange(spec string) (from, to int, err error) {
+	first, last := spec, spec
+	if i := strings.IndexByte(spec, '-'); i >= 0 {
+		first, last = strings.TrimSpace(spec[:i]), strings.TrimSpace(spec[i+1:])
+	}
+	from, err1 := strconv.Atoi(first)
+	to, err2 := strconv.Atoi(last)
+	if err1 != nil || err2 != nil || from < 1 || to < from {
+		return 0, 0, fmt.Errorf("invalid range %q", spec)
+	}
+	return from, to, nil
+}
+
+// uniqueChapters sorts chapters and removes repeats, in place.
+func uniqueChapters(chapters []int) []int {
+	sort.Ints(chapters)
+	out := chapters[:0]
+	for i, c := range chapters {
+		if i == 0 || c != chapters[i-1] {
+			out = append(out, c)
+		}
+	}
+	return out
+}
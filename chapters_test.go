@@ -0,0 +1,79 @@
+package main
+
+import (
+	"os"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+func TestParseChapterSpec(t *testing.T) {
+	tests := []struct {
+		spec string
+		want []int // nil for an invalid spec
+	}{
+		{"3", []int{3}},
+		{"3-5", []int{3, 4, 5}},
+		{"3 - 5", []int{3, 4, 5}},
+		{"5-5", []int{5}},
+		{"0", nil},
+		{"5-3", nil},
+		{"-3", nil},
+		{"3-", nil},
+		{"x", nil},
+		{"1-10001", nil},
+		{"1-9999999999", nil},
+	}
+	for _, tt := range tests {
+		got, err := parseChapterSpec(tt.spec)
+		if tt.want == nil {
+			if err == nil {
+				t.Errorf("%q: got %d chapters, want an error", tt.spec, len(got))
+			}
+			continue
+		}
+		if err != nil || !reflect.DeepEqual(got, tt.want) {
+			t.Errorf("%q: got %v, %v, want %v", tt.spec, got, err, tt.want)
+		}
+	}
+	if got, err := parseChapterSpec("9999-10000"); err != nil || len(got) != 2 {
+		t.Errorf("range up to maxChapter: got %v, %v", got, err)
+	}
+}
+
+func TestChapterSelection(t *testing.T) {
+	file := filepath.Join(t.TempDir(), "chapters.txt")
+	write := func(s string) {
+		if err := os.WriteFile(file, []byte(s), 0644); err != nil {
+			t.Fatal(err)
+		}
+	}
+	ser := builtinSeries[0]
+
+	write("# season 1\n1-3\n\n8 # finale\n")
+	got, err := chapterSelection{list: "3,10-11", file: file}.chapters(nil, ser)
+	if want := []int{1, 2, 3, 8, 10, 11}; err != nil || !reflect.DeepEqual(got, want) {
+		t.Errorf("got %v, %v, want %v", got, err, want)
+	}
+	got, err = chapterSelection{list: "-"}.chapters(strings.NewReader("2\n1\n"), ser)
+	if want := []int{1, 2}; err != nil || !reflect.DeepEqual(got, want) {
+		t.Errorf("from standard input: got %v, %v, want %v", got, err, want)
+	}
+
+	// Bad lines are reported by number.
+	for content, want := range map[string]string{
+		"1\n2\nthree\n":            file + ":3: ",
+		"# header\n\n1-99999999\n": file + ":3: ",
+		"5-2\n":                    file + ":1: ",
+	} {
+		write(content)
+		_, err := chapterSelection{file: file}.chapters(nil, ser)
+		if err == nil || !strings.HasPrefix(err.Error(), want) {
+			t.Errorf("%q: got error %v, want it to start with %s", content, err, want)
+		}
+	}
+	if _, err := (chapterSelection{list: "1,1-99999999"}).chapters(nil, ser); err == nil || !strings.HasPrefix(err.Error(), "-chapters: ") {
+		t.Errorf("huge range in -chapters: got error %v", err)
+	}
+}
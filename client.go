@@ -0,0 +1,231 @@
+package main
+
+import (
+	"context"
+	"crypto/tls"
+	"crypto/x509"
+	"errors"
+	"fmt"
+	"io"
+	"log"
+	"net"
+	"net/http"
+	"net/http/cookiejar"
+	"os"
+	"strings"
+	"sync"
+	"time"
+)
+
+// newHTTPClient returns the client shared by every request of a run. Its
+// connection pool is sized so that each worker can keep a connection alive.
+// Requests go through proxies if it is not nil.
+func newHTTPClient(cfg config, proxies *proxyRotator) (*http.Client, error) {
+	tlsConfig, err := newTLSConfig(cfg)
+	if err != nil {
+		return nil, err
+	}
+	dialer := &net.Dialer{
+		Timeout:   30 * time.Second,
+		KeepAlive: 30 * time.Second,
+	}
+	transport := &http.Transport{
+		Proxy:                 http.ProxyFromEnvironment,
+		DialContext:           dialer.DialContext,
+		ForceAttemptHTTP2:     true,
+		MaxIdleConns:          100,
+		MaxIdleConnsPerHost:   worker + cfg.galleryWorkers,
+		IdleConnTimeout:       90 * time.Second,
+		TLSClientConfig:       tlsConfig,
+		TLSHandshakeTimeout:   10 * time.Second,
+		ResponseHeaderTimeout: 30 * time.Second,
+		ExpectContinueTimeout: time.Second,
+	}
+	// The breaker wraps the host limiter, so that requests held back by an
+	// open breaker do not take up a host's slots.
+	var base http.RoundTripper = transport
+	if proxies != nil {
+		proxies.use(transport)
+		base = proxies
+	}
+	rt := newBreaker(newHostLimiter(base, cfg.perHost), cfg.breakerThreshold, cfg.breakerCooldown)
+	if rt, err = newCredentials(rt, cfg.cookies, cfg.basicAuth); err != nil {
+		return nil, err
+	}
+	// Cookies set by the site are kept for the rest of the run.
+	jar, err := cookiejar.New(nil)
+	if err != nil {
+		return nil, err
+	}
+	client := &http.Client{Transport: rt, Jar: jar}
+	if cfg.noFollow {
+		client.CheckRedirect = func(*http.Request, []*http.Request) error {
+			return http.ErrUseLastResponse
+		}
+	}
+	return client, nil
+}
+
+// credentials is a RoundTripper adding the cookies and basic authentication
+// given on the command line to every request, for galleries behind a login.
+type credentials struct {
+	next     http.RoundTripper
+	cookies  []*http.Cookie
+	user     string
+	password string
+	basic    bool // whether to send basic authentication
+}
+
+// newCredentials wraps next so that requests carry cookies, each name=value,
+// and basic authentication if basicAuth, user:password, is not empty. It
+// returns next if there are neither.
+func newCredentials(next http.RoundTripper, cookies []string, basicAuth string) (http.RoundTripper, error) {
+	if len(cookies) == 0 && basicAuth == "" {
+		return next, nil
+	}
+	c := &credentials{next: next}
+	for _, s := range cookies {
+		i := strings.IndexByte(s, '=')
+		if i <= 0 {
+			return nil, fmt.Errorf("invalid -cookie %q, expected name=value", s)
+		}
+		c.cookies = append(c.cookies, &http.Cookie{Name: strings.TrimSpace(s[:i]), Value: strings.TrimSpace(s[i+1:])})
+	}
+	if basicAuth != "" {
+		i := strings.IndexByte(basicAuth, ':')
+		if i < 0 {
+			return nil, errors.New("invalid -basic-auth, expected user:password")
+		}
+		c.user, c.password, c.basic = basicAuth[:i], basicAuth[i+1:], true
+	}
+	return c, nil
+}
+
+func (c *credentials) RoundTrip(req *http.Request) (*http.Response, error) {
+	req = req.Clone(req.Context())
+	for _, cookie := range c.cookies {
+		// A cookie the site has set since takes precedence.
+		if _, err := req.Cookie(cookie.Name); errors.Is(err, http.ErrNoCookie) {
+			req.AddCookie(cookie)
+		}
+	}
+	if c.basic && req.Header.Get("Authorization") == "" {
+		req.SetBasicAuth(c.user, c.password)
+	}
+	return c.next.RoundTrip(req)
+}
+
+// newTLSConfig returns the TLS configuration for cfg, trusting the roots in
+// its CA file besides the system's, or any certificate at all if insecure.
+func newTLSConfig(cfg config) (*tls.Config, error) {
+	c := &tls.Config{}
+	if cfg.caFile != "" {
+		pem, err := os.ReadFile(cfg.caFile)
+		if err != nil {
+			return nil, fmt.Errorf("unable to read CA file: %w", err)
+		}
+		pool, err := x509.SystemCertPool()
+		if err != nil || pool == nil {
+			pool = x509.NewCertPool()
+		}
+		if !pool.AppendCertsFromPEM(pem) {
+			return nil, fmt.Errorf("no certificates found in CA file %s", cfg.caFile)
+		}
+		c.RootCAs = pool
+	}
+	if cfg.insecure {
+		log.Print("WARNING: TLS certificates are not verified; anyone on the network path can intercept and alter downloads")
+		c.InsecureSkipVerify = true
+	}
+	return c, nil
+}
+
+// httpDo makes an HTTP request with client. It passes the HTTP response to closure f for it to handle.
+func httpDo(ctx context.Context, client *http.Client, req *http.Request, f func(*http.Response, error) error) error {
+	c := make(chan error, 1)
+	req = req.WithContext(ctx)
+	go func() {
+		c <- f(client.Do(req))
+	}()
+	select {
+	case <-ctx.Done():
+		<-c
+		return ctx.Err()
+	case err := <-c:
+		return err
+	}
+}
+
+// redirectChain returns the URLs requested to get resp, oldest first.
+func redirectChain(resp *http.Response) []string {
+	var chain []string
+	for req := resp.Request; req != nil; {
+		chain = append([]string{req.URL.String()}, chain...)
+		if req.Response == nil {
+			break
+		}
+		req = req.Response.Request
+	}
+	return chain
+}
+
+// hostLimiter is a RoundTripper bounding the number of requests in flight to
+// each host. A request holds its host's slot until its response body is
+// closed, and gives up waiting for one when its context is done.
+type hostLimiter struct {
+	next  http.RoundTripper
+	limit int
+
+	mu    sync.Mutex
+	hosts map[string]chan struct{}
+}
+
+// newHostLimiter wraps next so that at most limit requests per host are in
+// flight. A limit below 1 disables limiting.
+func newHostLimiter(next http.RoundTripper, limit int) http.RoundTripper {
+	if limit < 1 {
+		return next
+	}
+	return &hostLimiter{next: next, limit: limit, hosts: make(map[string]chan struct{})}
+}
+
+func (l *hostLimiter) sem(host string) chan struct{} {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	s, ok := l.hosts[host]
+	if !ok {
+		s = make(chan struct{}, l.limit)
+		l.hosts[host] = s
+	}
+	return s
+}
+
+func (l *hostLimiter) RoundTrip(req *http.Request) (*http.Response, error) {
+	sem := l.sem(req.URL.Host)
+	select {
+	case sem <- struct{}{}:
+	case <-req.Context().Done():
+		return nil, req.Context().Err()
+	}
+	var once sync.Once
+	release := func() { once.Do(func() { <-sem }) }
+	resp, err := l.next.RoundTrip(req)
+	if err != nil {
+		release()
+		return nil, err
+	}
+	resp.Body = &releaseBody{ReadCloser: resp.Body, release: release}
+	return resp, nil
+}
+
+// releaseBody calls release when the body is closed.
+type releaseBody struct {
+	io.ReadCloser
+	release func()
+}
+
+func (b *releaseBody) Close() error {
+	err := b.ReadCloser.Close()
+	b.release()
+	return err
+}
@@ -0,0 +1,181 @@
+package main
+
+import (
+	"context"
+	"crypto/x509"
+	"encoding/pem"
+	"errors"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+func TestHostLimiter(t *testing.T) {
+	a, b := newFakeSite(t), newFakeSite(t)
+	a.delay, b.delay = 50*time.Millisecond, 50*time.Millisecond
+	client := &http.Client{Transport: newHostLimiter(http.DefaultTransport, 2)}
+
+	var wg sync.WaitGroup
+	for i := 0; i < 8; i++ {
+		for _, site := range []*fakeSite{a, b} {
+			wg.Add(1)
+			go func(url string) {
+				defer wg.Done()
+				resp, err := client.Get(url)
+				if err != nil {
+					t.Error(err)
+					return
+				}
+				io.Copy(io.Discard, resp.Body)
+				resp.Body.Close()
+			}(site.URL + "/img/x.png")
+		}
+	}
+	wg.Wait()
+	// The sites listen on different ports, so they are limited
+	// independently of each other.
+	for _, site := range []*fakeSite{a, b} {
+		if site.peak() != 2 {
+			t.Errorf("%s: at most %d requests in flight, want 2", site.URL, site.peak())
+		}
+	}
+}
+
+func TestHostLimiterCancel(t *testing.T) {
+	site := newFakeSite(t)
+	client := &http.Client{Transport: newHostLimiter(http.DefaultTransport, 1)}
+	held, err := client.Get(site.URL + "/img/x.png")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// The only slot is held until the body is closed.
+	ctx, cancel := context.WithTimeout(context.Background(), 50*time.Millisecond)
+	defer cancel()
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, site.URL+"/img/y.png", nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := client.Do(req); !errors.Is(err, context.DeadlineExceeded) {
+		t.Fatalf("waiting for a slot: got error %v, want %v", err, context.DeadlineExceeded)
+	}
+
+	held.Body.Close()
+	resp, err := client.Get(site.URL + "/img/y.png")
+	if err != nil {
+		t.Fatalf("slot not released by closing the body: %v", err)
+	}
+	resp.Body.Close()
+}
+
+func TestTLSConfig(t *testing.T) {
+	srv := httptest.NewTLSServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		io.WriteString(w, "ok")
+	}))
+	defer srv.Close()
+	dir := t.TempDir()
+	caFile := filepath.Join(dir, "ca.pem")
+	if err := os.WriteFile(caFile, pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: srv.Certificate().Raw}), 0644); err != nil {
+		t.Fatal(err)
+	}
+	tests := []struct {
+		name     string
+		caFile   string
+		insecure bool
+		wantErr  bool
+	}{
+		{"default roots", "", false, true},
+		{"custom CA", caFile, false, false},
+		{"insecure", "", true, false},
+	}
+	for _, tt := range tests {
+		cfg := testConfig(t, srv.URL)
+		cfg.caFile, cfg.insecure = tt.caFile, tt.insecure
+		g := newTestGrabber(t, cfg)
+		resp, err := g.client.Get(srv.URL)
+		if err == nil {
+			resp.Body.Close()
+		}
+		var unknown x509.UnknownAuthorityError
+		switch {
+		case tt.wantErr && !errors.As(err, &unknown):
+			t.Errorf("%s: got error %v, want an unknown authority", tt.name, err)
+		case !tt.wantErr && err != nil:
+			t.Errorf("%s: %v", tt.name, err)
+		}
+	}
+
+	bad := filepath.Join(dir, "bad.pem")
+	if err := os.WriteFile(bad, []byte("not a certificate"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	cfg := testConfig(t, srv.URL)
+	cfg.caFile = bad
+	if _, err := newGrabber(cfg); err == nil {
+		t.Error("CA file without certificates accepted")
+	}
+}
+
+// TestCredentials runs against a site whose galleries require a session
+// cookie given with -cookie and basic authentication, and whose images
+// require a cookie the gallery page sets.
+func TestCredentials(t *testing.T) {
+	site := newFakeSite(t)
+	var rejected int64
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		user, password, _ := r.BasicAuth()
+		session, _ := r.Cookie("session")
+		if user != "din" || password != "djarin" || session == nil || session.Value != "abc" {
+			atomic.AddInt64(&rejected, 1)
+			http.Error(w, "forbidden", http.StatusForbidden)
+			return
+		}
+		if strings.HasPrefix(r.URL.Path, "/img/") {
+			if c, err := r.Cookie("cdn"); err != nil || c.Value != "granted" {
+				atomic.AddInt64(&rejected, 1)
+				http.Error(w, "forbidden", http.StatusForbidden)
+				return
+			}
+		} else {
+			http.SetCookie(w, &http.Cookie{Name: "cdn", Value: "granted", Path: "/"})
+		}
+		site.Config.Handler.ServeHTTP(w, r)
+	}))
+	defer srv.Close()
+	site.page(conceptPath(1, false), galleryHTML(srv.URL, []fakeImage{{"mando1", "The Mandalorian"}}), 0)
+
+	for _, authorized := range []bool{false, true} {
+		cfg := testConfig(t, srv.URL)
+		cfg.breakerThreshold = 0
+		if authorized {
+			cfg.cookies = []string{"session=abc"}
+			cfg.basicAuth = "din:djarin"
+		}
+		g := newTestGrabber(t, cfg)
+		ctx := context.Background()
+		g.run(ctx, ctx, []int{1})
+		_, err := os.Stat(filepath.Join(cfg.output, "The Mandalorian_mando1.jpeg"))
+		if authorized && err != nil {
+			t.Errorf("picture not downloaded with credentials: %v", err)
+		}
+		if !authorized && err == nil {
+			t.Error("picture downloaded without credentials")
+		}
+	}
+	if n := atomic.LoadInt64(&rejected); n == 0 {
+		t.Error("no request rejected without credentials")
+	}
+
+	cfg := testConfig(t, srv.URL)
+	cfg.cookies = []string{"session"}
+	if _, err := newGrabber(cfg); err == nil {
+		t.Error("cookie without a value accepted")
+	}
+}
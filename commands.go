@@ -0,0 +1,394 @@
+package main
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"flag"
+	"fmt"
+	"image/jpeg"
+	"io"
+	"log"
+	"os"
+	"os/signal"
+	"path/filepath"
+	"sort"
+	"time"
+)
+
+// command is a subcommand of the program.
+type command struct {
+	name    string
+	summary string
+	run     func(args []string) int
+}
+
+var commands []command
+
+func init() {
+	commands = []command{
+		{"fetch", "download the artworks (the default)", cmdFetch},
+		{"list", "list the chapters with a gallery and how many pictures each has", cmdList},
+		{"verify", "check downloaded files against the hashes recorded when they were downloaded", cmdVerify},
+	}
+}
+
+// realMain runs the subcommand named by the first argument, or fetch if there
+// is none, and returns the exit status.
+func realMain() int {
+	args := os.Args[1:]
+	if len(args) > 0 {
+		for _, c := range commands {
+			if args[0] == c.name {
+				return c.run(args[1:])
+			}
+		}
+	}
+	return cmdFetch(args)
+}
+
+// newFlagSet returns a flag set for the subcommand name whose usage lists the
+// subcommands.
+func newFlagSet(name string) *flag.FlagSet {
+	fs := flag.NewFlagSet(name, flag.ExitOnError)
+	fs.Usage = func() {
+		out := fs.Output()
+		fmt.Fprintf(out, "Usage: %s [command] [flags]\n\nCommands:\n", filepath.Base(os.Args[0]))
+		for _, c := range commands {
+			fmt.Fprintf(out, "  %-8s %s\n", c.name, c.summary)
+		}
+		fmt.Fprintf(out, "\nFlags of %s:\n", name)
+		fs.PrintDefaults()
+	}
+	return fs
+}
+
+// parseArgs parses args with fs, rejecting positional arguments.
+func parseArgs(fs *flag.FlagSet, args []string) {
+	fs.Parse(args)
+	if fs.NArg() > 0 {
+		fmt.Fprintf(fs.Output(), "unexpected argument %q\n", fs.Arg(0))
+		fs.Usage()
+		os.Exit(2)
+	}
+}
+
+func defaultConfig() config {
+	return config{
+		baseURL:          defaultBaseURL,
+		output:           "download",
+		galleryWorkers:   3,
+		jpegQuality:      jpeg.DefaultQuality,
+		contentDedup:     "off",
+		rendition:        renditionLargest,
+		retryDelay:       time.Minute,
+		perHost:          3,
+		galleryTimeout:   time.Minute,
+		downloadTimeout:  5 * time.Minute,
+		breakerThreshold: 10,
+		breakerCooldown:  time.Minute,
+		startJitter:      time.Second,
+		pollInterval:     6 * time.Hour,
+		maxRetryWait:     5 * time.Minute,
+		minFree:          256 << 20,
+		dirMode:          defaultDirMode,
+		fileMode:         defaultFileMode,
+		segments:         1,
+		maxPages:         20,
+		segmentThreshold: 16 << 20,
+		checkImages:      true,
+		verifyRetries:    2,
+		overwrite:        overwriteAlways,
+		groupBy:          groupNone,
+		series:           builtinSeries[0].Name,
+		detectMisses:     2,
+	}
+}
+
+// networkFlags defines on fs the flags controlling how pages are requested.
+func networkFlags(fs *flag.FlagSet, cfg *config) {
+	fs.StringVar(&cfg.baseURL, "base-url", cfg.baseURL, "scheme and host to fetch gallery pages from, e.g. a mirror or caching proxy")
+	fs.StringVar(&cfg.series, "series", cfg.series, "series to fetch the galleries of: the-mandalorian, the-book-of-boba-fett, or one defined with -sources")
+	fs.IntVar(&cfg.detectMisses, "detect-misses", cfg.detectMisses, "without -chapters, look for chapters past the series' last until this many in a row are not published (0 not to look)")
+	fs.StringVar(&cfg.sourcesFile, "sources", cfg.sourcesFile, "JSON file defining series, each with a name, chapter range and gallery URL templates containing {chapter}")
+	fs.IntVar(&cfg.galleryWorkers, "gallery-workers", cfg.galleryWorkers, "number of gallery pages to fetch concurrently")
+	fs.StringVar(&cfg.cacheDir, "cache-dir", cfg.cacheDir, "keep the HTML of gallery pages in this directory and parse it from there instead of fetching the pages again")
+	fs.DurationVar(&cfg.cacheTTL, "cache-ttl", cfg.cacheTTL, "how long cached gallery HTML is used for (0 for ever)")
+	fs.BoolVar(&cfg.refreshCache, "refresh-cache", cfg.refreshCache, "fetch gallery pages again and replace their cached HTML")
+	fs.BoolVar(&cfg.noFollow, "no-follow", cfg.noFollow, "do not follow redirects, for debugging")
+	fs.BoolVar(&cfg.verbose, "v", cfg.verbose, "verbose logging")
+	fs.BoolVar(&cfg.quiet, "quiet", cfg.quiet, "only log warnings, errors and the summary, not every picture handled")
+	fs.DurationVar(&cfg.galleryTimeout, "gallery-timeout", cfg.galleryTimeout, "deadline for fetching each gallery page (0 for none)")
+	fs.DurationVar(&cfg.downloadTimeout, "download-timeout", cfg.downloadTimeout, "deadline for downloading each picture, body included (0 for none)")
+	fs.Func("item-timeout", "set both -gallery-timeout and -download-timeout (deprecated)", func(s string) error {
+		d, err := time.ParseDuration(s)
+		if err != nil {
+			return err
+		}
+		cfg.galleryTimeout, cfg.downloadTimeout = d, d
+		return nil
+	})
+	fs.IntVar(&cfg.perHost, "per-host", cfg.perHost, "maximum concurrent requests to a single host")
+	fs.IntVar(&cfg.perHost, "workers-per-host", cfg.perHost, "same as -per-host")
+	fs.IntVar(&cfg.breakerThreshold, "breaker-threshold", cfg.breakerThreshold, "pause all requests after this many consecutive network errors or 5xx responses (0 to disable)")
+	fs.DurationVar(&cfg.breakerCooldown, "breaker-cooldown", cfg.breakerCooldown, "how long requests are paused for before a single probe request checks whether the site is back")
+	fs.StringVar(&cfg.proxyList, "proxy-list", cfg.proxyList, "file of proxy URLs, one per line, to rotate requests through; failing proxies are benched for a while")
+	fs.StringVar(&cfg.caFile, "ca-file", cfg.caFile, "PEM file of root certificates to trust besides the system's, e.g. an inspecting proxy's")
+	fs.Func("cookie", "cookie to send with every request, as name=value, for galleries behind a login (repeatable)", func(s string) error {
+		cfg.cookies = append(cfg.cookies, s)
+		return nil
+	})
+	fs.StringVar(&cfg.basicAuth, "basic-auth", cfg.basicAuth, "user:password to send with every request using HTTP basic authentication")
+	fs.BoolVar(&cfg.insecure, "insecure", cfg.insecure, "do not verify TLS certificates (dangerous)")
+}
+
+// outputFlags defines on fs the flags locating the downloads.
+func outputFlags(fs *flag.FlagSet, cfg *config) {
+	fs.StringVar(&cfg.output, "output", cfg.output, "directory, or s3://bucket/prefix, to download pictures to")
+	fs.StringVar(&cfg.stateDir, "state-dir", cfg.stateDir, "local directory for the state, caches and lock (default the output directory, or .grabber for s3 outputs)")
+}
+
+func cmdFetch(args []string) int {
+	cfg := defaultConfig()
+	fs := newFlagSet("fetch")
+	networkFlags(fs, &cfg)
+	outputFlags(fs, &cfg)
+	fs.Var(&cfg.bwLimit, "bwlimit", "limit total download bandwidth per second, e.g. 500KB or 1MB (0 for unlimited)")
+	fs.StringVar(&cfg.convert, "convert", cfg.convert, "convert downloaded images to png or jpeg")
+	fs.IntVar(&cfg.jpegQuality, "jpeg-quality", cfg.jpegQuality, "jpeg quality (1-100) used with -convert jpeg")
+	fs.StringVar(&cfg.stripPrefix, "strip-prefix", cfg.stripPrefix, "regular expression matching the start of captions to leave out of file names, e.g. 'The Mandalorian concept art:'")
+	fs.StringVar(&cfg.rendition, "rendition", cfg.rendition, "rendition to download when a gallery gives several: largest or smallest")
+	fs.StringVar(&cfg.contentDedup, "dedup-content", cfg.contentDedup, "handle byte-identical downloads: off, skip (delete the copy) or link (hard link to the original)")
+	fs.BoolVar(&cfg.watch, "watch", cfg.watch, "keep running, polling for new chapters and pictures every -poll-interval")
+	fs.DurationVar(&cfg.pollInterval, "poll-interval", cfg.pollInterval, "pause between polls with -watch")
+	fs.BoolVar(&cfg.headCheck, "head-check", cfg.headCheck, "only check that pictures can be downloaded, with HEAD requests, and report their total size")
+	fs.IntVar(&cfg.thumbs, "thumbs", cfg.thumbs, "also write JPEG thumbnails of at most this many pixels wide and high to thumbs/ (0 for none)")
+	fs.IntVar(&cfg.thumbs, "thumbnails", cfg.thumbs, "same as -thumbs")
+	fs.BoolVar(&cfg.strict, "strict", cfg.strict, "stop at the first gallery page that cannot be parsed, rather than reporting it at the end of the run")
+	fs.BoolVar(&cfg.interactive, "interactive", cfg.interactive, "list the pictures found by chapter and ask on standard input which to download")
+	fs.BoolVar(&cfg.stopOnFirstError, "stop-on-first-error", cfg.stopOnFirstError, "stop at the first gallery page or picture that fails to download or parse, and exit with that error")
+	fs.BoolVar(&cfg.tagMetadata, "tag-metadata", cfg.tagMetadata, "write each picture's caption, chapter and gallery into its EXIF (JPEG) or text chunks (PNG)")
+	fs.BoolVar(&cfg.asciiCaptions, "ascii-captions", cfg.asciiCaptions, "replace typographic quotes, dashes and ellipses in captions by ASCII, in file names and the manifest")
+	fs.BoolVar(&cfg.captions, "captions", cfg.captions, "write the full caption of each picture to a .txt file next to it")
+	fs.BoolVar(&cfg.ignoreState, "ignore-state", cfg.ignoreState, "download everything again, ignoring downloads recorded as complete")
+	fs.BoolVar(&cfg.waitLock, "wait-lock", cfg.waitLock, "wait for another run using the same output directory to finish instead of failing")
+	fs.StringVar(&cfg.groupBy, "group-by", cfg.groupBy, "subdirectories to store pictures in: none, chapter, gallery or first-letter (of the caption)")
+	fs.BoolVar(&cfg.mirror, "mirror", cfg.mirror, "remove pictures from the output that no gallery lists any more, if every gallery loaded")
+	fs.BoolVar(&cfg.mirrorDryRun, "mirror-dry-run", cfg.mirrorDryRun, "with -mirror, only list the pictures that would be removed")
+	fs.StringVar(&cfg.overwrite, "overwrite", cfg.overwrite, "when to replace a file already in the output: always, never, newer (remote Last-Modified is later) or larger (remote is bigger)")
+	fs.BoolVar(&cfg.fullRes, "full-res", cfg.fullRes, "download pictures at their original size instead of the gallery's rendition, when available")
+	fs.BoolVar(&cfg.checkImages, "check-images", cfg.checkImages, "check that downloaded pictures have a valid JPEG, PNG or WebP header and download them again if not")
+	fs.BoolVar(&cfg.verifyImages, "verify-images", cfg.verifyImages, "decode every downloaded picture and download it again if it is broken")
+	fs.IntVar(&cfg.verifyRetries, "verify-retries", cfg.verifyRetries, "how many more times to download a picture failing the image check")
+	fs.BoolVar(&cfg.sequentialChapters, "sequential-chapters", cfg.sequentialChapters, "download one chapter at a time, so that an interrupted run leaves whole chapters done")
+	fs.BoolVar(&cfg.ordered, "ordered", cfg.ordered, "parse every gallery first, then download pictures by chapter and gallery position")
+	fs.StringVar(&cfg.idsFile, "ids-file", cfg.idsFile, "only download the pictures whose IDs are listed in this file, one per line")
+	fs.StringVar(&cfg.excludeIDs, "exclude-ids", cfg.excludeIDs, "do not download the pictures whose IDs are listed in this file, one per line")
+	fs.IntVar(&cfg.maxPages, "max-pages", cfg.maxPages, "load at most this many pages of a paginated gallery (0 for no limit)")
+	fs.BoolVar(&cfg.story, "story", cfg.story, "also download the story gallery of each chapter, of episode stills")
+	fs.BoolVar(&cfg.trivia, "trivia", cfg.trivia, "also download the images of the trivia gallery of each chapter")
+	fs.BoolVar(&cfg.jsonl, "jsonl", cfg.jsonl, "write a JSON object to standard output for every picture downloaded, skipped or failed, as it happens")
+	fs.BoolVar(&cfg.videos, "videos", cfg.videos, "also download the mp4 video clips of galleries; HLS streams are skipped")
+	fs.BoolVar(&cfg.wayback, "wayback", cfg.wayback, "download galleries removed from the site from their most recent copy in the Wayback Machine")
+	fs.BoolVar(&cfg.keepDuplicates, "include-duplicates", cfg.keepDuplicates, "download pictures sharing an ID with one already seen instead of dropping them; they are still counted")
+	fs.DurationVar(&cfg.maxRetryWait, "max-retry-wait", cfg.maxRetryWait, "longest pause for a download answered with 429 or 503, whatever its Retry-After header asks")
+	fs.DurationVar(&cfg.retryDelay, "retry-delay", cfg.retryDelay, "pause before giving failed downloads a second try at the end of the run")
+	fs.BoolVar(&cfg.adaptive, "adaptive", cfg.adaptive, "lower download concurrency while the site returns 403, 429 or 5xx or slows down, and raise it back once healthy")
+	fs.DurationVar(&cfg.startJitter, "start-jitter", cfg.startJitter, "start each download worker after a random delay of up to this")
+	fs.DurationVar(&cfg.requestJitter, "request-jitter", cfg.requestJitter, "pause for a random delay of up to this before each download")
+	fs.Int64Var(&cfg.seed, "seed", cfg.seed, "seed for everything random, such as the delays, for reproducible runs (0 for a different one every run)")
+	fs.Int64Var(&cfg.seed, "jitter-seed", cfg.seed, "same as -seed (deprecated)")
+	fs.StringVar(&cfg.metricsAddr, "metrics-addr", cfg.metricsAddr, "serve Prometheus metrics at /metrics on this address, e.g. :9100")
+	fs.StringVar(&cfg.logFile, "log-file", cfg.logFile, "also append the log to this file, e.g. for scheduled runs")
+	fs.Var(&cfg.logMaxSize, "log-max-size", "rotate -log-file to a .1 file once it would grow past this, e.g. 10MB (0 for never)")
+	fs.BoolVar(&cfg.logFileOnly, "log-file-only", cfg.logFileOnly, "log to -log-file only, not to standard error")
+	fs.StringVar(&cfg.pprofAddr, "pprof", cfg.pprofAddr, "serve runtime profiles at /debug/pprof/ on this address while running, e.g. localhost:6060")
+	fs.StringVar(&cfg.metricsFile, "metrics-file", cfg.metricsFile, "write a Prometheus metrics snapshot to this file at the end of the run")
+	fs.IntVar(&cfg.segments, "segments", cfg.segments, "download large pictures in this many ranged requests at once, when the server accepts them (1 for a single request)")
+	fs.Var(&cfg.segmentThreshold, "segment-threshold", "download pictures of at least this size in -segments parts, e.g. 16MB")
+	fs.IntVar(&cfg.limit, "limit", cfg.limit, "stop after downloading this many pictures, e.g. to sample a few (0 for no limit)")
+	fs.Var(&cfg.maxTotal, "max-total", "stop starting downloads once this much has been written over the run, e.g. 1GB (0 for unlimited)")
+	fs.BoolVar(&cfg.maxTotalAbort, "max-total-abort", cfg.maxTotalAbort, "with -max-total, abort downloads in progress at the limit instead of letting them finish")
+	fs.Var(&cfg.minFree, "min-free", "stop before free space on the output's file system drops below this, e.g. 500MB (0 to disable)")
+	fs.Var(&cfg.dirMode, "dir-mode", "permissions, in octal, of directories created in a local output")
+	fs.Var(&cfg.fileMode, "file-mode", "permissions, in octal, of files written to a local output")
+	fs.BoolVar(&cfg.discover, "discover", cfg.discover, "also fetch the galleries of the selected chapters listed in the site's sitemap, such as renamed pages, and specials when every chapter is selected")
+	fs.StringVar(&cfg.discoverPattern, "discover-pattern", cfg.discoverPattern, "regular expression the gallery URLs of the sitemap must match with -discover (default the series' concept art galleries)")
+	fs.StringVar(&cfg.sitemapURL, "sitemap", cfg.sitemapURL, "sitemap or sitemap index to discover galleries in (default /sitemap.xml of -base-url)")
+	var sel chapterSelection
+	chapterFlags(fs, &sel)
+	parseArgs(fs, args)
+
+	if cfg.logFile != "" {
+		lf, err := openLogFile(cfg.logFile, int64(cfg.logMaxSize))
+		if err != nil {
+			log.Print(err)
+			return exitFailure
+		}
+		defer lf.Close()
+		if cfg.logFileOnly {
+			log.SetOutput(lf)
+		} else {
+			log.SetOutput(io.MultiWriter(os.Stderr, lf))
+		}
+	} else if cfg.logFileOnly {
+		log.Print("-log-file-only needs -log-file")
+		return exitFailure
+	}
+
+	if cfg.interactive && (cfg.watch || sel.list == "-") {
+		log.Print("-interactive cannot be used with -watch or -chapters -")
+		return exitFailure
+	}
+
+	cfg.allChapters = sel.all()
+	g, err := newGrabber(cfg)
+	if err != nil {
+		log.Print(err)
+		return exitFailure
+	}
+	chapters, err := sel.chapters(os.Stdin, g.series)
+	if err != nil {
+		log.Print(err)
+		return exitFailure
+	}
+
+	ctx, abort := context.WithCancel(context.Background())
+	defer abort()
+	work, stopWork := context.WithCancel(ctx)
+	defer stopWork()
+	go handleInterrupts(stopWork, abort)
+	if cfg.pprofAddr != "" {
+		servePprof(ctx, cfg.pprofAddr)
+	}
+	if sel.all() && cfg.detectMisses > 0 {
+		if chapters, err = g.withDetected(work, chapters); err != nil {
+			log.Print(err)
+			return exitFailure
+		}
+	}
+	if cfg.watch {
+		var last *grabber
+		last, err = watch(ctx, work, cfg, chapters)
+		if last != nil {
+			g = last
+		}
+	} else {
+		err = g.run(ctx, work, chapters)
+	}
+	if err != nil {
+		log.Print(err)
+	}
+	return g.exitCode(work, err)
+}
+
+func cmdList(args []string) int {
+	cfg := defaultConfig()
+	fs := newFlagSet("list")
+	networkFlags(fs, &cfg)
+	var sel chapterSelection
+	chapterFlags(fs, &sel)
+	parseArgs(fs, args)
+
+	g, err := newGrabber(cfg)
+	if err != nil {
+		log.Print(err)
+		return exitFailure
+	}
+	chapters, err := sel.chapters(os.Stdin, g.series)
+	if err != nil {
+		log.Print(err)
+		return exitFailure
+	}
+	ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt)
+	defer stop()
+	if sel.all() && cfg.detectMisses > 0 {
+		if chapters, err = g.withDetected(ctx, chapters); err != nil {
+			log.Print(err)
+			return exitFailure
+		}
+	}
+
+	status := exitOK
+	for _, l := range g.listChapters(ctx, chapters) {
+		switch {
+		case errors.Is(l.err, errNotFound):
+			fmt.Printf("chapter %2d  not found\n", l.chapter)
+		case l.err != nil:
+			fmt.Printf("chapter %2d  error: %v\n", l.chapter, l.err)
+			status = exitFailure
+		default:
+			fmt.Printf("chapter %2d  %3d pictures  %s\n", l.chapter, l.pictures, l.page)
+		}
+	}
+	if ctx.Err() != nil {
+		return exitInterrupted
+	}
+	return status
+}
+
+func cmdVerify(args []string) int {
+	cfg := defaultConfig()
+	fs := newFlagSet("verify")
+	outputFlags(fs, &cfg)
+	parseArgs(fs, args)
+
+	if !isLocalOutput(cfg.output) {
+		log.Printf("verify only supports local outputs")
+		return exitFailure
+	}
+	if cfg.stateDir == "" {
+		cfg.stateDir = cfg.output
+	}
+	done, err := readState(cfg.stateDir)
+	if err != nil {
+		log.Print(err)
+		return exitFailure
+	}
+	ids := make([]string, 0, len(done))
+	for id := range done {
+		ids = append(ids, id)
+	}
+	sort.Slice(ids, func(i, j int) bool { return done[ids[i]].File < done[ids[j]].File })
+
+	var ok, bad int
+	for _, id := range ids {
+		e := done[id]
+		if e.File == "" {
+			continue
+		}
+		if err := verifyFile(filepath.Join(cfg.output, filepath.FromSlash(e.File)), e); err != nil {
+			fmt.Printf("FAILED  %s: %v\n", e.File, err)
+			bad++
+			continue
+		}
+		ok++
+	}
+	fmt.Printf("%d files verified, %d failed\n", ok, bad)
+	if bad > 0 {
+		return exitFailure
+	}
+	return exitOK
+}
+
+// verifyFile checks that the file at path has the size and hash recorded in e.
+func verifyFile(path string, e stateEntry) error {
+	f, err := os.Open(path)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+	h := sha256.New()
+	n, err := io.Copy(h, f)
+	if err != nil {
+		return err
+	}
+	if n != e.Size {
+		return fmt.Errorf("size is %d, expected %d", n, e.Size)
+	}
+	if sum := hex.EncodeToString(h.Sum(nil)); sum != e.SHA256 {
+		return fmt.Errorf("sha256 is %s, expected %s", sum, e.SHA256)
+	}
+	return nil
+}
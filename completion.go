@@ -0,0 +1,83 @@
+package main
+
+import (
+	"log"
+	"sort"
+	"sync"
+)
+
+// completion tracks, for every picture queued for download, whether it ended
+// up in the output and, if not, why. It is safe for concurrent use.
+type completion struct {
+	mu      sync.Mutex
+	pics    map[string]Picture // by pictureKey
+	done    map[string]bool
+	lastErr map[string]error
+}
+
+func newCompletion() *completion {
+	return &completion{
+		pics:    make(map[string]Picture),
+		done:    make(map[string]bool),
+		lastErr: make(map[string]error),
+	}
+}
+
+// expect records that p is to be downloaded.
+func (c *completion) expect(p Picture) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.pics[pictureKey(p)] = p
+}
+
+// complete records that p is in the output, downloaded now or earlier.
+func (c *completion) complete(p Picture) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.done[pictureKey(p)] = true
+}
+
+// fail records err as the latest reason p is not in the output.
+func (c *completion) fail(p Picture, err error) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.lastErr[pictureKey(p)] = err
+}
+
+// report logs, per chapter, how many of the expected pictures are complete,
+// listing the missing ones with their last error.
+func (c *completion) report() {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	byChapter := make(map[int][]Picture)
+	var chapters []int
+	for _, p := range c.pics {
+		if _, ok := byChapter[p.Chapter]; !ok {
+			chapters = append(chapters, p.Chapter)
+		}
+		byChapter[p.Chapter] = append(byChapter[p.Chapter], p)
+	}
+	sort.Ints(chapters)
+	for _, chap := range chapters {
+		pics := byChapter[chap]
+		sort.Slice(pics, func(i, j int) bool { return picLess(pics[i], pics[j]) })
+		var missing []Picture
+		for _, p := range pics {
+			if !c.done[pictureKey(p)] {
+				missing = append(missing, p)
+			}
+		}
+		if len(missing) == 0 {
+			log.Printf("chapter %d: all %d pictures complete", chap, len(pics))
+			continue
+		}
+		log.Printf("chapter %d: %d of %d pictures complete, missing:", chap, len(pics)-len(missing), len(pics))
+		for _, p := range missing {
+			reason := "not attempted"
+			if err := c.lastErr[pictureKey(p)]; err != nil {
+				reason = err.Error()
+			}
+			log.Printf("  %s (%s): %s", p.ID, p.URL, reason)
+		}
+	}
+}
@@ -0,0 +1,42 @@
+package main
+
+import "sync"
+
+// conceptOrder learns which of the series' concept art URL templates
+// galleries are published under, so that the candidate URLs of later chapters
+// are tried most successful first and the URLs a series does not use are
+// rarely requested. The zero value tries candidates in template order. It is
+// safe for concurrent use.
+type conceptOrder struct {
+	mu   sync.Mutex
+	hits map[int]int // galleries found, by template
+}
+
+// order returns the indexes of the n candidate URLs of a concept art gallery,
+// one per template, in the order to try them.
+func (o *conceptOrder) order(n int) []int {
+	o.mu.Lock()
+	defer o.mu.Unlock()
+	idx := make([]int, n)
+	for i := range idx {
+		idx[i] = i
+	}
+	// Insertion sort, stable, by decreasing hits: n is the number of
+	// templates, which is small.
+	for i := 1; i < n; i++ {
+		for j := i; j > 0 && o.hits[idx[j]] > o.hits[idx[j-1]]; j-- {
+			idx[j], idx[j-1] = idx[j-1], idx[j]
+		}
+	}
+	return idx
+}
+
+// found records that a gallery was found under template i.
+func (o *conceptOrder) found(i int) {
+	o.mu.Lock()
+	defer o.mu.Unlock()
+	if o.hits == nil {
+		o.hits = make(map[int]int)
+	}
+	o.hits[i]++
+}
@@ -0,0 +1,73 @@
+package main
+
+import (
+	"bytes"
+	"fmt"
+	"image"
+	"image/jpeg"
+	"image/png"
+	"io"
+
+	_ "golang.org/x/image/webp" // register the webp decoder for image.Decode, also used by the image checks and thumbnails
+)
+
+// converter re-encodes downloaded images to a single format. The zero value
+// leaves images untouched.
+type converter struct {
+	format  string // "png", "jpeg", or empty for no conversion
+	quality int    // jpeg quality, 1-100
+}
+
+func newConverter(format string, quality int) (converter, error) {
+	switch format {
+	case "", "png", "jpeg":
+	case "jpg":
+		format = "jpeg"
+	default:
+		return converter{}, fmt.Errorf("unsupported conversion format %q", format)
+	}
+	if quality < 1 || quality > 100 {
+		return converter{}, fmt.Errorf("jpeg quality must be between 1 and 100, got %d", quality)
+	}
+	return converter{format: format, quality: quality}, nil
+}
+
+// ext returns the file extension for written images.
+func (c converter) ext() string {
+	if c.format == "png" {
+		return ".png"
+	}
+	return ".jpeg"
+}
+
+// convert copies the image in r to w, re-encoding it to the target format
+// unless it already is in that format. It returns the number of bytes read
+// from r.
+func (c converter) convert(w io.Writer, r io.Reader) (int64, error) {
+	if c.format == "" {
+		return io.Copy(w, r)
+	}
+	var buf bytes.Buffer
+	n, err := io.Copy(&buf, r)
+	if err != nil {
+		return n, err
+	}
+	img, format, err := image.Decode(bytes.NewReader(buf.Bytes()))
+	if err != nil {
+		return n, fmt.Errorf("decode image: %w", err)
+	}
+	if format == c.format {
+		_, err = buf.WriteTo(w)
+		return n, err
+	}
+	switch c.format {
+	case "png":
+		err = png.Encode(w, img)
+	case "jpeg":
+		err = jpeg.Encode(w, img, &jpeg.Options{Quality: c.quality})
+	}
+	if err != nil {
+		return n, fmt.Errorf("encode %s: %w", c.format, err)
+	}
+	return n, nil
+}
@@ -0,0 +1,43 @@
+package main
+
+import (
+	"bytes"
+	"image"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+var webpFixtures = []string{"pixel-lossless.webp", "pixel-lossy.webp"}
+
+func TestWebP(t *testing.T) {
+	for _, name := range webpFixtures {
+		t.Run(name, func(t *testing.T) {
+			b, err := os.ReadFile(filepath.Join("testdata", name))
+			if err != nil {
+				t.Fatal(err)
+			}
+			for _, full := range []bool{false, true} {
+				d := newDecodeCheck(full)
+				d.Write(b)
+				if err := d.result(); err != nil {
+					t.Errorf("image check (full %v): %v", full, err)
+				}
+			}
+			for _, format := range []string{"png", "jpeg"} {
+				c, err := newConverter(format, 90)
+				if err != nil {
+					t.Fatal(err)
+				}
+				var out bytes.Buffer
+				if _, err := c.convert(&out, bytes.NewReader(b)); err != nil {
+					t.Fatalf("converting to %s: %v", format, err)
+				}
+				cfg, got, err := image.DecodeConfig(&out)
+				if err != nil || got != format || cfg.Width != 1 || cfg.Height != 1 {
+					t.Errorf("converted to %s: got %s %dx%d, error %v", format, got, cfg.Width, cfg.Height, err)
+				}
+			}
+		})
+	}
+}
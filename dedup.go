@@ -0,0 +1,87 @@
+package main
+
+import (
+	"context"
+	"sync"
+	"sync/atomic"
+)
+
+// keySet records which keys have been seen. It is safe for concurrent use.
+type keySet struct {
+	mu   sync.Mutex
+	seen map[string]struct{}
+}
+
+// add records key and reports whether it had not been seen before.
+func (s *keySet) add(key string) bool {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if s.seen == nil {
+		s.seen = make(map[string]struct{})
+	}
+	if _, ok := s.seen[key]; ok {
+		return false
+	}
+	s.seen[key] = struct{}{}
+	return true
+}
+
+// has reports whether key has been seen.
+func (s *keySet) has(key string) bool {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	_, ok := s.seen[key]
+	return ok
+}
+
+// pictureKey identifies p by ID, falling back to URL when the ID is empty.
+// Pictures of story galleries are told apart from concept art sharing their
+// ID.
+func pictureKey(p Picture) string {
+	if p.ID == "" {
+		return "url:" + p.URL
+	}
+	return "id:" + stateKey(p)
+}
+
+// stateKey is what p is recorded under in the state file: its ID, prefixed by
+// the kind of gallery for other than concept art and by video for videos, so
+// that earlier state still applies.
+func stateKey(p Picture) string {
+	key := p.ID
+	if p.Kind != "" {
+		key = p.Kind + ":" + key
+	}
+	if p.Video {
+		key = "video:" + key
+	}
+	return key
+}
+
+// dedupPics forwards pictures from in to the returned channel, counting
+// repeats in the grabber's duplicates counter. Repeats are dropped unless
+// duplicates are to be kept.
+func (g *grabber) dedupPics(ctx context.Context, in <-chan Picture) <-chan Picture {
+	out := make(chan Picture, cap(in))
+	go func() {
+		defer close(out)
+		for p := range in {
+			if g.cfg.mirror {
+				g.expected.add(g.picName(p))
+			}
+			if !g.seen.add(pictureKey(p)) {
+				atomic.AddInt64(&g.duplicates, 1)
+				if !g.cfg.keepDuplicates {
+					continue
+				}
+			}
+			g.completion.expect(p)
+			select {
+			case out <- p:
+			case <-ctx.Done():
+				return
+			}
+		}
+	}()
+	return out
+}
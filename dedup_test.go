@@ -0,0 +1,86 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"reflect"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/z11i/mandalorian-art-grabber/grill"
+)
+
+func picsOf(pics ...Picture) <-chan Picture {
+	c := make(chan Picture, len(pics))
+	for _, p := range pics {
+		c <- p
+	}
+	close(c)
+	return c
+}
+
+func pic(id, url string) Picture {
+	return Picture{Picture: grill.Picture{ID: id, URL: url}}
+}
+
+func TestDedupPics(t *testing.T) {
+	in := []Picture{
+		pic("a", "https://example.com/a.jpg"),
+		pic("b", "https://example.com/b.jpg"),
+		pic("a", "https://example.com/a-copy.jpg"),
+		pic("", "https://example.com/c.jpg"),
+		pic("", "https://example.com/c.jpg"),
+		pic("", "https://example.com/d.jpg"),
+		{Picture: grill.Picture{ID: "a", URL: "https://example.com/a-story.jpg"}, Kind: "story"},
+	}
+	tests := []struct {
+		keep bool
+		want []string
+	}{
+		{false, []string{"a.jpg", "b.jpg", "c.jpg", "d.jpg", "a-story.jpg"}},
+		{true, []string{"a.jpg", "b.jpg", "a-copy.jpg", "c.jpg", "c.jpg", "d.jpg", "a-story.jpg"}},
+	}
+	for _, tt := range tests {
+		t.Run(fmt.Sprintf("keep=%v", tt.keep), func(t *testing.T) {
+			cfg := testConfig(t, "https://example.com")
+			cfg.keepDuplicates = tt.keep
+			g := newTestGrabber(t, cfg)
+			var got []string
+			for _, p := range collect(t, g.dedupPics(context.Background(), picsOf(in...)), 5*time.Second) {
+				got = append(got, p.URL[len("https://example.com/"):])
+			}
+			if !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("got %v, want %v", got, tt.want)
+			}
+			if n := atomic.LoadInt64(&g.duplicates); n != 2 {
+				t.Errorf("counted %d duplicates, want 2", n)
+			}
+		})
+	}
+}
+
+func TestKeySetConcurrent(t *testing.T) {
+	var s keySet
+	var added int64
+	var wg sync.WaitGroup
+	for i := 0; i < 8; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for k := 0; k < 100; k++ {
+				if s.add(fmt.Sprint(k)) {
+					atomic.AddInt64(&added, 1)
+				}
+			}
+		}()
+	}
+	wg.Wait()
+	if added != 100 {
+		t.Errorf("%d keys added, want 100", added)
+	}
+	if !s.has("42") || s.has("100") {
+		t.Error("has disagrees with what was added")
+	}
+}
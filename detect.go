@@ -0,0 +1,156 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log"
+	"net/http"
+	"time"
+
+	"github.com/z11i/mandalorian-art-grabber/grill"
+)
+
+// Probing for chapters whose gallery is failing for other reasons than not
+// being published.
+const (
+	probeRetries = 2
+	// maxProbeErrors is how many chapters in a row may fail to be probed
+	// before detection gives up.
+	maxProbeErrors = 3
+)
+
+// probeBackoff is the pause before probing a failing gallery again.
+var probeBackoff = 2 * time.Second
+
+// withDetected returns chapters followed by those past the last chapter of
+// the series whose gallery is already published, so that a new season is
+// fetched without waiting for the series to be updated. It logs the
+// resulting range.
+func (g *grabber) withDetected(ctx context.Context, chapters []int) ([]int, error) {
+	detected, err := g.detectChapters(ctx)
+	if err != nil {
+		return nil, err
+	}
+	chapters = append(chapters, detected...)
+	if len(chapters) > 0 {
+		log.Printf("chapters %d-%d of %s (%d past its last known chapter)",
+			chapters[0], chapters[len(chapters)-1], g.series.Name, len(detected))
+	}
+	return chapters, nil
+}
+
+// detectChapters probes the concept art galleries of the chapters following
+// the last one of the series, until -detect-misses of them in a row are not
+// published, and returns the chapters up to the last one found. A chapter
+// whose gallery fails for another reason is counted as found rather than
+// ending the range, so that a transient error does not hide the chapters
+// after it; the run then reports the failure. If maxProbeErrors chapters in a
+// row fail, the site is taken to be down and an error is returned.
+func (g *grabber) detectChapters(ctx context.Context) ([]int, error) {
+	var chapters []int
+	last := g.series.Last
+	for chap, misses, errs := g.series.Last+1, 0, 0; misses < g.cfg.detectMisses && ctx.Err() == nil; chap++ {
+		found, err := g.probeChapter(ctx, chap)
+		switch {
+		case ctx.Err() != nil:
+			return chapters, nil
+		case err != nil:
+			if errs++; errs >= maxProbeErrors {
+				return nil, fmt.Errorf("unable to detect the chapters of %s: %d chapters in a row failed, the last with: %w", g.series.Name, errs, err)
+			}
+			log.Printf("warning: unable to tell whether chapter %d is published, including it: %v", chap, err)
+			found = true
+		default:
+			errs = 0
+			if !found {
+				g.debugf("chapter %d is not published", chap)
+			}
+		}
+		if !found {
+			misses++
+			continue
+		}
+		misses = 0
+		for c := last + 1; c <= chap; c++ {
+			chapters = append(chapters, c)
+		}
+		last = chap
+	}
+	return chapters, nil
+}
+
+// probeChapter reports whether a concept art gallery of chapter is published
+// at any of its URLs, retrying requests that fail for other reasons.
+func (g *grabber) probeChapter(ctx context.Context, chapter int) (bool, error) {
+	var lastErr error
+	for _, t := range g.series.Concept {
+		url := galleryURL(g.cfg.baseURL, t, chapter)
+		var err error
+		for attempt := 0; attempt <= probeRetries; attempt++ {
+			if attempt > 0 {
+				select {
+				case <-ctx.Done():
+					return false, ctx.Err()
+				case <-time.After(probeBackoff):
+				}
+			}
+			var found bool
+			if found, err = g.probeGallery(ctx, url); err == nil {
+				if found {
+					return true, nil
+				}
+				break
+			}
+		}
+		if err != nil {
+			lastErr = err
+		}
+	}
+	return false, lastErr
+}
+
+// probeGallery reports whether the gallery at url is published. A HEAD
+// request settles it when the page does not exist; otherwise the page is
+// read, as the site also serves its error page with a 200.
+func (g *grabber) probeGallery(ctx context.Context, url string) (bool, error) {
+	ctx, cancel := itemContext(ctx, g.cfg.galleryTimeout)
+	defer cancel()
+	status, err := g.probeStatus(ctx, http.MethodHead, url)
+	if err != nil {
+		return false, err
+	}
+	switch {
+	case status == http.StatusNotFound || status == http.StatusGone:
+		return false, nil
+	case status >= 300 && status != http.StatusMethodNotAllowed && status != http.StatusNotImplemented:
+		return false, fmt.Errorf("unexpected status %d for %s", status, url)
+	}
+	doc, err := g.fetcher.Fetch(ctx, url)
+	switch {
+	case errors.Is(err, errNotFound):
+		return false, nil
+	case err != nil:
+		return false, err
+	}
+	return !grill.IsErrorPage(doc), nil
+}
+
+// probeStatus requests url with method, returning the status of the response.
+func (g *grabber) probeStatus(ctx context.Context, method, url string) (int, error) {
+	req, err := http.NewRequestWithContext(ctx, method, url, nil)
+	if err != nil {
+		return 0, fmt.Errorf("error creating request: %w", err)
+	}
+	req.Header.Set("Accept-Encoding", acceptEncoding)
+	var status int
+	err = httpDo(ctx, g.client, req, func(resp *http.Response, err error) error {
+		if err != nil {
+			return err
+		}
+		defer resp.Body.Close()
+		status = resp.StatusCode
+		return nil
+	})
+	return status, err
+}
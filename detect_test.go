@@ -0,0 +1,97 @@
+package main
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"reflect"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+func TestProbeGallery(t *testing.T) {
+	site := newFakeSite(t)
+	site.gallery("/published", fakeImage{"a", "A"})
+	site.page("/error-page", errorPageHTML, http.StatusOK)
+	site.page("/server-error", galleryHTML(site.URL, []fakeImage{{"a", "A"}}), http.StatusInternalServerError)
+	site.page("/gone", galleryHTML(site.URL, []fakeImage{{"a", "A"}}), http.StatusGone)
+	down := httptest.NewServer(http.NotFoundHandler())
+	down.Close()
+
+	cfg := testConfig(t, site.URL)
+	cfg.breakerThreshold = 0
+	g := newTestGrabber(t, cfg)
+	tests := []struct {
+		url     string
+		want    bool
+		wantErr bool
+	}{
+		{site.URL + "/published", true, false},
+		{site.URL + "/error-page", false, false},
+		{site.URL + "/missing", false, false},
+		{site.URL + "/gone", false, false},
+		{site.URL + "/server-error", false, true},
+		{down.URL + "/published", false, true},
+	}
+	for _, tt := range tests {
+		found, err := g.probeGallery(context.Background(), tt.url)
+		if found != tt.want || (err != nil) != tt.wantErr {
+			t.Errorf("probeGallery(%s) = %v, %v, want %v, error %v", tt.url, found, err, tt.want, tt.wantErr)
+		}
+	}
+}
+
+func TestDetectChapters(t *testing.T) {
+	site := newFakeSite(t)
+	for _, c := range []int{17, 18, 20} {
+		site.gallery(conceptPath(c, c == 18), fakeImage{"a", "A"})
+	}
+	// Chapter 21 is failing, which does not end the range.
+	site.page(conceptPath(21, false), galleryHTML(site.URL, []fakeImage{{"a", "A"}}), http.StatusBadGateway)
+	defer func(d time.Duration) { probeBackoff = d }(probeBackoff)
+	probeBackoff = 0
+	cfg := testConfig(t, site.URL)
+	cfg.breakerThreshold = 0
+	g := newTestGrabber(t, cfg)
+
+	got, err := g.detectChapters(context.Background())
+	if err != nil {
+		t.Fatal(err)
+	}
+	if want := []int{17, 18, 19, 20, 21}; !reflect.DeepEqual(got, want) {
+		t.Errorf("detected %v, want %v", got, want)
+	}
+}
+
+func TestDetectChaptersSiteDown(t *testing.T) {
+	var requests int64
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		atomic.AddInt64(&requests, 1)
+		w.WriteHeader(http.StatusServiceUnavailable)
+	}))
+	defer srv.Close()
+	defer func(d time.Duration) { probeBackoff = d }(probeBackoff)
+	probeBackoff = 0
+	cfg := testConfig(t, srv.URL)
+	cfg.breakerThreshold = 0
+	g := newTestGrabber(t, cfg)
+
+	done := make(chan error, 1)
+	go func() {
+		_, err := g.detectChapters(context.Background())
+		done <- err
+	}()
+	select {
+	case err := <-done:
+		if err == nil {
+			t.Error("no error while every probe fails")
+		}
+	case <-time.After(10 * time.Second):
+		t.Fatalf("detection still running after %d requests", atomic.LoadInt64(&requests))
+	}
+	// Every URL of each chapter is tried, retries included.
+	if want := int64(maxProbeErrors * len(g.series.Concept) * (probeRetries + 1)); requests != want {
+		t.Errorf("%d requests, want %d", requests, want)
+	}
+}
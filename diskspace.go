@@ -0,0 +1,55 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"sync"
+	"time"
+)
+
+// diskCheckInterval is how often free space is checked again while
+// downloading.
+const diskCheckInterval = 10 * time.Second
+
+// errDiskFreeUnsupported is returned by diskFree on platforms where free
+// space cannot be determined.
+var errDiskFreeUnsupported = errors.New("free disk space cannot be determined on this platform")
+
+// errLowDiskSpace is returned when the output runs out of free space.
+var errLowDiskSpace = errors.New("not enough free disk space")
+
+// diskGuard checks that the file system of a directory keeps a minimum of
+// free space. It is safe for concurrent use.
+type diskGuard struct {
+	dir     string
+	minFree uint64
+
+	mu   sync.Mutex
+	last time.Time
+}
+
+// check returns an error wrapping errLowDiskSpace if free space is below the
+// minimum. Unless force is set, it only looks again once diskCheckInterval
+// has passed since the last check.
+func (d *diskGuard) check(force bool) error {
+	if d == nil {
+		return nil
+	}
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	if !force && time.Since(d.last) < diskCheckInterval {
+		return nil
+	}
+	d.last = time.Now()
+	free, err := diskFree(d.dir)
+	if errors.Is(err, errDiskFreeUnsupported) {
+		return nil
+	}
+	if err != nil {
+		return fmt.Errorf("unable to check free disk space: %w", err)
+	}
+	if free < d.minFree {
+		return fmt.Errorf("%w: %s has %d bytes free, below the minimum of %d", errLowDiskSpace, d.dir, free, d.minFree)
+	}
+	return nil
+}
@@ -0,0 +1,41 @@
+package main
+
+import (
+	"bufio"
+	"compress/flate"
+	"compress/gzip"
+	"compress/zlib"
+	"fmt"
+	"io"
+	"net/http"
+	"strings"
+)
+
+// acceptEncoding is asked for on gallery requests. Setting it ourselves turns
+// off the transport's transparent gzip decompression, so responses must be
+// read through decodedBody.
+const acceptEncoding = "gzip, deflate"
+
+// decodedBody returns the body of resp decompressed according to its
+// Content-Encoding, whether the server compressed it or not.
+func decodedBody(resp *http.Response) (io.Reader, error) {
+	if resp.Uncompressed {
+		// Already decompressed by the transport.
+		return resp.Body, nil
+	}
+	switch enc := strings.ToLower(strings.TrimSpace(resp.Header.Get("Content-Encoding"))); enc {
+	case "", "identity":
+		return resp.Body, nil
+	case "gzip", "x-gzip":
+		return gzip.NewReader(resp.Body)
+	case "deflate":
+		// Deflate is meant to be zlib wrapped, but some servers send it raw.
+		br := bufio.NewReader(resp.Body)
+		if h, err := br.Peek(2); err == nil && h[0]&0x0f == 8 && (int(h[0])<<8|int(h[1]))%31 == 0 {
+			return zlib.NewReader(br)
+		}
+		return flate.NewReader(br), nil
+	default:
+		return nil, fmt.Errorf("unsupported content encoding %q", enc)
+	}
+}
@@ -0,0 +1,67 @@
+package main
+
+import (
+	"bytes"
+	"compress/flate"
+	"compress/gzip"
+	"compress/zlib"
+	"context"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+)
+
+func TestLoadGalleryEncoded(t *testing.T) {
+	encoders := map[string]func(io.Writer) io.WriteCloser{
+		"gzip":   func(w io.Writer) io.WriteCloser { return gzip.NewWriter(w) },
+		"x-gzip": func(w io.Writer) io.WriteCloser { return gzip.NewWriter(w) },
+		"deflate": func(w io.Writer) io.WriteCloser {
+			return zlib.NewWriter(w)
+		},
+		"raw deflate": func(w io.Writer) io.WriteCloser {
+			fw, _ := flate.NewWriter(w, flate.DefaultCompression)
+			return fw
+		},
+	}
+	for name, encode := range encoders {
+		t.Run(name, func(t *testing.T) {
+			var accepted string
+			srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+				accepted = r.Header.Get("Accept-Encoding")
+				var body bytes.Buffer
+				zw := encode(&body)
+				io.WriteString(zw, galleryHTML("https://example.com", []fakeImage{{"a", "A"}, {"b", "B"}}))
+				zw.Close()
+				enc := name
+				if enc == "raw deflate" {
+					enc = "deflate"
+				}
+				w.Header().Set("Content-Encoding", enc)
+				w.Write(body.Bytes())
+			}))
+			defer srv.Close()
+			g := newTestGrabber(t, testConfig(t, srv.URL))
+			pics, _, err := g.loadGallery(context.Background(), srv.URL+"/gallery")
+			if err != nil {
+				t.Fatal(err)
+			}
+			if len(pics) != 2 {
+				t.Errorf("got %d pictures, want 2", len(pics))
+			}
+			if accepted != acceptEncoding {
+				t.Errorf("Accept-Encoding %q, want %q", accepted, acceptEncoding)
+			}
+		})
+	}
+
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Encoding", "br")
+		io.WriteString(w, "\x1b\x00")
+	}))
+	defer srv.Close()
+	g := newTestGrabber(t, testConfig(t, srv.URL))
+	if _, _, err := g.loadGallery(context.Background(), srv.URL+"/gallery"); err == nil {
+		t.Error("unsupported content encoding accepted")
+	}
+}
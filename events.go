@@ -0,0 +1,73 @@
+package main
+
+import (
+	"encoding/json"
+	"io"
+	"log"
+)
+
+// Statuses of picture events.
+const (
+	eventDownloaded = "downloaded"
+	eventSkipped    = "skipped"
+	eventFailed     = "failed"
+)
+
+// event reports what became of a picture, for -jsonl.
+type event struct {
+	ID      string `json:"id"`
+	Caption string `json:"caption"`
+	URL     string `json:"url"`
+	Path    string `json:"path,omitempty"` // where the picture is stored
+	Status  string `json:"status"`
+	Bytes   int64  `json:"bytes,omitempty"`
+	Error   string `json:"error,omitempty"`
+}
+
+// eventStream writes events to a writer as JSON Lines, in the order they are
+// sent, from a goroutine of its own. A nil stream discards events.
+type eventStream struct {
+	events chan event
+	done   chan struct{}
+}
+
+func newEventStream(w io.Writer) *eventStream {
+	s := &eventStream{events: make(chan event, worker*picsPerWorker), done: make(chan struct{})}
+	go func() {
+		defer close(s.done)
+		enc := json.NewEncoder(w)
+		failed := false
+		for e := range s.events {
+			if failed {
+				continue
+			}
+			if err := enc.Encode(e); err != nil {
+				log.Printf("unable to write events, no more will be written: %v", err)
+				failed = true
+			}
+		}
+	}()
+	return s
+}
+
+// send queues an event for p with status. err is the reason it failed.
+func (s *eventStream) send(p Picture, status, path string, bytes int64, err error) {
+	if s == nil {
+		return
+	}
+	e := event{ID: p.ID, Caption: p.Caption, URL: p.URL, Path: path, Status: status, Bytes: bytes}
+	if err != nil {
+		e.Error = err.Error()
+	}
+	s.events <- e
+}
+
+// close waits for the queued events to be written. send must not be called
+// afterwards.
+func (s *eventStream) close() {
+	if s == nil {
+		return
+	}
+	close(s.events)
+	<-s.done
+}
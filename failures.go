@@ -0,0 +1,92 @@
+package main
+
+import (
+	"context"
+	"log"
+	"sync"
+	"time"
+)
+
+type failure struct {
+	pic Picture
+	err error
+}
+
+// failureLog collects the pictures that failed to download. It is safe for
+// concurrent use.
+type failureLog struct {
+	mu       sync.Mutex
+	failures []failure
+	final    []failure // failed again when retried
+}
+
+func (l *failureLog) add(p Picture, err error) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	l.failures = append(l.failures, failure{pic: p, err: err})
+}
+
+// list returns the collected failures, including final ones.
+func (l *failureLog) list() []failure {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	return append(append([]failure(nil), l.final...), l.failures...)
+}
+
+// pending returns the failures not yet marked final.
+func (l *failureLog) pending() int {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	return len(l.failures)
+}
+
+// finalize marks the collected failures final, so that they are not drained
+// again.
+func (l *failureLog) finalize() []failure {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	f := l.failures
+	l.final = append(l.final, f...)
+	l.failures = nil
+	return f
+}
+
+// drain returns the failures not marked final and removes them from the log.
+func (l *failureLog) drain() []failure {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	f := l.failures
+	l.failures = nil
+	return f
+}
+
+// retryFailed waits for the retry delay and then makes one more attempt at
+// every failed download, logging which were recovered and which failed for
+// good. Nothing is retried once work is done. Downloads still failing are
+// left in the failure log as final, so that later calls do not retry them.
+func (g *grabber) retryFailed(ctx, work context.Context) {
+	if g.failed.pending() == 0 || work.Err() != nil {
+		return
+	}
+	log.Printf("retrying %d failed downloads in %v", g.failed.pending(), g.cfg.retryDelay)
+	select {
+	case <-work.Done():
+		// The failures stay in the log, to be reported.
+		return
+	case <-time.After(g.cfg.retryDelay):
+	}
+	failed := g.failed.drain()
+
+	pics := make(chan Picture, len(failed))
+	for _, f := range failed {
+		pics <- f.pic
+	}
+	close(pics)
+	g.downloadAll(ctx, work, pics)
+
+	permanent := g.failed.finalize()
+	log.Printf("recovered on second pass: %d, permanently failed: %d", len(failed)-len(permanent), len(permanent))
+	for _, f := range permanent {
+		log.Printf("failed: %s: %v", f.pic.URL, f.err)
+	}
+}
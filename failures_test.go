@@ -0,0 +1,32 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"testing"
+	"time"
+)
+
+func TestRetryFailedCancelledDuringDelay(t *testing.T) {
+	cfg := testConfig(t, "https://www.starwars.com")
+	cfg.retryDelay = time.Hour
+	g := newTestGrabber(t, cfg)
+	g.failed.add(pic("a", "https://example.com/a.jpeg"), errors.New("unexpected status 500"))
+	g.failed.add(pic("b", "https://example.com/b.jpeg"), errors.New("unexpected status 503"))
+
+	work, stop := context.WithCancel(context.Background())
+	time.AfterFunc(10*time.Millisecond, stop)
+	done := make(chan struct{})
+	go func() {
+		g.retryFailed(context.Background(), work)
+		close(done)
+	}()
+	select {
+	case <-done:
+	case <-time.After(5 * time.Second):
+		t.Fatal("retryFailed still waiting after work was stopped")
+	}
+	if n := len(g.failed.list()); n != 2 {
+		t.Errorf("%d failures left after stopping during the retry delay, want 2", n)
+	}
+}
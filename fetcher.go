@@ -0,0 +1,105 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"io"
+	"net/http"
+
+	"github.com/z11i/mandalorian-art-grabber/grill"
+	"golang.org/x/net/html"
+)
+
+// Fetcher fetches and parses the HTML page at a URL. It returns errNotFound
+// if there is no page there.
+type Fetcher interface {
+	Fetch(ctx context.Context, url string) (*html.Node, error)
+}
+
+// Page is a page fetched by a PageFetcher, with what the gallery stage needs
+// of the response it came with.
+type Page struct {
+	URL        string   // final URL, after redirects
+	Redirects  []string // URLs requested to get the page, oldest first
+	StatusCode int
+	Status     string
+	Header     http.Header
+	Body       []byte // decoded from its content encoding
+}
+
+// PageFetcher is a Fetcher that can also return a page whatever its status,
+// along with its response, for conditional requests and caching. header is
+// sent with the request.
+type PageFetcher interface {
+	Fetcher
+	FetchPage(ctx context.Context, url string, header http.Header) (*Page, error)
+}
+
+// httpFetcher is the PageFetcher of pages of the site, requested with client.
+type httpFetcher struct {
+	client *http.Client
+}
+
+func (f httpFetcher) Fetch(ctx context.Context, url string) (*html.Node, error) {
+	return fetchNode(ctx, f, url)
+}
+
+func (f httpFetcher) FetchPage(ctx context.Context, url string, header http.Header) (*Page, error) {
+	req, err := http.NewRequest(http.MethodGet, url, nil)
+	if err != nil {
+		return nil, fmt.Errorf("error creating request: %w", err)
+	}
+	for k, v := range header {
+		req.Header[k] = v
+	}
+	req.Header.Set("Accept-Encoding", acceptEncoding)
+	var page *Page
+	err = httpDo(ctx, f.client, req, func(resp *http.Response, err error) error {
+		if err != nil {
+			return err
+		}
+		defer resp.Body.Close()
+		body, err := decodedBody(resp)
+		if err != nil {
+			return fmt.Errorf("unable to decode %s: %w", url, err)
+		}
+		b, err := io.ReadAll(body)
+		if err != nil {
+			return err
+		}
+		page = &Page{URL: resp.Request.URL.String(), Redirects: redirectChain(resp), StatusCode: resp.StatusCode, Status: resp.Status, Header: resp.Header, Body: b}
+		return nil
+	})
+	return page, err
+}
+
+// fetchNode fetches the page at url with f and parses it, returning
+// errNotFound if there is none.
+func fetchNode(ctx context.Context, f PageFetcher, url string) (*html.Node, error) {
+	page, err := f.FetchPage(ctx, url, nil)
+	if err != nil {
+		return nil, err
+	}
+	switch {
+	case page.StatusCode == http.StatusNotFound || page.StatusCode == http.StatusGone:
+		return nil, errNotFound
+	case page.StatusCode != http.StatusOK:
+		return nil, fmt.Errorf("unexpected status %d for %s", page.StatusCode, url)
+	}
+	return html.Parse(bytes.NewReader(page.Body))
+}
+
+// parseGalleryPage returns the pictures of the gallery page doc and the link
+// to the next page of the gallery, if it has several.
+func parseGalleryPage(doc *html.Node) ([]Picture, string, error) {
+	parsed, err := grill.ParseNode(doc)
+	if err != nil {
+		return nil, "", err
+	}
+	pics := make([]Picture, len(parsed))
+	for i, p := range parsed {
+		pics[i] = Picture{Picture: p}
+	}
+	return pics, nextPage(doc), nil
+}
@@ -0,0 +1,148 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"sync/atomic"
+	"testing"
+
+	"golang.org/x/net/html"
+)
+
+// fakeFetcher is a PageFetcher serving the pages saved in grill/testdata, by
+// URL. URLs it has no page for answer with a 404.
+type fakeFetcher struct {
+	pages map[string]string // file name by URL
+	err   error             // returned for every URL instead, if set
+}
+
+func (f fakeFetcher) Fetch(ctx context.Context, url string) (*html.Node, error) {
+	return fetchNode(ctx, f, url)
+}
+
+func (f fakeFetcher) FetchPage(ctx context.Context, url string, header http.Header) (*Page, error) {
+	if f.err != nil {
+		return nil, f.err
+	}
+	name, ok := f.pages[url]
+	if !ok {
+		return &Page{URL: url, StatusCode: http.StatusNotFound, Status: "404 Not Found", Header: http.Header{}}, nil
+	}
+	b, err := os.ReadFile(filepath.Join("grill", "testdata", name))
+	if err != nil {
+		return nil, err
+	}
+	return &Page{URL: url, StatusCode: http.StatusOK, Status: "200 OK", Header: http.Header{}, Body: b}, nil
+}
+
+func TestParseGalleryPageFixture(t *testing.T) {
+	const url = "https://www.starwars.com/series/the-mandalorian/chapter-1-concept-art-gallery"
+	var f Fetcher = fakeFetcher{pages: map[string]string{url: "concept.html"}}
+	doc, err := f.Fetch(context.Background(), url)
+	if err != nil {
+		t.Fatal(err)
+	}
+	pics, next, err := parseGalleryPage(doc)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(pics) != 4 || next != "" {
+		t.Errorf("got %d pictures and next page %q, want 4 and none", len(pics), next)
+	}
+}
+
+func TestProbeGalleryFetcher(t *testing.T) {
+	// Every page exists as far as HEAD requests go; the page tells.
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {}))
+	defer srv.Close()
+	g := newTestGrabber(t, testConfig(t, srv.URL))
+	broken := errors.New("connection reset")
+	tests := []struct {
+		fetcher fakeFetcher
+		want    bool
+		wantErr error
+	}{
+		{fakeFetcher{pages: map[string]string{srv.URL + "/gallery": "concept.html"}}, true, nil},
+		{fakeFetcher{pages: map[string]string{srv.URL + "/gallery": "error_page.html"}}, false, nil},
+		{fakeFetcher{}, false, nil},
+		{fakeFetcher{err: broken}, false, broken},
+	}
+	for _, tt := range tests {
+		g.fetcher = tt.fetcher
+		found, err := g.probeGallery(context.Background(), srv.URL+"/gallery")
+		if found != tt.want || !errors.Is(err, tt.wantErr) {
+			t.Errorf("%+v: got %v, %v, want %v, %v", tt.fetcher, found, err, tt.want, tt.wantErr)
+		}
+	}
+}
+
+func TestLoadGalleryFetcher(t *testing.T) {
+	const url = "https://www.starwars.com/series/the-mandalorian/chapter-1-concept-art-gallery"
+	g := newTestGrabber(t, testConfig(t, "https://www.starwars.com"))
+	g.fetcher = fakeFetcher{pages: map[string]string{url: "concept.html"}}
+	pics, next, err := g.loadGallery(context.Background(), url)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(pics) != 4 || next != "" {
+		t.Fatalf("got %d pictures and next page %q, want 4 and none", len(pics), next)
+	}
+	for i, p := range pics {
+		if p.Page != url || p.Index != i {
+			t.Errorf("picture %s on page %s at %d, want %s at %d", p.ID, p.Page, p.Index, url, i)
+		}
+	}
+	// The page was loaded already.
+	if pics, _, err := g.loadGallery(context.Background(), url); err != nil || len(pics) != 0 {
+		t.Errorf("loading again: got %d pictures, %v, want none", len(pics), err)
+	}
+}
+
+func TestFetchChapterFetcher(t *testing.T) {
+	const (
+		first  = "https://www.starwars.com/series/the-mandalorian/chapter-1-concept-art-gallery"
+		second = "https://www.starwars.com/chapter-1-concept-art-gallery"
+	)
+	broken := errors.New("connection reset")
+	tests := []struct {
+		name       string
+		fetcher    fakeFetcher
+		pictures   int
+		missing    int64
+		failedPage int64
+	}{
+		{"first url", fakeFetcher{pages: map[string]string{first: "concept.html"}}, 4, 0, 0},
+		{"second url", fakeFetcher{pages: map[string]string{second: "grid.html"}}, 2, 0, 0},
+		{"error page", fakeFetcher{pages: map[string]string{first: "error_page.html"}}, 0, 1, 0},
+		{"no gallery", fakeFetcher{}, 0, 1, 0},
+		{"malformed", fakeFetcher{pages: map[string]string{first: "malformed.html"}}, 0, 0, 1},
+		{"request failing", fakeFetcher{err: broken}, 0, 0, 1},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			g := newTestGrabber(t, testConfig(t, "https://www.starwars.com"))
+			g.fetcher = tt.fetcher
+			picChan := make(chan Picture, 10)
+			g.fetchChapter(context.Background(), gallery{chapter: 1, urls: []string{first, second}}, picChan)
+			close(picChan)
+			if n := len(picChan); n != tt.pictures {
+				t.Errorf("got %d pictures, want %d", n, tt.pictures)
+			}
+			for p := range picChan {
+				if p.Chapter != 1 {
+					t.Errorf("picture %s of chapter %d, want 1", p.ID, p.Chapter)
+				}
+			}
+			if n := atomic.LoadInt64(&g.missing); n != tt.missing {
+				t.Errorf("%d chapters missing, want %d", n, tt.missing)
+			}
+			if n := atomic.LoadInt64(&g.stats.galleryErrors); n != tt.failedPage {
+				t.Errorf("%d gallery errors, want %d", n, tt.failedPage)
+			}
+		})
+	}
+}
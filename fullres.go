@@ -0,0 +1,38 @@
+package main
+
+import "net/url"
+
+// resizeParams are the query parameters the image CDN resizes renditions by.
+// Without them it serves the image at its original size.
+var resizeParams = []string{"width", "height"}
+
+// fullResURL returns the URL of the largest variant of the image at raw, such
+// as
+//
+//	https://lumiere-a.akamaihd.net/v1/images/concept-01_a1b2c3d4.jpeg?region=0%2C0%2C1920%2C1080
+//
+// for
+//
+//	https://lumiere-a.akamaihd.net/v1/images/concept-01_a1b2c3d4.jpeg?region=0%2C0%2C1920%2C1080&width=768
+//
+// Crop regions are kept. raw is returned unchanged if it is not resized or
+// cannot be parsed.
+func fullResURL(raw string) string {
+	u, err := url.Parse(raw)
+	if err != nil || u.RawQuery == "" {
+		return raw
+	}
+	q := u.Query()
+	resized := false
+	for _, k := range resizeParams {
+		if _, ok := q[k]; ok {
+			q.Del(k)
+			resized = true
+		}
+	}
+	if !resized {
+		return raw
+	}
+	u.RawQuery = q.Encode()
+	return u.String()
+}
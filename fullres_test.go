@@ -0,0 +1,72 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func TestFullResURL(t *testing.T) {
+	const base = "https://lumiere-a.akamaihd.net/v1/images/concept-01_a1b2c3d4.jpeg"
+	tests := []struct {
+		raw, want string
+	}{
+		{base + "?region=0%2C0%2C1920%2C1080&width=768", base + "?region=0%2C0%2C1920%2C1080"},
+		{base + "?width=768&height=432", base},
+		{base + "?height=432", base},
+		{base + "?region=0%2C0%2C1920%2C1080", base + "?region=0%2C0%2C1920%2C1080"},
+		{base, base},
+		{"%zz?width=1", "%zz?width=1"},
+	}
+	for _, tt := range tests {
+		if got := fullResURL(tt.raw); got != tt.want {
+			t.Errorf("fullResURL(%q) = %q, want %q", tt.raw, got, tt.want)
+		}
+	}
+}
+
+func TestSavePicFullRes(t *testing.T) {
+	for _, hasFull := range []bool{true, false} {
+		var requested []string
+		srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			requested = append(requested, r.URL.RequestURI())
+			if r.URL.Query().Get("width") == "" {
+				if !hasFull {
+					http.NotFound(w, r)
+					return
+				}
+				w.Write(fakeImageData("full"))
+				return
+			}
+			w.Write(fakeImageData("rendition"))
+		}))
+		cfg := testConfig(t, srv.URL)
+		cfg.fullRes = true
+		g := newTestGrabber(t, cfg)
+		g.state = openState(cfg.stateDir, false)
+		p := pic("a", srv.URL+"/a.png?width=768")
+
+		if err := g.savePic(context.Background(), p); err != nil {
+			t.Fatalf("full resolution %v: %v", hasFull, err)
+		}
+		got, err := os.ReadFile(filepath.Join(cfg.output, filepath.FromSlash(g.picName(p))))
+		if err != nil {
+			t.Fatal(err)
+		}
+		want, wantRequests := fakeImageData("full"), 1
+		if !hasFull {
+			want, wantRequests = fakeImageData("rendition"), 2
+		}
+		if !bytes.Equal(got, want) {
+			t.Errorf("full resolution %v: wrong picture downloaded", hasFull)
+		}
+		if len(requested) != wantRequests || requested[0] != "/a.png" {
+			t.Errorf("full resolution %v: requested %v", hasFull, requested)
+		}
+		srv.Close()
+	}
+}
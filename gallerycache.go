@@ -0,0 +1,103 @@
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"log"
+	"net/http"
+	"os"
+	"path/filepath"
+	"sync"
+)
+
+// galleryCacheFile is the name of the file in the state directory caching
+// the validators and pictures of fetched gallery pages.
+const galleryCacheFile = ".gallery-cache.json"
+
+type galleryCacheEntry struct {
+	ETag         string    `json:"etag,omitempty"`
+	LastModified string    `json:"last_modified,omitempty"`
+	Pictures     []Picture `json:"pictures"`
+	Next         string    `json:"next,omitempty"` // next page of the gallery
+}
+
+// galleryCache remembers, per gallery URL, the response validators and the
+// pictures parsed from it, so that unchanged pages can be fetched with a
+// conditional GET and not parsed again. It is safe for concurrent use.
+type galleryCache struct {
+	mu      sync.Mutex
+	dir     string
+	entries map[string]galleryCacheEntry
+}
+
+// loadGalleryCache reads the gallery cache of dir. A missing or corrupt cache
+// is replaced by an empty one.
+func loadGalleryCache(dir string) *galleryCache {
+	c := &galleryCache{dir: dir, entries: make(map[string]galleryCacheEntry)}
+	b, err := os.ReadFile(filepath.Join(dir, galleryCacheFile))
+	if err != nil {
+		if !errors.Is(err, os.ErrNotExist) {
+			log.Printf("ignoring unreadable gallery cache: %v", err)
+		}
+		return c
+	}
+	if err := json.Unmarshal(b, &c.entries); err != nil {
+		log.Printf("ignoring corrupt gallery cache: %v", err)
+		c.entries = make(map[string]galleryCacheEntry)
+	}
+	return c
+}
+
+// setValidators adds to header the conditional request headers for a cached
+// copy of the page at url.
+func (c *galleryCache) setValidators(url string, header http.Header) {
+	c.mu.Lock()
+	e, ok := c.entries[url]
+	c.mu.Unlock()
+	if !ok {
+		return
+	}
+	if e.ETag != "" {
+		header.Set("If-None-Match", e.ETag)
+	}
+	if e.LastModified != "" {
+		header.Set("If-Modified-Since", e.LastModified)
+	}
+}
+
+// cached returns the pictures previously parsed from url, and the next page
+// of the gallery it linked to.
+func (c *galleryCache) cached(url string) ([]Picture, string, bool) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	e, ok := c.entries[url]
+	return e.Pictures, e.Next, ok
+}
+
+// store records the pictures parsed from the page at url, whose response had
+// header, and the next page it links to, if it carries validators.
+func (c *galleryCache) store(url string, header http.Header, pics []Picture, next string) {
+	e := galleryCacheEntry{
+		ETag:         header.Get("ETag"),
+		LastModified: header.Get("Last-Modified"),
+		Pictures:     pics,
+		Next:         next,
+	}
+	if e.ETag == "" && e.LastModified == "" {
+		return
+	}
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.entries[url] = e
+}
+
+// save writes the cache to the state directory.
+func (c *galleryCache) save() error {
+	c.mu.Lock()
+	b, err := json.Marshal(c.entries)
+	c.mu.Unlock()
+	if err != nil {
+		return err
+	}
+	return writeFileAtomic(filepath.Join(c.dir, galleryCacheFile), b, 0600)
+}
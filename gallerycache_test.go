@@ -0,0 +1,76 @@
+package main
+
+import (
+	"context"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"reflect"
+	"sync/atomic"
+	"testing"
+)
+
+func TestLoadGalleryNotModified(t *testing.T) {
+	var full, notModified int64
+	var srv *httptest.Server
+	srv = httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.Header.Get("If-None-Match") == `"v1"` {
+			atomic.AddInt64(&notModified, 1)
+			w.WriteHeader(http.StatusNotModified)
+			return
+		}
+		atomic.AddInt64(&full, 1)
+		w.Header().Set("ETag", `"v1"`)
+		io.WriteString(w, galleryHTML(srv.URL, []fakeImage{{"a", "Alpha"}, {"b", "Beta"}}))
+	}))
+	defer srv.Close()
+	cfg := testConfig(t, srv.URL)
+	url := srv.URL + conceptPath(1, false)
+
+	first := newTestGrabber(t, cfg)
+	want, _, err := first.loadGallery(context.Background(), url)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := first.pages.save(); err != nil {
+		t.Fatal(err)
+	}
+
+	second := newTestGrabber(t, cfg)
+	got, _, err := second.loadGallery(context.Background(), url)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if full != 1 || notModified != 1 {
+		t.Errorf("served %d pages and %d not modified responses, want 1 each", full, notModified)
+	}
+	if len(got) != 2 || !reflect.DeepEqual(got, want) {
+		t.Errorf("pictures after 304 = %+v, want %+v", got, want)
+	}
+}
+
+func TestLoadGalleryCacheCorrupt(t *testing.T) {
+	dir := t.TempDir()
+	if err := os.WriteFile(filepath.Join(dir, galleryCacheFile), []byte(`{"https://example.com/": {`), 0600); err != nil {
+		t.Fatal(err)
+	}
+	c := loadGalleryCache(dir)
+	if _, _, ok := c.cached("https://example.com/"); ok {
+		t.Error("entry found in corrupt cache")
+	}
+	header := make(http.Header)
+	c.setValidators("https://example.com/", header)
+	if v := header.Get("If-None-Match") + header.Get("If-Modified-Since"); v != "" {
+		t.Errorf("validators %q sent from corrupt cache", v)
+	}
+}
+
+func TestGalleryCacheStoreWithoutValidators(t *testing.T) {
+	c := loadGalleryCache(t.TempDir())
+	c.store("https://example.com/", http.Header{}, []Picture{pic("a", "https://example.com/a.jpg")}, "")
+	if _, _, ok := c.cached("https://example.com/"); ok {
+		t.Error("page without validators cached")
+	}
+}
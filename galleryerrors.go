@@ -0,0 +1,93 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"log"
+	"sort"
+	"sync"
+
+	"github.com/z11i/mandalorian-art-grabber/grill"
+)
+
+// galleryFailure is a gallery page that failed to download or parse.
+type galleryFailure struct {
+	gal gallery
+	url string
+	err error
+}
+
+// galleryFailures collects the gallery pages that failed over a run, for the
+// report at its end. It is safe for concurrent use.
+type galleryFailures struct {
+	mu       sync.Mutex
+	failures []galleryFailure
+}
+
+// isParseError reports whether err is a failure to read pictures from a
+// gallery page, suggesting that the layout of the site has changed.
+func isParseError(err error) bool {
+	return errors.Is(err, grill.ErrNoScriptNode) || errors.Is(err, grill.ErrNoPicData) || errors.Is(err, grill.ErrDecode)
+}
+
+// galleryFailed counts and logs the failure of the page of gal at url, and
+// records it for the report. An interstitial page served in place of the
+// gallery stops the run, as every other page would be one too. With
+// -stop-on-first-error, any failure stops the run; with -strict, only a
+// failure to parse the page does.
+func (g *grabber) galleryFailed(gal gallery, url string, err error) {
+	g.stats.add(&g.stats.galleryErrors, 1)
+	var ierr *grill.InterstitialError
+	if errors.As(err, &ierr) {
+		g.recordGalleryFailure(gal, url, err)
+		g.halt(fmt.Errorf("blocked: the site served a %s page instead of gallery %s; try -cookie, -proxy-list or another network", ierr.Kind, url))
+		return
+	}
+	if isParseError(err) {
+		log.Printf("error parsing gallery html, the site layout may have changed: %v on %s", err, url)
+	} else {
+		log.Printf("error downloading gallery html: %v on %s", err, url)
+	}
+	g.recordGalleryFailure(gal, url, err)
+	switch {
+	case g.cfg.stopOnFirstError:
+		g.halt(fmt.Errorf("-stop-on-first-error: gallery %s failed: %w", url, err))
+	case g.cfg.strict && isParseError(err):
+		g.halt(fmt.Errorf("-strict: unable to parse gallery %s: %w", url, err))
+	}
+}
+
+// recordGalleryFailure records the failure of the page of gal at url for the
+// report.
+func (g *grabber) recordGalleryFailure(gal gallery, url string, err error) {
+	g.galleryFailures.mu.Lock()
+	defer g.galleryFailures.mu.Unlock()
+	g.galleryFailures.failures = append(g.galleryFailures.failures, galleryFailure{gal: gal, url: url, err: err})
+}
+
+// report logs the gallery pages that failed, by chapter, telling parse
+// errors and interstitial pages from download errors.
+func (f *galleryFailures) report() {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	if len(f.failures) == 0 {
+		return
+	}
+	sort.SliceStable(f.failures, func(i, j int) bool { return f.failures[i].gal.chapter < f.failures[j].gal.chapter })
+	log.Printf("%d gallery pages failed:", len(f.failures))
+	for _, fl := range f.failures {
+		var ierr *grill.InterstitialError
+		what := "download"
+		switch {
+		case isParseError(fl.err):
+			what = "parse"
+		case errors.As(fl.err, &ierr):
+			what = "access"
+		}
+		kind := "concept art"
+		if fl.gal.kind != "" {
+			kind = fl.gal.kind
+		}
+		log.Printf("  chapter %d %s, %s error: %s: %v", fl.gal.chapter, kind, what, fl.url, fl.err)
+	}
+}
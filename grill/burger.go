@@ -0,0 +1,119 @@
+package grill
+
+import (
+	"fmt"
+	"regexp"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// picDataMarker precedes the JSON object holding a gallery's pictures in the
+// page's script, however the assignment is spaced.
+var picDataMarker = regexp.MustCompile(`Grill\.burger\s*=\s*`)
+
+// picDataRegexp matches the picture data in the minified form the site first
+// used. It is only tried when counting braces fails.
+var picDataRegexp = regexp.MustCompile(`this\.Grill\?Grill\.burger=(.*):\(function\(\)`)
+
+// extractPicData returns the JSON object following picDataMarker in script.
+// The object is delimited by counting braces outside of strings, so whatever
+// code follows it cannot be mistaken for part of it. Every occurrence of the
+// marker is tried, as the script may assign something else first.
+func extractPicData(script string) ([]byte, error) {
+	err := ErrNoPicData
+	for _, m := range picDataMarker.FindAllStringIndex(script, -1) {
+		rest := script[m[1]:]
+		if !strings.HasPrefix(rest, "{") {
+			continue
+		}
+		var data []byte
+		if data, err = balancedObject(rest); err == nil {
+			return data, nil
+		}
+	}
+	if m := picDataRegexp.FindStringSubmatch(script); m != nil {
+		return []byte(m[1]), nil
+	}
+	return nil, err
+}
+
+// balancedObject returns the JSON object s starts with, up to the brace
+// closing it.
+func balancedObject(s string) ([]byte, error) {
+	depth := 0
+	inString, escaped := false, false
+	for j := 0; j < len(s); j++ {
+		c := s[j]
+		switch {
+		case escaped:
+			escaped = false
+		case inString && c == '\\':
+			escaped = true
+		case c == '"':
+			inString = !inString
+		case inString:
+		case c == '{':
+			depth++
+		case c == '}':
+			depth--
+			if depth == 0 {
+				return []byte(s[:j+1]), nil
+			}
+		}
+	}
+	return nil, fmt.Errorf("%w: unterminated object", ErrDecode)
+}
+
+// dimension returns the number of pixels v, a decoded JSON width or height,
+// gives, or 0 if it is not a number of pixels.
+func dimension(v interface{}) int {
+	switch v := v.(type) {
+	case float64:
+		return int(v)
+	case string:
+		n, err := strconv.Atoi(strings.TrimSuffix(v, "px"))
+		if err == nil {
+			return n
+		}
+	}
+	return 0
+}
+
+// published returns the first of vs, decoded JSON dates, that is set: as it
+// is if a string, or in RFC 3339 if a Unix time in seconds or milliseconds.
+func published(vs ...interface{}) string {
+	for _, v := range vs {
+		switch v := v.(type) {
+		case string:
+			if s := strings.TrimSpace(v); s != "" {
+				return s
+			}
+		case float64:
+			if v <= 0 {
+				continue
+			}
+			if v >= 1e12 {
+				return time.UnixMilli(int64(v)).UTC().Format(time.RFC3339)
+			}
+			return time.Unix(int64(v), 0).UTC().Format(time.RFC3339)
+		}
+	}
+	return ""
+}
+
+// imageURL returns the absolute or protocol-relative URL v holds, directly or
+// in a url or src field, or "".
+func imageURL(v interface{}) string {
+	if m, ok := v.(map[string]interface{}); ok {
+		if u := imageURL(m["url"]); u != "" {
+			return u
+		}
+		return imageURL(m["src"])
+	}
+	s, ok := v.(string)
+	if !ok || !(strings.HasPrefix(s, "https://") || strings.HasPrefix(s, "http://") || strings.HasPrefix(s, "//")) {
+		return ""
+	}
+	return s
+}
@@ -0,0 +1,75 @@
+package grill
+
+import (
+	"errors"
+	"testing"
+)
+
+func TestExtractPicDataTerminator(t *testing.T) {
+	const data = `{"stack":[{"data":[{"images":[{"id":"a","image":"https://example.com/a.jpeg"}]}]}]}`
+	tests := []struct {
+		name   string
+		script string
+	}{
+		{"minified", `this.Grill?Grill.burger=` + data + `:(function(){console.log("no grill")})();`},
+		// A greedy capture up to the last ":(function()" would take in
+		// the code between the two.
+		{"terminator repeated later", `this.Grill?Grill.burger=` + data + `:(function(){})();var x={a:1}?1:(function(){return 2})();`},
+		{"other trailing expression", `this.Grill?Grill.burger=` + data + `:null;`},
+		{"nothing after", `this.Grill?Grill.burger=` + data},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := extractPicData(tt.script)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if string(got) != data {
+				t.Errorf("got %s, want %s", got, data)
+			}
+		})
+	}
+}
+
+func TestExtractPicDataMissing(t *testing.T) {
+	if _, err := extractPicData(`window.dataLayer = [];`); !errors.Is(err, ErrNoPicData) {
+		t.Errorf("got %v, want %v", err, ErrNoPicData)
+	}
+}
+
+func TestExtractPicData(t *testing.T) {
+	tests := []struct {
+		name   string
+		script string
+		want   string
+	}{
+		{"spaced", `window.Grill = window.Grill || {}; Grill.burger = {"a":1};`, `{"a":1}`},
+		{"newlines", "Grill.burger=\n\t{\n\"a\": {\"b\": 2}\n}\n;", "{\n\"a\": {\"b\": 2}\n}"},
+		{"braces in strings", `this.Grill?Grill.burger={"caption":"a } and a {","x":{"y":"}}}"}}:(function(){})();`, `{"caption":"a } and a {","x":{"y":"}}}"}}`},
+		{"escaped quotes", `this.Grill?Grill.burger={"caption":"say \"}\" twice \\","z":{}}:(function(){})();`, `{"caption":"say \"}\" twice \\","z":{}}`},
+		{"code before", `var f=function(){return {a:1}};f();this.Grill?Grill.burger={"a":[{"b":{}}]}:(function(){})();`, `{"a":[{"b":{}}]}`},
+		{"earlier assignment not an object", `Grill.burger=null;Grill.burger={"a":1};`, `{"a":1}`},
+		// Counting finds no end, so the regex of the old minified form
+		// gets its say.
+		{"regex fallback", `this.Grill?Grill.burger={"stack":[{"data":[]}]:(function(){})();`, `{"stack":[{"data":[]}]`},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := extractPicData(tt.script)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if string(got) != tt.want {
+				t.Errorf("got %s, want %s", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestBalancedObjectUnterminated(t *testing.T) {
+	for _, s := range []string{`{`, `{"a":{}`, `{"a":"}"`, `{"a":"\"}`} {
+		if _, err := balancedObject(s); !errors.Is(err, ErrDecode) {
+			t.Errorf("balancedObject(%s): got %v, want %v", s, err, ErrDecode)
+		}
+	}
+}
@@ -0,0 +1,35 @@
+package grill_test
+
+import (
+	"errors"
+	"fmt"
+	"strings"
+
+	"github.com/z11i/mandalorian-art-grabber/grill"
+)
+
+func ExampleParse() {
+	page := `<html><body><div id="main"><script>
+this.Grill?Grill.burger={"stack":[{"data":[]},{"data":[]},{"data":[{"images":[
+	{"id":"1","caption":"The Razor Crest","image":"https://lumiere-a.akamaihd.net/v1/images/razor-crest.jpeg"}
+]}]}]}:(function(){})();
+</script></div></body></html>`
+	pics, err := grill.Parse(strings.NewReader(page))
+	if err != nil {
+		fmt.Println(err)
+		return
+	}
+	for _, p := range pics {
+		fmt.Println(p.ID, p.Caption, p.URL)
+	}
+	// Output:
+	// 1 The Razor Crest https://lumiere-a.akamaihd.net/v1/images/razor-crest.jpeg
+}
+
+func ExampleParse_notFound() {
+	page := `<html><body><div id="main"><article id="error_page">Not found</article></div></body></html>`
+	_, err := grill.Parse(strings.NewReader(page))
+	fmt.Println(errors.Is(err, grill.ErrNotFound))
+	// Output:
+	// true
+}
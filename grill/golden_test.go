@@ -0,0 +1,67 @@
+package grill
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"flag"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+var update = flag.Bool("update", false, "rewrite the golden files of the parser tests")
+
+// TestParseGolden parses the pages saved in testdata and compares the
+// pictures with those in the page's .golden.json file, or the error with the
+// one expected.
+func TestParseGolden(t *testing.T) {
+	tests := []struct {
+		page    string
+		wantErr error
+	}{
+		{"concept.html", nil},
+		{"grid.html", nil},
+		{"boba_fett.html", nil},
+		{"story.html", nil},
+		{"error_page.html", ErrNotFound},
+		{"malformed.html", ErrDecode},
+	}
+	for _, tt := range tests {
+		t.Run(tt.page, func(t *testing.T) {
+			f, err := os.Open(filepath.Join("testdata", tt.page))
+			if err != nil {
+				t.Fatal(err)
+			}
+			defer f.Close()
+			pics, err := Parse(f)
+			if tt.wantErr != nil {
+				if !errors.Is(err, tt.wantErr) {
+					t.Errorf("got %d pictures, error %v, want %v", len(pics), err, tt.wantErr)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatal(err)
+			}
+			got, err := json.MarshalIndent(pics, "", "\t")
+			if err != nil {
+				t.Fatal(err)
+			}
+			got = append(got, '\n')
+			golden := filepath.Join("testdata", tt.page[:len(tt.page)-len(filepath.Ext(tt.page))]+".golden.json")
+			if *update {
+				if err := os.WriteFile(golden, got, 0644); err != nil {
+					t.Fatal(err)
+				}
+			}
+			want, err := os.ReadFile(golden)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if !bytes.Equal(got, want) {
+				t.Errorf("pictures differ from %s; run go test -update after checking them:\n%s", golden, got)
+			}
+		})
+	}
+}
@@ -0,0 +1,273 @@
+// Package grill reads the pictures of the concept art galleries of
+// starwars.com from their HTML pages. The site embeds a gallery's pictures in
+// a script, as a JSON object assigned to Grill.burger; Parse finds and decodes
+// it.
+//
+// To list the pictures of a gallery page fetched with net/http:
+//
+//	resp, err := http.Get("https://www.starwars.com/series/the-mandalorian/chapter-1-concept-art-gallery")
+//	if err != nil {
+//		return err
+//	}
+//	defer resp.Body.Close()
+//	pics, err := grill.Parse(resp.Body)
+//	switch {
+//	case errors.Is(err, grill.ErrNotFound):
+//		// No such gallery.
+//	case err != nil:
+//		return err
+//	}
+//	for _, p := range pics {
+//		fmt.Println(p.ID, p.Caption, p.URL)
+//	}
+//
+// The package does no I/O of its own besides reading the page it is given, and
+// does not log.
+package grill
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"strings"
+
+	"github.com/antchfx/htmlquery"
+	"github.com/antchfx/xpath"
+	"github.com/mitchellh/mapstructure"
+	"golang.org/x/net/html"
+)
+
+// Picture is a picture, or video clip, of a gallery. Besides its URL, fields
+// are empty when the gallery does not give them.
+type Picture struct {
+	URL     string // as the gallery gives it, possibly relative to the page
+	Caption string // as the gallery gives it
+	ID      string
+	Video   bool // a video clip rather than a picture
+
+	Alt         string
+	Credit      string // artist credit line
+	Description string // long description, besides the caption
+	PublishedAt string // date the gallery gives, as given or in RFC 3339
+	Width       int
+	Height      int
+	Extra       map[string]interface{} // fields of the gallery's image entry not otherwise known
+
+	// Variants are the renditions of the picture the gallery entry gives,
+	// largest first, when it gives several. URL, Width and Height are
+	// those of the first.
+	Variants []Variant
+}
+
+// ErrNotFound is returned for the page the site serves for galleries that do
+// not exist.
+var ErrNotFound = errors.New("gallery not found")
+
+// Errors returned for a gallery page pictures cannot be read from. All but
+// ErrNoImages suggest that the layout of the site has changed.
+var (
+	ErrNoScriptNode = errors.New("cannot find html node for pictures")
+	ErrNoPicData    = errors.New("unable to find picture data in script")
+	ErrDecode       = errors.New("unable to decode picture data")
+	ErrNoImages     = errors.New("gallery has no pictures")
+)
+
+var (
+	picDataXpath   = xpath.MustCompile("//div[@id='main']/script")
+	notFoundXpath  = xpath.MustCompile("//div[@id='main']/article[@id='error_page']")
+	anyScriptXpath = xpath.MustCompile("//script")
+)
+
+// IsErrorPage reports whether doc is the page the site serves, often with a
+// 200, for galleries that do not exist.
+func IsErrorPage(doc *html.Node) bool {
+	return htmlquery.QuerySelector(doc, notFoundXpath) != nil
+}
+
+// scriptTexts returns the text of the scripts matching expr that have any.
+func scriptTexts(doc *html.Node, expr *xpath.Expr) []string {
+	var scripts []string
+	for _, n := range htmlquery.QuerySelectorAll(doc, expr) {
+		if s := htmlquery.InnerText(n); strings.TrimSpace(s) != "" {
+			scripts = append(scripts, s)
+		}
+	}
+	return scripts
+}
+
+// firstPicData returns the decoded picture data of the first of scripts
+// holding a JSON object after the marker. Scripts whose data does not decode
+// are passed over, as analytics scripts may assign Grill.burger too. It
+// returns ErrNoPicData if no script has the marker, or else the error of the
+// first that failed to decode.
+func firstPicData(scripts []string) (map[string]interface{}, error) {
+	var firstErr error
+	for _, script := range scripts {
+		picData, err := extractPicData(script)
+		if errors.Is(err, ErrNoPicData) {
+			continue
+		}
+		var m map[string]interface{}
+		if err == nil {
+			if err = json.Unmarshal(picData, &m); err != nil {
+				err = fmt.Errorf("%w: %v", ErrDecode, err)
+			}
+		}
+		if err == nil {
+			return m, nil
+		}
+		if firstErr == nil {
+			firstErr = err
+		}
+	}
+	if firstErr != nil {
+		return nil, firstErr
+	}
+	return nil, ErrNoPicData
+}
+
+// Parse reads the pictures of the gallery page read from r. See ParseNode.
+func Parse(r io.Reader) ([]Picture, error) {
+	doc, err := html.Parse(r)
+	if err != nil {
+		return nil, err
+	}
+	return ParseNode(doc)
+}
+
+// ParseNode returns the pictures of the gallery page doc, in the order the
+// page gives them, without duplicates. Video clips are returned too, with
+// Video set. It returns ErrNotFound for the site's error page, ErrNoImages
+// for a page whose picture data holds no pictures, an *InterstitialError for a page served in
+// its place, and an error wrapping ErrNoScriptNode, ErrNoPicData or ErrDecode
+// for a page it cannot read.
+func ParseNode(doc *html.Node) ([]Picture, error) {
+	scripts := scriptTexts(doc, picDataXpath)
+	if len(scripts) == 0 && IsErrorPage(doc) {
+		return nil, ErrNotFound
+	}
+
+	// The picture data is in the first script holding it, which is not
+	// necessarily the first script, nor under #main if the layout moved it.
+	m, err := firstPicData(scripts)
+	if err != nil || len(scripts) == 0 {
+		all := scriptTexts(doc, anyScriptXpath)
+		if len(all) == 0 {
+			err = ErrNoScriptNode
+		} else {
+			m, err = firstPicData(all)
+		}
+	}
+	if errors.Is(err, ErrNoScriptNode) || errors.Is(err, ErrNoPicData) {
+		if ierr := interstitial(doc); ierr != nil {
+			return nil, ierr
+		}
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	// The gallery is usually the first data block of the third stack
+	// entry, but galleries of other kinds are laid out differently, so every
+	// block with images is collected. Blocks of other shapes are skipped.
+	var data struct {
+		Stack []interface{} `mapstructure:"stack"`
+	}
+	err = mapstructure.Decode(m, &data)
+	if err != nil {
+		return nil, fmt.Errorf("%w: %v", ErrDecode, err)
+	}
+	var (
+		pics    []Picture
+		gallery bool // an images array was found
+		seen    = make(map[string]bool)
+	)
+	for _, s := range data.Stack {
+		var entry struct {
+			Data []interface{} `mapstructure:"data"`
+		}
+		if mapstructure.Decode(s, &entry) != nil {
+			continue
+		}
+		for _, d := range entry.Data {
+			var block struct {
+				Images []struct {
+					Image   string                 `mapstructure:"image"`
+					Caption string                 `mapstructure:"caption"`
+					ID      string                 `mapstructure:"id"`
+					Alt     string                 `mapstructure:"alt"`
+					Credit  string                 `mapstructure:"credit"`
+					Desc    string                 `mapstructure:"description"`
+					Date    interface{}            `mapstructure:"date"`
+					Pub     interface{}            `mapstructure:"published"`
+					PubAt   interface{}            `mapstructure:"published_at"`
+					PubDate interface{}            `mapstructure:"publish_date"`
+					Width   interface{}            `mapstructure:"width"`
+					Height  interface{}            `mapstructure:"height"`
+					Extra   map[string]interface{} `mapstructure:",remain"`
+				} `mapstructure:"images"`
+			}
+			// Weakly, so that numbers given as strings, or the other
+			// way round, do not lose the block.
+			if mapstructure.WeakDecode(d, &block) != nil || block.Images == nil {
+				continue
+			}
+			gallery = true
+			for _, img := range block.Images {
+				p := Picture{URL: img.Image, Caption: img.Caption, ID: img.ID,
+					Alt: img.Alt, Credit: img.Credit, Description: img.Desc, PublishedAt: published(img.PubAt, img.Pub, img.PubDate, img.Date),
+					Width: dimension(img.Width), Height: dimension(img.Height), Extra: img.Extra}
+				if p.URL == "" {
+					continue
+				}
+				if vs := variants(p, img.Extra); len(vs) > 1 {
+					p.Variants = vs
+					p.URL, p.Width, p.Height = vs[0].URL, vs[0].Width, vs[0].Height
+				}
+				if seen[key(p)] {
+					continue
+				}
+				seen[key(p)] = true
+				pics = append(pics, p)
+			}
+		}
+	}
+	// Galleries such as trivia mix images with text in other structures,
+	// and special galleries may not be laid out as a stack at all, such as
+	// grid modules, in which case the whole data is searched.
+	var root interface{} = data.Stack
+	if data.Stack == nil {
+		root = m
+	}
+	if !gallery {
+		for _, p := range findImages(root) {
+			if !seen[key(p)] {
+				seen[key(p)] = true
+				pics = append(pics, p)
+			}
+		}
+	}
+	// Video clips are returned too, whether or not they are wanted.
+	for _, v := range findVideos(root) {
+		if !seen[key(v)] {
+			seen[key(v)] = true
+			pics = append(pics, v)
+		}
+	}
+	// Pages without pictures, such as the trivia galleries of chapters
+	// whose trivia is all text, are not malformed.
+	if len(pics) == 0 {
+		return nil, ErrNoImages
+	}
+	return pics, nil
+}
+
+// key identifies p within a page by ID, falling back to URL when the ID is
+// empty.
+func key(p Picture) string {
+	if p.ID == "" {
+		return "url:" + p.URL
+	}
+	return "id:" + p.ID
+}
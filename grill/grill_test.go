@@ -0,0 +1,248 @@
+package grill
+
+import (
+	"encoding/json"
+	"errors"
+	"strings"
+	"testing"
+)
+
+// page returns a gallery page with the scripts in its #main container.
+func page(scripts ...string) string {
+	var b strings.Builder
+	b.WriteString(`<!DOCTYPE html><html><head><title>Gallery</title></head><body><div id="main">`)
+	for _, s := range scripts {
+		b.WriteString("<script>" + s + "</script>")
+	}
+	b.WriteString(`</div></body></html>`)
+	return b.String()
+}
+
+// burger returns the script assigning v, as JSON, to Grill.burger, minified
+// the way the site does.
+func burger(v interface{}) string {
+	b, err := json.Marshal(v)
+	if err != nil {
+		panic(err)
+	}
+	return "this.Grill?Grill.burger=" + string(b) + ":(function(){console.log('no grill')})();"
+}
+
+// stack returns picture data laid out as the site lays out galleries, with
+// images in the first data block of the third stack entry.
+func stack(images ...map[string]interface{}) map[string]interface{} {
+	if images == nil {
+		images = []map[string]interface{}{}
+	}
+	return map[string]interface{}{"stack": []interface{}{
+		map[string]interface{}{"data": []interface{}{map[string]interface{}{"title": "Chapter 1"}}},
+		map[string]interface{}{"data": []interface{}{}},
+		map[string]interface{}{"data": []interface{}{map[string]interface{}{"images": images}}},
+	}}
+}
+
+func image(id, caption string) map[string]interface{} {
+	return map[string]interface{}{"id": id, "caption": caption, "image": "https://lumiere-a.akamaihd.net/v1/images/" + id + ".jpeg"}
+}
+
+func TestParseErrors(t *testing.T) {
+	tests := []struct {
+		name string
+		html string
+		want error
+	}{
+		{"error page", `<html><body><div id="main"><article id="error_page"><h1>Not found</h1></article></div></body></html>`, ErrNotFound},
+		{"no script", `<html><body><div id="main"><p>Nothing here</p></div></body></html>`, ErrNoScriptNode},
+		{"no marker", page("window.dataLayer = [];"), ErrNoPicData},
+		{"bad json", page(`this.Grill?Grill.burger={"stack": [1,}:(function(){})();`), ErrDecode},
+		{"unterminated", page(`Grill.burger={"stack": [`), ErrDecode},
+		{"stack not a list", page(burger(map[string]interface{}{"stack": "none"})), ErrDecode},
+		{"empty gallery", page(burger(stack())), ErrNoImages},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			pics, err := Parse(strings.NewReader(tt.html))
+			if !errors.Is(err, tt.want) {
+				t.Errorf("got %d pictures, error %v, want error %v", len(pics), err, tt.want)
+			}
+		})
+	}
+}
+
+func TestParse(t *testing.T) {
+	pics, err := Parse(strings.NewReader(page(
+		"window.dataLayer = [];",
+		burger(stack(image("a", "Alpha"), image("b", "Beta"), image("a", "Alpha again"))),
+	)))
+	if err != nil {
+		t.Fatal(err)
+	}
+	var got []string
+	for _, p := range pics {
+		got = append(got, p.ID+"="+p.Caption)
+	}
+	if want := "a=Alpha b=Beta"; strings.Join(got, " ") != want {
+		t.Errorf("got %v, want %s", got, want)
+	}
+}
+
+func TestParseLayouts(t *testing.T) {
+	images := func(ids ...string) map[string]interface{} {
+		var imgs []map[string]interface{}
+		for _, id := range ids {
+			imgs = append(imgs, image(id, "Caption "+id))
+		}
+		return map[string]interface{}{"images": imgs}
+	}
+	tests := []struct {
+		name string
+		data map[string]interface{}
+		want int
+	}{
+		{"concept", stack(image("a", "A"), image("b", "B"), image("c", "C")), 3},
+		{"story", map[string]interface{}{"stack": []interface{}{
+			map[string]interface{}{"data": []interface{}{map[string]interface{}{"title": "Chapter 2"}, images("a", "b")}},
+			map[string]interface{}{"data": []interface{}{map[string]interface{}{"text": "The story so far"}}},
+			map[string]interface{}{"data": []interface{}{images("c"), images("b", "d")}},
+		}}, 4},
+		{"short stack", map[string]interface{}{"stack": []interface{}{
+			map[string]interface{}{"data": []interface{}{images("a", "b")}},
+		}}, 2},
+		{"odd entries", map[string]interface{}{"stack": []interface{}{
+			"promo",
+			map[string]interface{}{"data": "none"},
+			map[string]interface{}{"data": []interface{}{42, images("a")}},
+		}}, 1},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			pics, err := Parse(strings.NewReader(page(burger(tt.data))))
+			if err != nil {
+				t.Fatal(err)
+			}
+			if len(pics) != tt.want {
+				t.Errorf("got %d pictures, want %d", len(pics), tt.want)
+			}
+		})
+	}
+}
+
+// TestParseMalformed feeds picture data that indexing the third stack entry
+// used to panic on, or silently return nothing for.
+func TestParseMalformed(t *testing.T) {
+	for _, data := range []string{
+		`{}`,
+		`[]`,
+		`{"stack":null}`,
+		`{"stack":[]}`,
+		`{"stack":[{"data":[]}]}`,
+		`{"stack":[{},{},{}]}`,
+		`{"stack":[{},{},{"data":[]}]}`,
+		`{"stack":[{},{},{"data":[{"images":"none"}]}]}`,
+		`{"stack":[{},{},{"data":[{"images":[{"caption":"no url"}]}]}]}`,
+	} {
+		pics, err := Parse(strings.NewReader(page("this.Grill?Grill.burger=" + data + ":(function(){})();")))
+		if err == nil || err.Error() == "" {
+			t.Errorf("%s: got %d pictures and no error", data, len(pics))
+			continue
+		}
+		if !errors.Is(err, ErrDecode) && !errors.Is(err, ErrNoImages) {
+			t.Errorf("%s: got error %v, want %v or %v", data, err, ErrDecode, ErrNoImages)
+		}
+	}
+}
+
+func TestParseTrivia(t *testing.T) {
+	factoid := func(text string, img map[string]interface{}) map[string]interface{} {
+		f := map[string]interface{}{"type": "factoid", "text": text}
+		if img != nil {
+			f["media"] = img
+		}
+		return f
+	}
+	trivia := func(factoids ...interface{}) map[string]interface{} {
+		return map[string]interface{}{"stack": []interface{}{
+			map[string]interface{}{"data": []interface{}{map[string]interface{}{"title": "Chapter 1 Trivia"}}},
+			map[string]interface{}{"data": []interface{}{map[string]interface{}{"factoids": factoids}}},
+		}}
+	}
+
+	pics, err := Parse(strings.NewReader(page(burger(trivia(
+		factoid("The Razor Crest was built as a physical model.", map[string]interface{}{"id": "t1", "src": "https://lumiere-a.akamaihd.net/t1.jpeg"}),
+		factoid("Grogu was a puppet.", nil),
+		factoid("IG-11 was voiced by Taika Waititi.", map[string]interface{}{"id": "t2", "image": "https://lumiere-a.akamaihd.net/t2.jpeg", "caption": "IG-11"}),
+	)))))
+	if err != nil {
+		t.Fatal(err)
+	}
+	var got []string
+	for _, p := range pics {
+		got = append(got, p.ID+"="+p.URL)
+	}
+	if want := "t1=https://lumiere-a.akamaihd.net/t1.jpeg t2=https://lumiere-a.akamaihd.net/t2.jpeg"; strings.Join(got, " ") != want {
+		t.Errorf("got %v, want %s", got, want)
+	}
+
+	// Trivia that is all text is not an error of the page.
+	_, err = Parse(strings.NewReader(page(burger(trivia(factoid("Grogu was a puppet.", nil))))))
+	if !errors.Is(err, ErrNoImages) {
+		t.Errorf("trivia without images: got error %v, want %v", err, ErrNoImages)
+	}
+}
+
+// TestParseRelativeURLs checks that URLs are returned as the gallery gives
+// them, for the caller to resolve against the page.
+func TestParseRelativeURLs(t *testing.T) {
+	stacked := stack(
+		map[string]interface{}{"id": "proto", "image": "//lumiere-a.akamaihd.net/proto.jpeg"},
+		map[string]interface{}{"id": "root", "image": "/content/root.jpeg"},
+	)
+	grid := map[string]interface{}{"grid": map[string]interface{}{"items": []interface{}{
+		map[string]interface{}{"id": "proto", "image": "//lumiere-a.akamaihd.net/proto.jpeg"},
+		map[string]interface{}{"id": "src", "src": "//lumiere-a.akamaihd.net/src.png"},
+	}}}
+	for name, data := range map[string]map[string]interface{}{"stack": stacked, "grid": grid} {
+		pics, err := Parse(strings.NewReader(page(burger(data))))
+		if err != nil {
+			t.Fatalf("%s: %v", name, err)
+		}
+		for _, p := range pics {
+			if !strings.HasPrefix(p.URL, "/") {
+				t.Errorf("%s: picture %s has url %s, want it as given", name, p.ID, p.URL)
+			}
+		}
+		if len(pics) != 2 {
+			t.Errorf("%s: got %d pictures, want 2", name, len(pics))
+		}
+	}
+}
+
+func TestParseScriptOrder(t *testing.T) {
+	data := burger(stack(image("a", "A"), image("b", "B")))
+	tests := []struct {
+		name string
+		html string
+	}{
+		{"third script", page("window.dataLayer = [];", "(function(){console.log('ads')})();", data)},
+		{"after data that does not decode", page("window.dataLayer = [];", "Grill.burger={'not': json};", data)},
+		{"after an empty script", page("", "   ", data)},
+		{"outside #main", `<html><head><script>window.dataLayer = [];</script><script>` + data + `</script></head><body><div id="main"><script>var a = 1;</script></div></body></html>`},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			pics, err := Parse(strings.NewReader(tt.html))
+			if err != nil {
+				t.Fatal(err)
+			}
+			if len(pics) != 2 {
+				t.Errorf("got %d pictures, want 2", len(pics))
+			}
+		})
+	}
+
+	// With no script decoding, the error is that of the first to fail.
+	_, err := Parse(strings.NewReader(page("Grill.burger={'a': 1};", `Grill.burger={"a": [}`)))
+	if !errors.Is(err, ErrDecode) {
+		t.Errorf("got %v, want %v", err, ErrDecode)
+	}
+}
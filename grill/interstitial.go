@@ -0,0 +1,56 @@
+package grill
+
+import (
+	"fmt"
+	"strings"
+
+	"github.com/antchfx/htmlquery"
+	"github.com/antchfx/xpath"
+	"golang.org/x/net/html"
+)
+
+// Kinds of interstitial pages, see InterstitialError.
+const (
+	InterstitialConsent = "cookie consent"
+	InterstitialRegion  = "region block"
+)
+
+// InterstitialError is returned for a page the site served in place of the
+// gallery, such as a cookie consent wall or a notice that the site is not
+// available in the region, which depends on the network rather than on the
+// gallery.
+type InterstitialError struct {
+	Kind string // InterstitialConsent or InterstitialRegion
+}
+
+func (e *InterstitialError) Error() string {
+	return fmt.Sprintf("got a %s page instead of the gallery", e.Kind)
+}
+
+// consentXpath matches the containers of the consent managers that block
+// pages until cookies are accepted.
+var consentXpath = xpath.MustCompile(`//*[@id='onetrust-consent-sdk' or @id='onetrust-banner-sdk' or @id='didomi-host' or @id='CybotCookiebotDialog' or @id='truste-consent-track' or starts-with(@id, 'sp_message_container')]`)
+
+// regionPhrases are found, in lower case, in the text of region block pages.
+var regionPhrases = []string{
+	"not available in your region",
+	"not available in your country",
+	"not available in your location",
+	"unavailable in your region",
+	"unavailable in your country",
+}
+
+// interstitial returns an *InterstitialError if doc, a page without picture
+// data, is an interstitial page, or nil.
+func interstitial(doc *html.Node) error {
+	text := strings.ToLower(htmlquery.InnerText(doc))
+	for _, phrase := range regionPhrases {
+		if strings.Contains(text, phrase) {
+			return &InterstitialError{Kind: InterstitialRegion}
+		}
+	}
+	if htmlquery.QuerySelector(doc, consentXpath) != nil {
+		return &InterstitialError{Kind: InterstitialConsent}
+	}
+	return nil
+}
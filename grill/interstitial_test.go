@@ -0,0 +1,52 @@
+package grill
+
+import (
+	"errors"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+// TestParseInterstitial parses the interstitial pages saved in testdata,
+// which hold no picture data, and checks the kind of page reported.
+func TestParseInterstitial(t *testing.T) {
+	tests := []struct {
+		page string
+		want string
+	}{
+		{"consent.html", InterstitialConsent},
+		{"region.html", InterstitialRegion},
+	}
+	for _, tt := range tests {
+		t.Run(tt.page, func(t *testing.T) {
+			f, err := os.Open(filepath.Join("testdata", tt.page))
+			if err != nil {
+				t.Fatal(err)
+			}
+			defer f.Close()
+			pics, err := Parse(f)
+			var ierr *InterstitialError
+			if !errors.As(err, &ierr) {
+				t.Fatalf("got %d pictures, error %v, want an interstitial error", len(pics), err)
+			}
+			if ierr.Kind != tt.want {
+				t.Errorf("kind %q, want %q", ierr.Kind, tt.want)
+			}
+		})
+	}
+}
+
+// TestParseInterstitialWithGallery checks that a consent banner laid over a
+// gallery does not hide the gallery's pictures.
+func TestParseInterstitialWithGallery(t *testing.T) {
+	html := `<html><body><div id="onetrust-consent-sdk"></div>` +
+		`<div id="main"><script>` + burger(stack(image("a", "Grogu"))) + `</script></div></body></html>`
+	pics, err := Parse(strings.NewReader(html))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(pics) != 1 {
+		t.Errorf("got %d pictures, want 1", len(pics))
+	}
+}
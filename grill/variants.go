@@ -0,0 +1,91 @@
+package grill
+
+import (
+	"sort"
+	"strings"
+)
+
+// Variant is one of the renditions of a picture that a gallery entry gives.
+type Variant struct {
+	Name   string `json:"name"` // field of the entry it is given in, such as "image" or "full"
+	URL    string `json:"url"`
+	Width  int    `json:"width,omitempty"` // as declared, 0 if not
+	Height int    `json:"height,omitempty"`
+}
+
+// variantKeys are the fields of an image entry that may hold a rendition of
+// its picture, largest first as far as the names tell.
+var variantKeys = []string{"original", "full", "image_2x", "retina", "large", "image", "medium", "small", "thumb", "thumbnail"}
+
+// variants returns the renditions of p given by the image entry it was read
+// from, whose fields not otherwise known are extra, largest first. Those
+// found are removed from extra. p's own URL and dimensions are those of the
+// entry's image field.
+func variants(p Picture, extra map[string]interface{}) []Variant {
+	vs := []Variant{{Name: "image", URL: p.URL, Width: p.Width, Height: p.Height}}
+	seen := map[string]bool{p.URL: true}
+	for _, k := range variantKeys {
+		v, ok := extra[k]
+		if !ok {
+			continue
+		}
+		var variant Variant
+		switch v := v.(type) {
+		case string:
+			variant = Variant{Name: k, URL: v}
+		case map[string]interface{}:
+			variant = Variant{Name: k, URL: variantURL(v["url"]), Width: dimension(v["width"]), Height: dimension(v["height"])}
+			if variant.URL == "" {
+				variant.URL = variantURL(v["src"])
+			}
+		}
+		if !isVariantURL(variant.URL) || seen[variant.URL] {
+			continue
+		}
+		seen[variant.URL] = true
+		vs = append(vs, variant)
+		delete(extra, k)
+	}
+	sortLargest(vs)
+	return vs
+}
+
+// variantURL returns v if it is a string, or "".
+func variantURL(v interface{}) string {
+	s, _ := v.(string)
+	return s
+}
+
+// isVariantURL reports whether s looks like the URL of an image, possibly
+// relative to the page.
+func isVariantURL(s string) bool {
+	for _, prefix := range []string{"https://", "http://", "//", "/"} {
+		if strings.HasPrefix(s, prefix) {
+			return true
+		}
+	}
+	return false
+}
+
+// sortLargest sorts vs largest first: by declared area if every variant
+// declares its dimensions, or else by the order of variantKeys.
+func sortLargest(vs []Variant) {
+	sized := true
+	for _, v := range vs {
+		sized = sized && v.Width > 0 && v.Height > 0
+	}
+	rank := func(v Variant) int {
+		for i, k := range variantKeys {
+			if k == v.Name {
+				return i
+			}
+		}
+		return len(variantKeys)
+	}
+	sort.SliceStable(vs, func(i, j int) bool {
+		if sized {
+			return vs[i].Width*vs[i].Height > vs[j].Width*vs[j].Height
+		}
+		return rank(vs[i]) < rank(vs[j])
+	})
+}
@@ -0,0 +1,73 @@
+package grill
+
+import (
+	"reflect"
+	"strings"
+	"testing"
+)
+
+func TestParseVariants(t *testing.T) {
+	const cdn = "https://lumiere-a.akamaihd.net/v1/images/"
+	tests := []struct {
+		name    string
+		entry   map[string]interface{}
+		want    []string // variant names, largest first
+		wantURL string
+		extra   map[string]interface{}
+	}{
+		{
+			name:    "named only",
+			entry:   map[string]interface{}{"id": "a", "image": cdn + "a.jpeg", "thumb": cdn + "a-thumb.jpeg", "full": cdn + "a-full.jpeg"},
+			want:    []string{"full", "image", "thumb"},
+			wantURL: cdn + "a-full.jpeg",
+		},
+		{
+			name: "declared sizes",
+			entry: map[string]interface{}{"id": "b", "image": cdn + "b.jpeg", "width": 1280, "height": 720,
+				"retina": map[string]interface{}{"url": cdn + "b@2x.jpeg", "width": 2560, "height": 1440},
+				"large":  map[string]interface{}{"src": "//lumiere-a.akamaihd.net/b-large.jpeg", "width": "1920", "height": "1080px"}},
+			want:    []string{"retina", "large", "image"},
+			wantURL: cdn + "b@2x.jpeg",
+		},
+		{
+			// Without every size declared, the names decide.
+			name: "some sizes",
+			entry: map[string]interface{}{"id": "c", "image": cdn + "c.jpeg",
+				"thumb":    map[string]interface{}{"url": cdn + "c-thumb.jpeg", "width": 4000, "height": 4000},
+				"original": cdn + "c-original.jpeg"},
+			want:    []string{"original", "image", "thumb"},
+			wantURL: cdn + "c-original.jpeg",
+		},
+		{
+			name:    "repeated and invalid",
+			entry:   map[string]interface{}{"id": "d", "image": cdn + "d.jpeg", "full": cdn + "d.jpeg", "medium": "n/a", "small": 42},
+			wantURL: cdn + "d.jpeg",
+			extra:   map[string]interface{}{"full": cdn + "d.jpeg", "medium": "n/a", "small": float64(42)},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			pics, err := Parse(strings.NewReader(page(burger(stack(tt.entry)))))
+			if err != nil {
+				t.Fatal(err)
+			}
+			p := pics[0]
+			var names []string
+			for _, v := range p.Variants {
+				names = append(names, v.Name)
+			}
+			if !reflect.DeepEqual(names, tt.want) {
+				t.Errorf("variants %v, want %v", names, tt.want)
+			}
+			if p.URL != tt.wantURL {
+				t.Errorf("url %s, want %s", p.URL, tt.wantURL)
+			}
+			if len(p.Variants) > 0 && (p.Width != p.Variants[0].Width || p.Height != p.Variants[0].Height) {
+				t.Errorf("size %dx%d, want that of the largest variant", p.Width, p.Height)
+			}
+			if len(p.Extra)+len(tt.extra) > 0 && !reflect.DeepEqual(p.Extra, tt.extra) {
+				t.Errorf("extra %v, want %v", p.Extra, tt.extra)
+			}
+		})
+	}
+}
@@ -0,0 +1,65 @@
+package grill
+
+import (
+	"net/url"
+	"path"
+	"strings"
+)
+
+// Extensions of the video files found in galleries, as returned by VideoExt.
+const (
+	ExtMP4 = ".mp4"
+	ExtHLS = ".m3u8" // an HLS playlist rather than a single file
+)
+
+// videoKeys are the fields of a gallery entry that may hold a video URL.
+var videoKeys = []string{"video", "mp4", "src", "source", "file", "url", "hls"}
+
+// VideoExt returns the extension of the video at raw, ExtMP4 or ExtHLS, or ""
+// if raw is not the absolute or protocol-relative URL of a video.
+func VideoExt(raw string) string {
+	u, err := url.Parse(raw)
+	if err != nil || u.Host == "" || (u.Scheme != "http" && u.Scheme != "https" && u.Scheme != "") {
+		return ""
+	}
+	switch ext := strings.ToLower(path.Ext(u.Path)); ext {
+	case ExtMP4, ExtHLS:
+		return ext
+	}
+	return ""
+}
+
+// findVideos returns the video entries anywhere in v, a decoded JSON value,
+// in document order. A video entry is an object with a field named in
+// videoKeys holding the URL of an mp4 file or HLS playlist, directly or in a
+// nested url or src field. Entries offering both are returned as mp4.
+func findVideos(v interface{}) []Picture {
+	videos := findEntries(v, videoURL)
+	for i := range videos {
+		videos[i].Video = true
+	}
+	return videos
+}
+
+// videoURL returns the URL of the video the entry m offers, preferring mp4
+// files to HLS playlists, or "".
+func videoURL(m map[string]interface{}) string {
+	var hls string
+	for _, k := range videoKeys {
+		u, ok := m[k].(string)
+		if !ok {
+			if nested, isMap := m[k].(map[string]interface{}); isMap {
+				u = videoURL(nested)
+			}
+		}
+		switch VideoExt(u) {
+		case ExtMP4:
+			return u
+		case ExtHLS:
+			if hls == "" {
+				hls = u
+			}
+		}
+	}
+	return hls
+}
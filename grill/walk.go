@@ -0,0 +1,102 @@
+package grill
+
+import (
+	"path"
+	"sort"
+	"strconv"
+	"strings"
+)
+
+// Bounds of the walk of decoded picture data for entries, so that a huge or
+// deeply nested payload cannot exhaust the stack or take long to search.
+const (
+	maxWalkDepth = 64
+	maxWalkNodes = 200000
+)
+
+// imageExts are the extensions of image URLs, for entries that do not give
+// their image in an image field.
+var imageExts = map[string]bool{".jpg": true, ".jpeg": true, ".png": true, ".webp": true, ".gif": true}
+
+// findImages returns the image entries anywhere in v, a decoded JSON value,
+// in document order. An image entry is an object whose image field is an
+// absolute or protocol-relative URL, or an object with such a url or src
+// field. Objects with an id or caption but no image field are entries too if
+// their url or src field is the URL of an image file. It is for galleries not
+// laid out as arrays of images, such as the trivia galleries, whose images
+// are mixed with text, and for layouts other than the stack, such as grids.
+func findImages(v interface{}) []Picture {
+	return findEntries(v, func(m map[string]interface{}) string {
+		if u := imageURL(m["image"]); u != "" {
+			return u
+		}
+		if m["id"] == nil && m["caption"] == nil {
+			return ""
+		}
+		for _, k := range []string{"url", "src"} {
+			if u, ok := m[k].(string); ok && imageURL(u) != "" && imageExts[strings.ToLower(path.Ext(strings.SplitN(u, "?", 2)[0]))] {
+				return u
+			}
+		}
+		return ""
+	})
+}
+
+// findEntries returns a picture for every object anywhere in v, a decoded
+// JSON value, for which url returns a URL, in document order, the fields of
+// objects taken in sorted order. Such objects are not searched further. The
+// search gives up below maxWalkDepth levels and after maxWalkNodes values.
+func findEntries(v interface{}, url func(map[string]interface{}) string) []Picture {
+	var pics []Picture
+	nodes := 0
+	var walk func(v interface{}, depth int)
+	walk = func(v interface{}, depth int) {
+		nodes++
+		if depth > maxWalkDepth || nodes > maxWalkNodes {
+			return
+		}
+		switch v := v.(type) {
+		case []interface{}:
+			for _, e := range v {
+				walk(e, depth+1)
+			}
+		case map[string]interface{}:
+			if u := url(v); u != "" {
+				pics = append(pics, entry(v, u))
+				return
+			}
+			keys := make([]string, 0, len(v))
+			for k := range v {
+				keys = append(keys, k)
+			}
+			sort.Strings(keys)
+			for _, k := range keys {
+				walk(v[k], depth+1)
+			}
+		}
+	}
+	walk(v, 0)
+	return pics
+}
+
+// captionKeys are the fields an entry's caption is taken from, in order of
+// preference, when it is found by findEntries.
+var captionKeys = []string{"caption", "title", "text", "description"}
+
+// entry returns the picture at u described by the object m.
+func entry(m map[string]interface{}, u string) Picture {
+	p := Picture{URL: u}
+	switch id := m["id"].(type) {
+	case string:
+		p.ID = id
+	case float64:
+		p.ID = strconv.FormatFloat(id, 'f', -1, 64)
+	}
+	for _, k := range captionKeys {
+		if c, ok := m[k].(string); ok && strings.TrimSpace(c) != "" {
+			p.Caption = c
+			break
+		}
+	}
+	return p
+}
@@ -0,0 +1,60 @@
+package grill
+
+import (
+	"strings"
+	"testing"
+)
+
+// nested returns v wrapped in depth arrays.
+func nested(v interface{}, depth int) interface{} {
+	for i := 0; i < depth; i++ {
+		v = []interface{}{v}
+	}
+	return v
+}
+
+func TestFindImagesStack(t *testing.T) {
+	data := map[string]interface{}{"stack": []interface{}{
+		map[string]interface{}{"data": []interface{}{map[string]interface{}{"title": "Chapter 1", "image": map[string]interface{}{"url": "https://lumiere-a.akamaihd.net/hero.jpeg"}}}},
+		map[string]interface{}{"data": []interface{}{map[string]interface{}{"blocks": []interface{}{
+			map[string]interface{}{"type": "text", "text": "Grogu was a puppet."},
+			map[string]interface{}{"type": "figure", "media": image("a", "The Razor Crest")},
+			map[string]interface{}{"type": "link", "id": "l", "url": "https://www.starwars.com/news"},
+			map[string]interface{}{"type": "figure", "media": map[string]interface{}{"caption": "IG-11", "src": "https://lumiere-a.akamaihd.net/ig-11.png"}},
+		}}}},
+	}}
+	var got []string
+	for _, p := range findImages(data["stack"]) {
+		got = append(got, p.Caption)
+	}
+	if want := "Chapter 1|The Razor Crest|IG-11"; strings.Join(got, "|") != want {
+		t.Errorf("got captions %q, want %s", got, want)
+	}
+}
+
+func TestFindEntriesBounds(t *testing.T) {
+	img := image("a", "Grogu")
+	if pics := findImages(nested(img, maxWalkDepth)); len(pics) != 1 {
+		t.Errorf("got %d pictures at the depth bound, want 1", len(pics))
+	}
+	if pics := findImages(nested(img, maxWalkDepth+1)); len(pics) != 0 {
+		t.Errorf("got %d pictures below the depth bound, want 0", len(pics))
+	}
+	// A payload nested far deeper is searched no further than the bound.
+	if pics := findImages(nested(img, 100000)); len(pics) != 0 {
+		t.Errorf("got %d pictures 100000 levels down, want 0", len(pics))
+	}
+
+	wide := make([]interface{}, maxWalkNodes)
+	for i := range wide {
+		wide[i] = "filler"
+	}
+	first := append([]interface{}{img}, wide...)
+	if pics := findImages(first); len(pics) != 1 {
+		t.Errorf("got %d pictures before the node bound, want 1", len(pics))
+	}
+	last := append(wide, img)
+	if pics := findImages(last); len(pics) != 0 {
+		t.Errorf("got %d pictures past the node bound, want 0", len(pics))
+	}
+}
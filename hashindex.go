@@ -0,0 +1,106 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log"
+	"os"
+	"path/filepath"
+	"sync"
+)
+
+// hashIndexFile is the name of the content hash index kept in the state
+// directory so that content deduplication carries over between runs.
+const hashIndexFile = ".hashes.json"
+
+// hashIndex maps the SHA-256 of downloaded files to the file first written
+// with that content. It is safe for concurrent use.
+type hashIndex struct {
+	mu   sync.Mutex
+	dir  string
+	data struct {
+		Files      map[string]string `json:"files"`      // hash to file name
+		Duplicates map[string]string `json:"duplicates"` // duplicate file name to original file name
+	}
+}
+
+// loadHashIndex reads the hash index of dir. A missing index is not an error.
+func loadHashIndex(dir string) (*hashIndex, error) {
+	h := &hashIndex{dir: dir}
+	b, err := os.ReadFile(filepath.Join(dir, hashIndexFile))
+	if err != nil && !errors.Is(err, os.ErrNotExist) {
+		return nil, err
+	}
+	if err == nil {
+		if err := json.Unmarshal(b, &h.data); err != nil {
+			return nil, fmt.Errorf("corrupt hash index: %w", err)
+		}
+	}
+	if h.data.Files == nil {
+		h.data.Files = make(map[string]string)
+	}
+	if h.data.Duplicates == nil {
+		h.data.Duplicates = make(map[string]string)
+	}
+	return h, nil
+}
+
+// claim records name as having content sum. If a different file that still
+// exists in s already has that content, claim records name as its duplicate
+// and returns the original's name.
+func (h *hashIndex) claim(ctx context.Context, s Storer, sum, name string) (orig string, dup bool) {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	if o, ok := h.data.Files[sum]; ok && o != name {
+		if exists, _ := s.Exists(ctx, o); exists {
+			h.data.Duplicates[name] = o
+			return o, true
+		}
+	}
+	h.data.Files[sum] = name
+	delete(h.data.Duplicates, name)
+	return "", false
+}
+
+// save writes the index to the state directory.
+func (h *hashIndex) save() error {
+	h.mu.Lock()
+	b, err := json.MarshalIndent(h.data, "", "  ")
+	h.mu.Unlock()
+	if err != nil {
+		return err
+	}
+	return writeFileAtomic(filepath.Join(h.dir, hashIndexFile), b, 0600)
+}
+
+// dedupContent compares the content hash sum of the just written file name
+// against the files seen so far. A duplicate is removed and, in link mode,
+// replaced by a hard link to the original. It returns the name of the file now
+// holding the content.
+func (g *grabber) dedupContent(ctx context.Context, name, sum string) string {
+	orig, dup := g.hashes.claim(ctx, g.store, sum, name)
+	if !dup {
+		return name
+	}
+	if err := g.store.Remove(ctx, name); err != nil {
+		log.Printf("unable to remove duplicate file: %v", err)
+		return name
+	}
+	if g.cfg.contentDedup == "link" {
+		l, ok := g.store.(linker)
+		if !ok {
+			log.Printf("skipped %v, identical to %v; the output does not support hard links", name, orig)
+			return orig
+		}
+		if err := l.Link(orig, name); err != nil {
+			log.Printf("unable to link duplicate file: %v", err)
+			return orig
+		}
+		g.infof("linked %v to identical %v", name, orig)
+		return name
+	}
+	g.infof("skipped %v, identical to %v", name, orig)
+	return orig
+}
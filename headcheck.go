@@ -0,0 +1,66 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log"
+	"net/http"
+	"sync/atomic"
+)
+
+// headPic checks that p can be downloaded without downloading it, counting
+// it as reachable or not and adding its size to the expected bytes. Servers
+// rejecting HEAD requests are sent a GET whose body is not read.
+func (g *grabber) headPic(ctx context.Context, p Picture) error {
+	size, err := g.probe(ctx, http.MethodHead, p.URL)
+	if errors.Is(err, errHeadRejected) {
+		size, err = g.probe(ctx, http.MethodGet, p.URL)
+	}
+	if err != nil {
+		g.stats.add(&g.stats.unreachable, 1)
+		return err
+	}
+	g.stats.add(&g.stats.reachable, 1)
+	if size > 0 {
+		g.stats.add(&g.stats.expectedBytes, size)
+	}
+	g.debugf("reachable %s, %d bytes", p.URL, size)
+	return nil
+}
+
+// errHeadRejected is returned by probe when the server does not support HEAD
+// requests.
+var errHeadRejected = errors.New("HEAD not supported")
+
+// probe requests url with method and returns the Content-Length of the
+// response, -1 if unknown.
+func (g *grabber) probe(ctx context.Context, method, url string) (int64, error) {
+	req, err := http.NewRequestWithContext(ctx, method, url, nil)
+	if err != nil {
+		return 0, fmt.Errorf("unable to create request: %w", err)
+	}
+	var size int64
+	err = httpDo(ctx, g.client, req, func(resp *http.Response, err error) error {
+		if err != nil {
+			return err
+		}
+		defer resp.Body.Close()
+		switch {
+		case method == http.MethodHead && (resp.StatusCode == http.StatusMethodNotAllowed || resp.StatusCode == http.StatusNotImplemented):
+			return errHeadRejected
+		case resp.StatusCode != http.StatusOK:
+			return &statusError{code: resp.StatusCode, status: resp.Status, url: url, retryAfter: resp.Header.Get("Retry-After")}
+		}
+		size = resp.ContentLength
+		return nil
+	})
+	return size, err
+}
+
+// logHeadCheck logs the outcome of a head check run.
+func (g *grabber) logHeadCheck() {
+	log.Printf("head check: %d reachable, %d unreachable, %s expected",
+		atomic.LoadInt64(&g.stats.reachable), atomic.LoadInt64(&g.stats.unreachable),
+		formatBytes(atomic.LoadInt64(&g.stats.expectedBytes)))
+}
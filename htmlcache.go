@@ -0,0 +1,66 @@
+package main
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"log"
+	"os"
+	"path/filepath"
+	"time"
+)
+
+// htmlCache keeps the HTML of fetched gallery pages in a directory, so that
+// they can be parsed again without the network. Each page is stored as
+// <hash>.html, with the final URL it was served from, after redirects, in
+// <hash>.url, where <hash> is the SHA-256 of the requested URL.
+type htmlCache struct {
+	dir     string
+	ttl     time.Duration // how long a page is used for, 0 for ever
+	refresh bool          // ignore cached pages, fetching and storing them again
+}
+
+func (c *htmlCache) path(url, ext string) string {
+	sum := sha256.Sum256([]byte(url))
+	return filepath.Join(c.dir, hex.EncodeToString(sum[:])+ext)
+}
+
+// get returns the cached HTML of the page requested as url, and the URL it
+// was served from. A nil cache has no pages.
+func (c *htmlCache) get(url string) (page string, body []byte, ok bool) {
+	if c == nil || c.refresh {
+		return "", nil, false
+	}
+	name := c.path(url, ".html")
+	fi, err := os.Stat(name)
+	if err != nil {
+		if !errors.Is(err, os.ErrNotExist) {
+			log.Printf("ignoring cached html of %s: %v", url, err)
+		}
+		return "", nil, false
+	}
+	if c.ttl > 0 && time.Since(fi.ModTime()) > c.ttl {
+		return "", nil, false
+	}
+	p, err := os.ReadFile(c.path(url, ".url"))
+	if err != nil {
+		return "", nil, false
+	}
+	body, err = os.ReadFile(name)
+	if err != nil {
+		log.Printf("ignoring cached html of %s: %v", url, err)
+		return "", nil, false
+	}
+	return string(p), body, true
+}
+
+// put stores the HTML of the page requested as url and served from page.
+func (c *htmlCache) put(url, page string, body []byte) error {
+	if err := os.MkdirAll(c.dir, 0700); err != nil {
+		return err
+	}
+	if err := writeFileAtomic(c.path(url, ".url"), []byte(page), 0644); err != nil {
+		return err
+	}
+	return writeFileAtomic(c.path(url, ".html"), body, 0644)
+}
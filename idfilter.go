@@ -0,0 +1,104 @@
+package main
+
+import (
+	"bufio"
+	"context"
+	"fmt"
+	"os"
+	"strings"
+	"sync/atomic"
+)
+
+// idFilter selects pictures by ID, from lists given with -ids-file and
+// -exclude-ids.
+type idFilter struct {
+	include map[string]struct{} // nil to include every ID not excluded
+	exclude map[string]struct{}
+
+	included int64 // accessed atomically
+	excluded int64 // accessed atomically
+}
+
+// loadIDFilter reads the lists of IDs to include and exclude. Either name may
+// be empty. It returns nil if both are.
+func loadIDFilter(include, exclude string) (*idFilter, error) {
+	if include == "" && exclude == "" {
+		return nil, nil
+	}
+	f := &idFilter{}
+	var err error
+	if include != "" {
+		if f.include, err = readIDs(include); err != nil {
+			return nil, err
+		}
+	}
+	if exclude != "" {
+		if f.exclude, err = readIDs(exclude); err != nil {
+			return nil, err
+		}
+	}
+	return f, nil
+}
+
+// readIDs reads the picture IDs in name, one per line. Blank lines and lines
+// starting with # are ignored.
+func readIDs(name string) (map[string]struct{}, error) {
+	f, err := os.Open(name)
+	if err != nil {
+		return nil, fmt.Errorf("unable to read ID list: %w", err)
+	}
+	defer f.Close()
+	ids := make(map[string]struct{})
+	s := bufio.NewScanner(f)
+	for s.Scan() {
+		line := strings.TrimSpace(s.Text())
+		if line == "" || strings.HasPrefix(line, "#") {
+			continue
+		}
+		ids[line] = struct{}{}
+	}
+	if err := s.Err(); err != nil {
+		return nil, fmt.Errorf("unable to read ID list: %w", err)
+	}
+	return ids, nil
+}
+
+// allows reports whether p passes the filter, counting the outcome.
+func (f *idFilter) allows(p Picture) bool {
+	_, excluded := f.exclude[p.ID]
+	if !excluded && f.include != nil {
+		_, included := f.include[p.ID]
+		excluded = !included
+	}
+	if excluded {
+		atomic.AddInt64(&f.excluded, 1)
+		return false
+	}
+	atomic.AddInt64(&f.included, 1)
+	return true
+}
+
+// filterPics forwards the pictures from in that the ID filter allows to the
+// returned channel.
+func (g *grabber) filterPics(ctx context.Context, in <-chan Picture) <-chan Picture {
+	out := make(chan Picture, cap(in))
+	go func() {
+		defer close(out)
+		for p := range in {
+			if !g.ids.allows(p) {
+				if g.cfg.mirror {
+					// Keep what was downloaded before the picture
+					// was filtered out.
+					g.expected.add(g.picName(p))
+				}
+				continue
+			}
+			select {
+			case out <- p:
+			case <-ctx.Done():
+				return
+			}
+		}
+	}()
+	return out
+}
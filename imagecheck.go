@@ -0,0 +1,62 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"image"
+	"io"
+)
+
+// errCorruptImage is returned when a downloaded picture does not decode.
+var errCorruptImage = errors.New("corrupt image")
+
+// decodeCheck decodes the image written to it as it is written, so that
+// truncated or otherwise broken files are caught before they are kept.
+type decodeCheck struct {
+	pw   *io.PipeWriter
+	done chan error
+}
+
+// newDecodeCheck returns a check of the whole image if full is set, or else a
+// cheap one of its header only, which catches error pages served in place of
+// images but not truncated ones.
+func newDecodeCheck(full bool) *decodeCheck {
+	pr, pw := io.Pipe()
+	d := &decodeCheck{pw: pw, done: make(chan error, 1)}
+	go func() {
+		var err error
+		if full {
+			_, _, err = image.Decode(pr)
+		} else {
+			var cfg image.Config
+			cfg, _, err = image.DecodeConfig(pr)
+			if err == nil && (cfg.Width == 0 || cfg.Height == 0) {
+				err = fmt.Errorf("image is %dx%d", cfg.Width, cfg.Height)
+			}
+		}
+		// Keep reading whatever follows the image, or what is left after
+		// a failed decode, so that writes never block.
+		io.Copy(io.Discard, pr)
+		d.done <- err
+	}()
+	return d
+}
+
+func (d *decodeCheck) Write(p []byte) (int, error) {
+	return d.pw.Write(p)
+}
+
+// abort stops the check without waiting for its result.
+func (d *decodeCheck) abort() {
+	d.pw.CloseWithError(errAborted)
+}
+
+// result returns an error wrapping errCorruptImage if what was written is not
+// a complete image.
+func (d *decodeCheck) result() error {
+	d.pw.Close()
+	if err := <-d.done; err != nil {
+		return fmt.Errorf("%w: %v", errCorruptImage, err)
+	}
+	return nil
+}
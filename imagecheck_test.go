@@ -0,0 +1,66 @@
+package main
+
+import (
+	"bytes"
+	"encoding/binary"
+	"errors"
+	"hash/crc32"
+	"testing"
+)
+
+func TestDecodeCheck(t *testing.T) {
+	pngData := fakeImageData("check")
+	// A PNG claiming to be 0x0, with the checksum of its header fixed up.
+	empty := append([]byte(nil), pngData...)
+	copy(empty[16:24], make([]byte, 8))
+	binary.BigEndian.PutUint32(empty[29:33], crc32.ChecksumIEEE(empty[12:29]))
+	jpg := jpegData(t)
+	tests := []struct {
+		name     string
+		body     []byte
+		header   bool // passes the header check
+		complete bool // passes the full check
+	}{
+		{"png", pngData, true, true},
+		{"jpeg", jpg, true, true},
+		{"html interstitial", []byte(errorPageHTML), false, false},
+		{"empty body", nil, false, false},
+		{"truncated header", pngData[:12], false, false},
+		{"truncated jpeg", jpg[:len(jpg)/2], true, false},
+		{"zero size", empty, false, false},
+		{"garbage after signature", append([]byte("\x89PNG\r\n\x1a\n"), bytes.Repeat([]byte{0xff}, 64)...), false, false},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			for _, full := range []bool{false, true} {
+				want := tt.header
+				if full {
+					want = tt.complete
+				}
+				d := newDecodeCheck(full)
+				// Write in small pieces, as a download would.
+				for b := tt.body; len(b) > 0; b = b[1:] {
+					if _, err := d.Write(b[:1]); err != nil {
+						t.Fatal(err)
+					}
+				}
+				err := d.result()
+				if want && err != nil {
+					t.Errorf("full=%v: got error %v, want none", full, err)
+				}
+				if !want && !errors.Is(err, errCorruptImage) {
+					t.Errorf("full=%v: got error %v, want %v", full, err, errCorruptImage)
+				}
+			}
+		})
+	}
+}
+
+func TestDecodeCheckAbort(t *testing.T) {
+	d := newDecodeCheck(true)
+	d.Write(fakeImageData("abort")[:8])
+	d.abort()
+	if _, err := d.Write([]byte{0}); err == nil {
+		t.Error("write after abort succeeded")
+	}
+}
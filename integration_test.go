@@ -0,0 +1,164 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"os"
+	"path/filepath"
+	"sync"
+	"sync/atomic"
+	"testing"
+)
+
+func TestRunPipeline(t *testing.T) {
+	site := newFakeSite(t)
+	site.gallery(conceptPath(1, false), fakeImage{"mando1", "The Mandalorian"}, fakeImage{"razor", "Razor Crest"})
+	site.gallery(conceptPath(2, true), fakeImage{"frog", "Frog Lady"})
+	cfg := testConfig(t, site.URL)
+	g := newTestGrabber(t, cfg)
+
+	ctx := context.Background()
+	if err := g.run(ctx, ctx, []int{1, 2, 3}); err != nil {
+		t.Fatal(err)
+	}
+
+	want := map[string]string{
+		"The Mandalorian_mando1.jpeg": "mando1.png",
+		"Razor Crest_razor.jpeg":      "razor.png",
+		"Frog Lady_frog.jpeg":         "frog.png",
+	}
+	for name, src := range want {
+		got, err := os.ReadFile(filepath.Join(cfg.output, name))
+		if err != nil {
+			t.Error(err)
+			continue
+		}
+		if !bytes.Equal(got, fakeImageData(src)) {
+			t.Errorf("%s does not hold the picture served as %s", name, src)
+		}
+	}
+	pics, err := filepath.Glob(filepath.Join(cfg.output, "*.jpeg"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(pics) != len(want) {
+		t.Errorf("got pictures %v, want %d", pics, len(want))
+	}
+	if n := atomic.LoadInt64(&g.stats.downloaded); n != 3 {
+		t.Errorf("downloaded %d pictures, want 3", n)
+	}
+	// Chapter 3 has no gallery: both its URLs answer with the error page.
+	if n := atomic.LoadInt64(&g.missing); n != 1 {
+		t.Errorf("%d galleries missing, want 1", n)
+	}
+	if n := atomic.LoadInt64(&g.stats.galleryErrors); n != 0 {
+		t.Errorf("%d gallery pages failed, want none", n)
+	}
+	for _, second := range []bool{false, true} {
+		if n := site.hitsOf(conceptPath(3, second)); n != 1 {
+			t.Errorf("%s fetched %d times, want 1", conceptPath(3, second), n)
+		}
+	}
+}
+
+func TestRunPipelineSkipsDownloaded(t *testing.T) {
+	site := newFakeSite(t)
+	site.gallery(conceptPath(1, false), fakeImage{"mando1", "The Mandalorian"})
+	cfg := testConfig(t, site.URL)
+
+	ctx := context.Background()
+	if err := newTestGrabber(t, cfg).run(ctx, ctx, []int{1}); err != nil {
+		t.Fatal(err)
+	}
+	g := newTestGrabber(t, cfg)
+	if err := g.run(ctx, ctx, []int{1}); err != nil {
+		t.Fatal(err)
+	}
+	if n := site.hitsOf("/img/mando1.png"); n != 1 {
+		t.Errorf("picture fetched %d times over two runs, want 1", n)
+	}
+	if n := atomic.LoadInt64(&g.stats.skipped); n != 1 {
+		t.Errorf("second run skipped %d pictures, want 1", n)
+	}
+}
+
+// BenchmarkPipeline runs the whole pipeline against a site of 16 galleries
+// of 20 pictures each, for profiling with -cpuprofile or -memprofile.
+func TestRunPipelineOnDownloaded(t *testing.T) {
+	site := newFakeSite(t)
+	site.gallery(conceptPath(1, false), fakeImage{"mando1", "The Mandalorian"}, fakeImage{"razor", "Razor Crest"}, fakeImage{"frog", "Frog Lady"})
+	cfg := testConfig(t, site.URL)
+	var (
+		mu    sync.Mutex
+		sizes = make(map[string]int64)
+		calls int
+	)
+	cfg.OnDownloaded = func(p Picture, localPath string, size int64) {
+		mu.Lock()
+		defer mu.Unlock()
+		calls++
+		sizes[localPath] = size
+		// The file is complete by the time the hook is called.
+		if fi, err := os.Stat(localPath); err != nil || fi.Size() != size {
+			t.Errorf("%s of %s: got %v, %v, want a file of %d bytes", localPath, p.ID, fi, err, size)
+		}
+	}
+	g := newTestGrabber(t, cfg)
+
+	ctx := context.Background()
+	if err := g.run(ctx, ctx, []int{1}); err != nil {
+		t.Fatal(err)
+	}
+	if calls != 3 {
+		t.Errorf("hook called %d times, want 3", calls)
+	}
+	for _, name := range []string{"The Mandalorian_mando1.jpeg", "Razor Crest_razor.jpeg", "Frog Lady_frog.jpeg"} {
+		path := filepath.Join(cfg.output, name)
+		fi, err := os.Stat(path)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if got, ok := sizes[path]; !ok || got != fi.Size() {
+			t.Errorf("hook got %s with size %d, %t, want %d", path, got, ok, fi.Size())
+		}
+	}
+
+	// Pictures already downloaded are not reported again.
+	calls = 0
+	g = newTestGrabber(t, cfg)
+	if err := g.run(ctx, ctx, []int{1}); err != nil {
+		t.Fatal(err)
+	}
+	if calls != 0 {
+		t.Errorf("hook called %d times on a second run, want 0", calls)
+	}
+}
+
+func BenchmarkPipeline(b *testing.B) {
+	const chapters, perGallery = 16, 20
+	site := newFakeSite(b)
+	var list []int
+	for c := 1; c <= chapters; c++ {
+		var images []fakeImage
+		for i := 0; i < perGallery; i++ {
+			id := fmt.Sprintf("c%02d-%02d", c, i)
+			images = append(images, fakeImage{id, "Concept art " + id})
+		}
+		site.gallery(conceptPath(c, false), images...)
+		list = append(list, c)
+	}
+	ctx := context.Background()
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		b.StopTimer()
+		g := newTestGrabber(b, testConfig(b, site.URL))
+		b.StartTimer()
+		if err := g.run(ctx, ctx, list); err != nil {
+			b.Fatal(err)
+		}
+		if n := atomic.LoadInt64(&g.stats.downloaded); n != chapters*perGallery {
+			b.Fatalf("downloaded %d pictures, want %d", n, chapters*perGallery)
+		}
+	}
+}
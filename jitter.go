@@ -0,0 +1,52 @@
+package main
+
+import (
+	"context"
+	"math/rand"
+	"sync"
+	"time"
+)
+
+// jitter draws random delays that spread requests out, so that workers do not
+// hit the site in lockstep. It is the only source of randomness of a run, so
+// that -seed makes runs reproducible. It is safe for concurrent use.
+type jitter struct {
+	mu  sync.Mutex
+	rnd *rand.Rand
+}
+
+// newJitter returns a jitter drawing from seed, or from the current time if
+// seed is 0.
+func newJitter(seed int64) *jitter {
+	if seed == 0 {
+		seed = time.Now().UnixNano()
+	}
+	return &jitter{rnd: rand.New(rand.NewSource(seed))}
+}
+
+// delay returns a random duration in [0, max), or 0 if max is not positive.
+func (j *jitter) delay(max time.Duration) time.Duration {
+	if max <= 0 {
+		return 0
+	}
+	j.mu.Lock()
+	defer j.mu.Unlock()
+	return time.Duration(j.rnd.Int63n(int64(max)))
+}
+
+// sleep waits for a random duration in [0, max), reporting false if ctx is
+// done first.
+func (j *jitter) sleep(ctx context.Context, max time.Duration) bool {
+	d := j.delay(max)
+	if d == 0 {
+		return ctx.Err() == nil
+	}
+	t := time.NewTimer(d)
+	defer t.Stop()
+	select {
+	case <-ctx.Done():
+		return false
+	case <-t.C:
+		return true
+	}
+}
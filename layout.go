@@ -0,0 +1,141 @@
+package main
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"net/url"
+	"path"
+	"regexp"
+	"strings"
+	"unicode"
+	"unicode/utf8"
+
+	"github.com/z11i/mandalorian-art-grabber/grill"
+)
+
+// maxCaptionBytes is the length, in bytes, captions are cut to in file names.
+const maxCaptionBytes = 64
+
+// Layouts of the output, chosen with -group-by.
+const (
+	groupNone        = "none"         // every picture at the top of the output
+	groupChapter     = "chapter"      // a directory per chapter, such as chapter-01
+	groupGallery     = "gallery"      // a directory per gallery page, named after its URL
+	groupFirstLetter = "first-letter" // a directory per first letter of the caption
+)
+
+func validGroupBy(groupBy string) error {
+	switch groupBy {
+	case "", groupNone, groupChapter, groupGallery, groupFirstLetter:
+		return nil
+	}
+	return fmt.Errorf("invalid -group-by layout %q", groupBy)
+}
+
+// picName returns the slash-separated name p is stored under. Pictures of
+// other than the default series go in a directory named after the series.
+func (g *grabber) picName(p Picture) string {
+	p.Caption = stripCaptionPrefix(g.stripPrefix, p.Caption)
+	ext := g.conv.ext()
+	if p.Video {
+		ext = grill.ExtMP4
+	}
+	name := sanitizeComponent(baseName(p) + ext)
+	if dir := groupDir(g.cfg.groupBy, p); dir != "" {
+		name = path.Join(dir, name)
+	}
+	if !g.series.isDefault() {
+		name = path.Join(g.series.Name, name)
+	}
+	return name
+}
+
+// baseName returns the file name of p without extension: its caption and ID,
+// prefixed by the kind of gallery for other than concept art. Pictures without
+// a caption are named after their chapter and position in the gallery
+// instead, and pictures without an ID after a hash of their URL.
+func baseName(p Picture) string {
+	id := p.ID
+	if id == "" {
+		sum := sha256.Sum256([]byte(p.URL))
+		id = hex.EncodeToString(sum[:6])
+	}
+	caption := strings.TrimSpace(p.Caption)
+	if caption == "" {
+		caption = fmt.Sprintf("chapter-%02d_%03d", p.Chapter, p.Index+1)
+	}
+	caption = strings.TrimRight(truncateUTF8(caption, maxCaptionBytes), " ")
+	if p.Kind != "" {
+		return p.Kind + "_" + caption + "_" + id
+	}
+	return caption + "_" + id
+}
+
+// truncateUTF8 returns s cut to at most n bytes, without splitting a
+// character.
+func truncateUTF8(s string, n int) string {
+	if len(s) <= n {
+		return s
+	}
+	for n > 0 && !utf8.RuneStart(s[n]) {
+		n--
+	}
+	return s[:n]
+}
+
+// stripCaptionPrefix returns caption without the start re matches, such as
+// boilerplate naming the series, for file names. The caption is kept whole if
+// re is nil or nothing would be left of it.
+func stripCaptionPrefix(re *regexp.Regexp, caption string) string {
+	if re == nil {
+		return caption
+	}
+	loc := re.FindStringIndex(caption)
+	if loc == nil {
+		return caption
+	}
+	if rest := strings.TrimSpace(caption[loc[1]:]); rest != "" {
+		return rest
+	}
+	return caption
+}
+
+// groupDir returns the directory p is stored in under groupBy, or "" for the
+// top of the output.
+func groupDir(groupBy string, p Picture) string {
+	switch groupBy {
+	case groupChapter:
+		return fmt.Sprintf("chapter-%02d", p.Chapter)
+	case groupGallery:
+		u, err := url.Parse(p.Page)
+		if err != nil {
+			return "unknown-gallery"
+		}
+		return sanitizeComponent(path.Base(strings.TrimSuffix(u.Path, "/")))
+	case groupFirstLetter:
+		for _, r := range p.Caption {
+			if unicode.IsLetter(r) || unicode.IsDigit(r) {
+				return string(unicode.ToUpper(r))
+			}
+		}
+		return "_"
+	}
+	return ""
+}
+
+// sanitizeComponent makes s safe to use as a single path component on any
+// file system, replacing separators and characters reserved on Windows.
+func sanitizeComponent(s string) string {
+	s = strings.Map(func(r rune) rune {
+		if r < ' ' || strings.ContainsRune(`/\:*?"<>|`, r) {
+			return '_'
+		}
+		return r
+	}, s)
+	s = strings.TrimRight(s, ". ")
+	if s == "" || s == "." || s == ".." {
+		return "_"
+	}
+	return s
+}
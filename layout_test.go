@@ -0,0 +1,81 @@
+package main
+
+import (
+	"strings"
+	"testing"
+	"unicode/utf8"
+
+	"github.com/z11i/mandalorian-art-grabber/grill"
+)
+
+func TestBaseName(t *testing.T) {
+	const url = "https://example.com/x.jpeg"
+	long := strings.Repeat("a", 70)
+	tests := []struct {
+		name    string
+		caption string
+		id      string
+		kind    string
+		want    string
+	}{
+		{"plain", "The Child", "123", "", "The Child_123"},
+		{"trimmed", "  The Child \t", "123", "", "The Child_123"},
+		{"empty caption", "", "123", "", "chapter-07_003_123"},
+		{"blank caption", " \t\n ", "123", "", "chapter-07_003_123"},
+		{"no id", "The Child", "", "", "The Child_355880c116b1"},
+		{"nothing", "", "", "", "chapter-07_003_355880c116b1"},
+		{"kind", "", "123", "story", "story_chapter-07_003_123"},
+		{"long", long, "123", "", long[:64] + "_123"},
+		{"exactly 64", long[:64], "123", "", long[:64] + "_123"},
+		// Multibyte characters straddling the cut are dropped whole.
+		{"accents", strings.Repeat("é", 40), "123", "", strings.Repeat("é", 32) + "_123"},
+		{"emoji", strings.Repeat("a", 62) + "🚀🚀", "123", "", strings.Repeat("a", 62) + "_123"},
+		{"space at cut", strings.Repeat("a", 63) + " b" + long, "123", "", strings.Repeat("a", 63) + "_123"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			p := Picture{Picture: grill.Picture{URL: url, Caption: tt.caption, ID: tt.id}, Chapter: 7, Index: 2, Kind: tt.kind}
+			got := baseName(p)
+			if got != tt.want {
+				t.Errorf("baseName = %q, want %q", got, tt.want)
+			}
+			if !utf8.ValidString(got) {
+				t.Errorf("baseName %q is not valid UTF-8", got)
+			}
+		})
+	}
+}
+
+func TestPicName(t *testing.T) {
+	g := newTestGrabber(t, testConfig(t, "https://example.com"))
+	tests := []struct {
+		caption, id string
+		want        string
+	}{
+		{"Mando/Grogu", "1", "Mando_Grogu_1.jpeg"},
+		{`a\b:c*d?e"f<g>h|i`, "2", "a_b_c_d_e_f_g_h_i_2.jpeg"},
+		{"tab\there", "3", "tab_here_3.jpeg"},
+		{"..", "..", ".._...jpeg"},
+		{"", "../../etc/passwd", "chapter-01_001_.._.._etc_passwd.jpeg"},
+	}
+	for _, tt := range tests {
+		p := Picture{Picture: grill.Picture{URL: "https://example.com/x.jpeg", Caption: tt.caption, ID: tt.id}, Chapter: 1}
+		if got := g.picName(p); got != tt.want {
+			t.Errorf("picName(%q, %q) = %q, want %q", tt.caption, tt.id, got, tt.want)
+		}
+	}
+}
+
+func TestTruncateUTF8(t *testing.T) {
+	for _, s := range []string{"", "abc", "héllo", "日本語のテキスト", "🚀🚀🚀"} {
+		for n := 0; n <= len(s)+1; n++ {
+			got := truncateUTF8(s, n)
+			if len(got) > n || !utf8.ValidString(got) || !strings.HasPrefix(s, got) {
+				t.Errorf("truncateUTF8(%q, %d) = %q", s, n, got)
+			}
+			if n >= len(s) && got != s {
+				t.Errorf("truncateUTF8(%q, %d) = %q, want it whole", s, n, got)
+			}
+		}
+	}
+}
@@ -0,0 +1,44 @@
+package main
+
+import "sync/atomic"
+
+// downloadLimit caps the number of pictures downloaded over a run. Workers
+// reserve a download before starting it, so that no more than the limit are
+// ever in progress or done, and give the reservation back if the download
+// does not succeed. It is safe for concurrent use.
+type downloadLimit struct {
+	max int64
+
+	reserved int64 // accessed atomically
+	done     int64 // accessed atomically
+}
+
+// reserve reports whether another download may be started.
+func (l *downloadLimit) reserve() bool {
+	if l == nil {
+		return true
+	}
+	if atomic.AddInt64(&l.reserved, 1) > l.max {
+		atomic.AddInt64(&l.reserved, -1)
+		return false
+	}
+	return true
+}
+
+// release gives back the reservation of a download that did not succeed.
+func (l *downloadLimit) release() {
+	if l != nil {
+		atomic.AddInt64(&l.reserved, -1)
+	}
+}
+
+// complete records a successful download, reporting whether it was the last
+// one allowed.
+func (l *downloadLimit) complete() bool {
+	return l != nil && atomic.AddInt64(&l.done, 1) == l.max
+}
+
+// reached reports whether the limit of downloads was reached.
+func (l *downloadLimit) reached() bool {
+	return l != nil && atomic.LoadInt64(&l.done) >= l.max
+}
@@ -0,0 +1,54 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"time"
+)
+
+// lockFile is the name of the lock file that keeps two runs from writing to
+// the same state directory at once.
+const lockFile = ".grabber.lock"
+
+// lockPollInterval is how often a waiting run checks whether the lock was
+// released.
+const lockPollInterval = time.Second
+
+// dirLock is an advisory lock on an state directory, held by creating the
+// lock file exclusively.
+type dirLock struct {
+	path string
+}
+
+// lockDir locks dir. If another run holds the lock, lockDir fails unless wait
+// is set, in which case it waits until the lock is released or ctx is done.
+func lockDir(ctx context.Context, dir string, wait bool) (*dirLock, error) {
+	path := filepath.Join(dir, lockFile)
+	for {
+		f, err := os.OpenFile(path, os.O_WRONLY|os.O_CREATE|os.O_EXCL, 0600)
+		if err == nil {
+			fmt.Fprintf(f, "%d\n", os.Getpid())
+			f.Close()
+			return &dirLock{path: path}, nil
+		}
+		if !errors.Is(err, os.ErrExist) {
+			return nil, err
+		}
+		if !wait {
+			return nil, fmt.Errorf("%s is in use by another run; use -wait-lock to wait for it, or remove %s if no other run is active", dir, path)
+		}
+		select {
+		case <-ctx.Done():
+			return nil, ctx.Err()
+		case <-time.After(lockPollInterval):
+		}
+	}
+}
+
+// unlock releases the lock.
+func (l *dirLock) unlock() error {
+	return os.Remove(l.path)
+}
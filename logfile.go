@@ -0,0 +1,74 @@
+package main
+
+import (
+	"fmt"
+	"os"
+	"sync"
+)
+
+// logFile is a log file opened for appending that is rotated once it would
+// grow past max bytes: it is renamed with a .1 suffix, replacing the previous
+// one, and a new file is started. It is safe for concurrent use.
+type logFile struct {
+	name string
+	max  int64 // 0 for no rotation
+
+	mu   sync.Mutex
+	f    *os.File
+	size int64
+}
+
+// openLogFile opens name for appending, creating it if needed.
+func openLogFile(name string, max int64) (*logFile, error) {
+	l := &logFile{name: name, max: max}
+	if err := l.open(); err != nil {
+		return nil, fmt.Errorf("unable to open log file: %w", err)
+	}
+	return l, nil
+}
+
+func (l *logFile) open() error {
+	f, err := os.OpenFile(l.name, os.O_WRONLY|os.O_APPEND|os.O_CREATE, 0644)
+	if err != nil {
+		return err
+	}
+	info, err := f.Stat()
+	if err != nil {
+		f.Close()
+		return err
+	}
+	l.f, l.size = f, info.Size()
+	return nil
+}
+
+func (l *logFile) Write(p []byte) (int, error) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	if l.max > 0 && l.size > 0 && l.size+int64(len(p)) > l.max {
+		if err := l.rotate(); err != nil {
+			// Keep logging to the current file rather than losing lines.
+			fmt.Fprintf(os.Stderr, "unable to rotate log file: %v\n", err)
+		}
+	}
+	n, err := l.f.Write(p)
+	l.size += int64(n)
+	return n, err
+}
+
+func (l *logFile) rotate() error {
+	if err := os.Rename(l.name, l.name+".1"); err != nil {
+		return err
+	}
+	old := l.f
+	if err := l.open(); err != nil {
+		return err
+	}
+	return old.Close()
+}
+
+// Close closes the file.
+func (l *logFile) Close() error {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	return l.f.Close()
+}
@@ -1,239 +1,1252 @@
 package main
 
 import (
+	"bufio"
+	"bytes"
 	"context"
-	"encoding/json"
+	"crypto/sha256"
+	"encoding/hex"
 	"errors"
 	"fmt"
 	"io"
 	"log"
 	"net/http"
+	"net/url"
 	"os"
 	"os/signal"
+	"path/filepath"
 	"regexp"
+	"strings"
 	"sync"
+	"sync/atomic"
+	"syscall"
+	"time"
 
-	"github.com/antchfx/htmlquery"
-	"github.com/antchfx/xpath"
-	"github.com/mitchellh/mapstructure"
+	"github.com/z11i/mandalorian-art-grabber/grill"
 	"golang.org/x/net/html"
+	"golang.org/x/time/rate"
 )
 
 const (
-	startChapter = 1
-	endChapter   = 16
-	worker       = 5
+	worker = 5
+
+	// picsPerWorker is how many parsed pictures may queue up per download
+	// worker. Once the queue is full, gallery fetchers block until a worker
+	// takes a picture, so parsing never runs far ahead of downloading and
+	// memory stays bounded however many chapters are fetched.
+	picsPerWorker = 2
 )
 
+// Picture is a picture of a gallery, as parsed by package grill, with where
+// it was found. Its caption is normalized, see normalizeCaption.
 type Picture struct {
-	URL     string
-	Caption string
-	ID      string
+	grill.Picture
+	Page       string // final URL of the gallery page the picture was found on
+	Chapter    int
+	Index      int    // position in the gallery
+	Archived   bool   // found on the Wayback Machine's copy of a removed gallery
+	Kind       string // kind of gallery the picture is in: "" for concept art, kindStory or kindTrivia
+	RawCaption string // caption as the gallery gives it, if normalizing changed it
+}
+
+type config struct {
+	bwLimit            byteSize      // total download bandwidth in bytes per second, 0 for unlimited
+	galleryWorkers     int           // number of concurrent gallery page fetchers
+	convert            string        // format to convert downloaded images to, empty to keep the source format
+	jpegQuality        int           // quality used when encoding jpeg
+	baseURL            string        // scheme and host the gallery pages are fetched from
+	series             string        // name of the series to fetch, see builtinSeries
+	sourcesFile        string        // JSON file defining series besides the built-in ones
+	output             string        // where pictures are stored: a local directory or s3://bucket/prefix
+	dirMode            permMode      // permissions of directories created in a local output
+	fileMode           permMode      // permissions of files written to a local output
+	groupBy            string        // subdirectory layout of the output: none, chapter, gallery or first-letter
+	mirror             bool          // remove pictures no gallery lists any more
+	mirrorDryRun       bool          // only log the pictures mirroring would remove
+	thumbs             int           // maximum dimension of thumbnails, 0 for none
+	headCheck          bool          // only check that pictures can be downloaded, with HEAD requests
+	watch              bool          // keep running, polling for new chapters and pictures
+	pollInterval       time.Duration // pause between polls in watch mode
+	stateDir           string        // local directory for bookkeeping files, defaults to the output directory
+	contentDedup       string        // what to do with files whose content was already downloaded: off, skip or link
+	rendition          string        // rendition to download when a gallery gives several: largest or smallest
+	captions           bool          // write the full caption of each picture to a sidecar text file
+	ignoreState        bool          // download pictures again even if the state file says they are complete
+	waitLock           bool          // wait for another run using the output directory instead of failing
+	keepDuplicates     bool          // download pictures sharing an ID with an earlier one instead of dropping them
+	ordered            bool          // download pictures by chapter and gallery position
+	sequentialChapters bool          // finish each chapter before starting the next
+	checkImages        bool          // check the header of downloaded pictures and download them again if it is broken
+	verifyImages       bool          // decode downloaded pictures and download them again if they are broken
+	verifyRetries      int           // further attempts at a picture that does not decode
+	overwrite          string        // when a download replaces an existing file: always, never, newer or larger
+	fullRes            bool          // download the original size of pictures rather than the rendition in the gallery
+	cacheDir           string        // directory keeping the HTML of gallery pages, empty for none
+	cacheTTL           time.Duration // how long cached HTML is used for, 0 for ever
+	refreshCache       bool          // fetch gallery pages again even if their HTML is cached
+	retryDelay         time.Duration // pause before retrying failed downloads at the end of a run
+	maxRetryWait       time.Duration // longest pause for a rate-limited download, whatever Retry-After says
+	noFollow           bool          // do not follow redirects
+	verbose            bool          // log debugging details
+	quiet              bool          // do not log every picture handled
+	galleryTimeout     time.Duration // deadline for fetching a single gallery page, 0 for none
+	downloadTimeout    time.Duration // deadline for downloading a single picture, body included, 0 for none
+	perHost            int           // maximum concurrent requests to a single host
+	caFile             string        // PEM file of extra root certificates to trust
+	proxyList          string        // file of proxy URLs to rotate requests through, empty for none
+	breakerThreshold   int           // consecutive failed requests that pause all requests, 0 for never
+	breakerCooldown    time.Duration // how long requests are paused for before a probe
+	cookies            []string      // cookies to send with every request, each name=value
+	basicAuth          string        // user:password to authenticate every request with, empty for none
+	insecure           bool          // do not verify TLS certificates
+	startJitter        time.Duration // download workers start at random times within this
+	adaptive           bool          // lower download concurrency while the site throttles or slows down
+	requestJitter      time.Duration // random pause of up to this before each download
+	seed               int64         // seed of every random choice, 0 for a different one every run
+	metricsAddr        string        // address to serve Prometheus metrics on, empty for none
+	metricsFile        string        // file to write a metrics snapshot to at the end of the run, empty for none
+	pprofAddr          string        // address to serve runtime profiles on, empty for none
+	minFree            byteSize      // free space to keep on a local output's file system, 0 for no check
+	maxTotal           byteSize      // bytes to write to the output over the run, 0 for unlimited
+	maxPages           int           // pages of a paginated gallery to load at most, 0 for no limit
+	maxTotalAbort      bool          // abort downloads in progress once maxTotal is reached instead of letting them finish
+	limit              int           // pictures to download over the run before stopping, 0 for no limit
+	discover           bool          // also fetch the galleries listed in the site's sitemap
+	discoverPattern    string        // regular expression the sitemap's gallery URLs must match, empty for the series' concept art
+	stripPrefix        string        // regular expression matching the start of captions to leave out of file names
+	sitemapURL         string        // sitemap to discover galleries in, empty for the base URL's
+	detectMisses       int           // chapters past the series' last in a row found unpublished before no more are looked for, 0 not to look
+	allChapters        bool          // no chapters were selected, so galleries without a chapter are wanted too
+	idsFile            string        // file of the only picture IDs to download, empty for all
+	excludeIDs         string        // file of picture IDs not to download, empty for none
+	segments           int           // ranged requests a large picture is downloaded with at once, 1 for a single request
+	segmentThreshold   byteSize      // size from which pictures are downloaded in segments
+	wayback            bool          // look for galleries missing from the site in the Wayback Machine
+	story              bool          // also download the story gallery of each chapter
+	trivia             bool          // also download the images of the trivia gallery of each chapter
+	videos             bool          // also download the mp4 video clips of galleries
+	logFile            string        // file to append the log to, empty for none
+	logMaxSize         byteSize      // size from which the log file is rotated, 0 for never
+	logFileOnly        bool          // log to logFile only, not to stderr
+	tagMetadata        bool          // write the caption and origin of pictures into their metadata
+	asciiCaptions      bool          // replace typographic punctuation in captions by ASCII
+	strict             bool          // stop the run at the first gallery page that cannot be parsed
+	stopOnFirstError   bool          // stop the run at the first gallery page or picture that fails
+	interactive        bool          // list the pictures found and ask which to download
+	jsonl              bool          // write an event per picture downloaded, skipped or failed to stdout
+
+	// OnDownloaded, if set, is called with each picture downloaded, the
+	// path or URL it is stored at and its size in bytes, once the file is
+	// complete in the output. It runs on the download workers, so it is
+	// called concurrently and must be safe for that, and the worker calling
+	// it waits for it to return.
+	OnDownloaded func(p Picture, localPath string, size int64)
 }
 
 func main() {
-	var chapters []int
-	for i := startChapter; i <= endChapter; i++ {
-		chapters = append(chapters, i)
+	os.Exit(realMain())
+}
+
+// Exit statuses.
+const (
+	exitOK          = 0
+	exitFailure     = 1   // a gallery page or picture could not be downloaded
+	exitNothing     = 2   // nothing was downloaded, nor found already downloaded
+	exitInterrupted = 130 // the run was interrupted
+)
+
+// exitCode returns the exit status for a run that returned err.
+func (g *grabber) exitCode(work context.Context, err error) int {
+	switch {
+	case work.Err() != nil:
+		return exitInterrupted
+	case err != nil:
+		return exitFailure
+	case atomic.LoadInt64(&g.stats.downloaded)+atomic.LoadInt64(&g.stats.skipped)+atomic.LoadInt64(&g.stats.reachable) == 0:
+		return exitNothing
+	case len(g.failed.list()) > 0, atomic.LoadInt64(&g.stats.galleryErrors) > 0:
+		return exitFailure
+	}
+	return exitOK
+}
+
+// handleInterrupts calls stopWork on the first interrupt, so that no new work
+// is started while downloads in progress complete, and abort on the second.
+func handleInterrupts(stopWork, abort context.CancelFunc) {
+	sigs := make(chan os.Signal, 2)
+	signal.Notify(sigs, os.Interrupt, syscall.SIGTERM)
+	<-sigs
+	log.Print("interrupted: finishing downloads in progress, press Ctrl-C again to abort them")
+	stopWork()
+	<-sigs
+	log.Print("interrupted again: aborting")
+	abort()
+}
+
+// grabber holds the configuration and shared state of a run.
+type grabber struct {
+	cfg       config
+	series    series   // fetched
+	allSeries []series // built-in and defined with -sources
+	client    *http.Client
+	fetcher   PageFetcher // of gallery pages, and of pages read whole such as by chapter detection
+	store     Storer
+	bw        *rate.Limiter // shared by all download workers, nil for unlimited
+	conv      converter
+
+	hashes *hashIndex // nil unless content deduplication is enabled
+
+	manifest        manifest
+	pages           *galleryCache
+	html            *htmlCache // nil unless gallery HTML is cached
+	sums            *checksums
+	jitter          *jitter
+	thumbs          *thumbnailer     // nil unless thumbnails are made
+	adaptive        *adaptiveLimiter // nil unless download concurrency adapts
+	paused          pauser
+	proxies         *proxyRotator // nil unless requests go through a proxy list
+	completion      *completion
+	state           *stateStore
+	failed          failureLog
+	stats           stats
+	started         time.Time // when downloads began
+	inflight        inflight
+	events          *eventStream   // nil unless events are written to stdout
+	answers         *bufio.Scanner // of the standard input, nil unless -interactive
+	disk            *diskGuard     // nil unless free space is checked
+	ids             *idFilter      // nil unless pictures are selected by ID
+	budget          *byteBudget    // nil unless the bytes written are capped
+	limit           *downloadLimit // nil unless the number of downloads is capped
+	discover        *regexp.Regexp // gallery URLs to fetch from the sitemap, nil unless -discover
+	stripPrefix     *regexp.Regexp // caption prefix left out of file names, nil for none
+	sitemap         sitemapPages
+	galleryFailures galleryFailures
+
+	haltOnce sync.Once
+	haltErr  error // why the run was stopped early
+	stopWork context.CancelFunc
+
+	fetched    keySet // final URLs of the gallery pages fetched so far
+	expected   keySet // names of every picture found, when mirroring
+	missing    int64  // chapters without a gallery, or with an empty one
+	truncated  int64  // galleries with pages past -max-pages left unloaded, accessed atomically
+	highest    int64  // highest chapter with a gallery
+	concept    conceptOrder
+	seen       keySet
+	duplicates int64 // pictures sharing an ID with one already seen, accessed atomically
+}
+
+func newGrabber(cfg config) (*grabber, error) {
+	if cfg.stateDir == "" {
+		cfg.stateDir = ".grabber"
+		if isLocalOutput(cfg.output) {
+			cfg.stateDir = cfg.output
+		}
+	}
+	if err := validPermModes(cfg.dirMode, cfg.fileMode); err != nil {
+		return nil, err
+	}
+	store, err := newStorer(context.Background(), cfg.output, os.FileMode(cfg.dirMode), os.FileMode(cfg.fileMode))
+	if err != nil {
+		return nil, err
+	}
+	conv, err := newConverter(cfg.convert, cfg.jpegQuality)
+	if err != nil {
+		return nil, err
+	}
+	allSeries, err := loadSeries(cfg.sourcesFile)
+	if err != nil {
+		return nil, err
+	}
+	series, err := lookupSeries(allSeries, cfg.series)
+	if err != nil {
+		return nil, err
+	}
+	if cfg.story && series.Story == "" {
+		return nil, fmt.Errorf("-story: series %s has no story gallery", series.Name)
+	}
+	if cfg.trivia && series.Trivia == "" {
+		return nil, fmt.Errorf("-trivia: series %s has no trivia gallery", series.Name)
+	}
+	if u, err := url.Parse(cfg.baseURL); err != nil || (u.Scheme != "http" && u.Scheme != "https") || u.Host == "" {
+		return nil, fmt.Errorf("invalid base URL %q", cfg.baseURL)
+	}
+	var proxies *proxyRotator
+	if cfg.proxyList != "" {
+		if proxies, err = loadProxyList(cfg.proxyList); err != nil {
+			return nil, err
+		}
+		proxies.verbose = cfg.verbose
+	}
+	client, err := newHTTPClient(cfg, proxies)
+	if err != nil {
+		return nil, err
+	}
+	g := &grabber{
+		cfg:        cfg,
+		series:     series,
+		allSeries:  allSeries,
+		client:     client,
+		fetcher:    httpFetcher{client: client},
+		proxies:    proxies,
+		store:      store,
+		bw:         newBandwidthLimiter(int64(cfg.bwLimit)),
+		conv:       conv,
+		pages:      loadGalleryCache(cfg.stateDir),
+		sums:       newChecksums(),
+		completion: newCompletion(),
+		jitter:     newJitter(cfg.seed),
+	}
+	if cfg.adaptive {
+		g.adaptive = newAdaptiveLimiter(worker)
+	}
+	if cfg.cacheDir != "" {
+		g.html = &htmlCache{dir: cfg.cacheDir, ttl: cfg.cacheTTL, refresh: cfg.refreshCache}
+	}
+	if cfg.maxTotal > 0 {
+		g.budget = &byteBudget{limit: int64(cfg.maxTotal), abort: cfg.maxTotalAbort}
+	}
+	if cfg.limit > 0 {
+		g.limit = &downloadLimit{max: int64(cfg.limit)}
+	}
+	if cfg.interactive {
+		g.answers = bufio.NewScanner(os.Stdin)
+	}
+	if cfg.discover {
+		if g.discover, err = g.discoverPattern(); err != nil {
+			return nil, err
+		}
+		if g.cfg.sitemapURL == "" {
+			g.cfg.sitemapURL = strings.TrimSuffix(cfg.baseURL, "/") + "/sitemap.xml"
+		}
+	}
+	if cfg.stripPrefix != "" {
+		if g.stripPrefix, err = regexp.Compile(`^(?:` + cfg.stripPrefix + `)`); err != nil {
+			return nil, fmt.Errorf("invalid -strip-prefix: %w", err)
+		}
+	}
+	if g.ids, err = loadIDFilter(cfg.idsFile, cfg.excludeIDs); err != nil {
+		return nil, err
+	}
+	if cfg.quiet && cfg.verbose {
+		return nil, errors.New("-quiet and -v cannot be combined")
+	}
+	if err := validGroupBy(cfg.groupBy); err != nil {
+		return nil, err
+	}
+	if err := validOverwritePolicy(cfg.overwrite); err != nil {
+		return nil, err
+	}
+	if err := validRendition(cfg.rendition); err != nil {
+		return nil, err
+	}
+	switch cfg.contentDedup {
+	case "", "off":
+	case "skip", "link":
+		if g.hashes, err = loadHashIndex(cfg.stateDir); err != nil {
+			return nil, err
+		}
+	default:
+		return nil, fmt.Errorf("invalid -dedup-content mode %q", cfg.contentDedup)
+	}
+	return g, nil
+}
+
+// run downloads the galleries of chapters, returning once every picture has
+// been handled or ctx is done. Failures to download individual gallery pages
+// and pictures are counted rather than returned. Once work is done, no new gallery page or
+// picture is fetched, but downloads in progress run to completion unless ctx
+// is done too. work must be derived from ctx.
+func (g *grabber) run(ctx, work context.Context, chapters []int) error {
+	if isLocalOutput(g.cfg.output) {
+		if err := mkdirAllMode(g.cfg.output, os.FileMode(g.cfg.dirMode)); err != nil {
+			return fmt.Errorf("unable to create download directory: %w", err)
+		}
+		if g.cfg.minFree > 0 {
+			g.disk = &diskGuard{dir: g.cfg.output, minFree: uint64(g.cfg.minFree)}
+			if err := g.disk.check(true); err != nil {
+				return err
+			}
+		}
+	}
+	if err := os.MkdirAll(g.cfg.stateDir, 0700); err != nil {
+		return fmt.Errorf("unable to create state directory: %w", err)
+	}
+	work, g.stopWork = context.WithCancel(work)
+	defer g.stopWork()
+
+	lock, err := lockDir(work, g.cfg.stateDir, g.cfg.waitLock)
+	if err != nil {
+		return fmt.Errorf("unable to lock download directory: %w", err)
+	}
+	defer func() {
+		if err := lock.unlock(); err != nil {
+			log.Printf("unable to release lock: %v", err)
+		}
+	}()
+
+	g.state = openState(g.cfg.stateDir, g.cfg.ignoreState)
+	defer func() {
+		if err := g.state.close(); err != nil {
+			log.Printf("unable to save state: %v", err)
+		}
+	}()
+
+	if g.cfg.metricsAddr != "" {
+		metricsCtx, stopMetrics := context.WithCancel(context.Background())
+		defer stopMetrics()
+		g.serveMetrics(metricsCtx, g.cfg.metricsAddr)
+	}
+
+	if g.cfg.thumbs > 0 {
+		g.thumbs = newThumbnailer(ctx, g.store, g.cfg.thumbs, g.cfg.jpegQuality)
+	}
+	if g.cfg.jsonl {
+		g.events = newEventStream(os.Stdout)
+	}
+	g.started = time.Now()
+	defer g.handleStatusSignal()()
+	if g.cfg.sequentialChapters {
+		for _, chap := range chapters {
+			if work.Err() != nil {
+				break
+			}
+			g.fetchChapters(ctx, work, []int{chap})
+		}
+	} else {
+		g.fetchChapters(ctx, work, chapters)
+	}
+	if g.thumbs != nil {
+		g.thumbs.close()
+	}
+	g.events.close()
+	if g.cfg.headCheck {
+		g.logHeadCheck()
+	} else if g.cfg.mirror {
+		if work.Err() != nil {
+			log.Printf("not pruning: the run was stopped early")
+		} else {
+			g.pruneOutput(g.cfg.mirrorDryRun)
+		}
+	}
+
+	g.manifest.Duplicates = atomic.LoadInt64(&g.duplicates)
+	log.Printf("duplicates: %d", g.manifest.Duplicates)
+	if g.ids != nil {
+		log.Printf("ID filter: %d included, %d excluded",
+			atomic.LoadInt64(&g.ids.included), atomic.LoadInt64(&g.ids.excluded))
+	}
+	log.Printf("summary: %d downloaded, %d skipped, %d failed, %d gallery pages failed",
+		atomic.LoadInt64(&g.stats.downloaded), atomic.LoadInt64(&g.stats.skipped),
+		len(g.failed.list()), atomic.LoadInt64(&g.stats.galleryErrors))
+	if g.proxies != nil {
+		g.proxies.logStatus()
+	}
+	if g.budget != nil {
+		g.budget.logUsage()
+	}
+	if g.limit.reached() {
+		log.Printf("limit: reached, stopped after %d downloads", g.cfg.limit)
+	}
+	if g.adaptive != nil {
+		log.Printf("adaptive: download concurrency ended at %d of %d", g.adaptive.current(), worker)
 	}
-	ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt)
-	defer stop()
-	urls := generateGalleryURLs(ctx, chapters)
-	pics := downloadGalleryHTML(ctx, urls)
+	elapsed := time.Since(g.started)
+	received := atomic.LoadInt64(&g.stats.received)
+	log.Printf("received %s in %v (%s/s)", formatBytes(received), elapsed.Round(time.Millisecond),
+		formatBytes(int64(float64(received)/elapsed.Seconds())))
+	g.galleryFailures.report()
+	if !g.cfg.headCheck {
+		g.completion.report()
+		g.saveOutputs()
+	}
+	if err := g.pages.save(); err != nil {
+		log.Printf("unable to save gallery cache: %v", err)
+	}
+	if g.cfg.metricsFile != "" {
+		if err := g.saveMetrics(g.cfg.metricsFile); err != nil {
+			log.Printf("unable to save metrics: %v", err)
+		}
+	}
+	return g.haltErr
+}
+
+// fetchChapters downloads every picture in the galleries of chapters, and
+// retries the downloads that failed.
+func (g *grabber) fetchChapters(ctx, work context.Context, chapters []int) {
+	galleries := g.generateGalleryURLs(work, chapters)
+	pics := g.downloadGalleryHTML(work, galleries)
+	if g.ids != nil {
+		pics = g.filterPics(work, pics)
+	}
+	if g.cfg.ordered {
+		// Order before deduplicating, so the same copy of a repeated
+		// picture is kept on every run.
+		pics = orderPics(work, pics)
+	}
+	pics = g.dedupPics(work, pics)
+	if g.cfg.interactive {
+		// The listing goes to stderr, as stdout may carry -jsonl events.
+		pics = g.pickPics(work, pics, g.answers, os.Stderr)
+	}
+	g.downloadAll(ctx, work, pics)
+	g.retryFailed(ctx, work)
+}
 
+// saveOutputs writes the hash index, manifest and checksums of the run. They
+// are written even after an abort, so that they list what was downloaded
+// before it.
+func (g *grabber) saveOutputs() {
+	ctx := context.Background()
+	if g.hashes != nil {
+		if err := g.hashes.save(); err != nil {
+			log.Printf("unable to save hash index: %v", err)
+		}
+	}
+	if err := g.manifest.save(ctx, g.store); err != nil {
+		log.Printf("unable to save manifest: %v", err)
+	}
+	if done, err := readState(g.cfg.stateDir); err != nil {
+		log.Printf("unable to list earlier downloads in %s: %v", sumsFile, err)
+	} else {
+		g.sums.addEarlier(done)
+	}
+	if err := g.sums.save(ctx, g.store); err != nil {
+		log.Printf("unable to save %s: %v", sumsFile, err)
+	}
+}
+
+// halt stops the run early because of err: no new work is started, and run
+// returns err once downloads in progress are done.
+func (g *grabber) halt(err error) {
+	g.haltOnce.Do(func() {
+		g.haltErr = err
+		log.Printf("stopping: %v", err)
+		g.stopWork()
+	})
+}
+
+// downloadAll downloads the pictures from pics with the download workers,
+// returning once pics is drained or work is done.
+func (g *grabber) downloadAll(ctx, work context.Context, pics <-chan Picture) {
 	var wg sync.WaitGroup
 	wg.Add(worker)
 	for i := 0; i < worker; i++ {
-		downloadPic(ctx, &wg, pics)
+		go func() {
+			// Stagger the workers so that they do not start in lockstep.
+			if !g.jitter.sleep(work, g.cfg.startJitter) {
+				wg.Done()
+				return
+			}
+			g.downloadPic(ctx, work, &wg, pics)
+		}()
 	}
 	wg.Wait()
 }
 
-func generateGalleryURLs(ctx context.Context, chapters []int) <-chan string {
-	const (
-		urlConcept  = "https://www.starwars.com/series/the-mandalorian/chapter-%d-concept-art-gallery"
-		urlConcept2 = "https://www.starwars.com/chapter-%d-concept-art-gallery"
-		// urlStory   = "https://www.starwars.com/series/the-mandalorian/chapter-%d-story-gallery"
-		// urlTrivia  = "https://www.starwars.com/series/the-mandalorian/chapter-%d-trivia-gallery"
-	)
+// gallery is a chapter's gallery page, which may be published under any of
+// several URLs.
+type gallery struct {
+	chapter   int
+	kind      string   // "" for concept art, kindStory or kindTrivia
+	urls      []string // candidate URLs, tried in order until one is found
+	templates bool     // urls are those of the series' concept art templates, in order
+}
+
+// Kinds of gallery other than concept art.
+const (
+	kindStory  = "story"  // episode stills
+	kindTrivia = "trivia" // factoids, some with images
+)
+
+const defaultBaseURL = "https://www.starwars.com"
 
-	urls := make(chan string, 3)
+// generateGalleryURLs sends a gallery for each of chapters, followed by its
+// story and trivia galleries if those are wanted, with candidate URLs rooted
+// at the configured base URL. The galleries discovered in the sitemap at
+// other URLs follow, with -discover.
+func (g *grabber) generateGalleryURLs(ctx context.Context, chapters []int) <-chan gallery {
+	base := g.cfg.baseURL
+	galleries := make(chan gallery, 3)
 	go func() {
-		defer close(urls)
+		defer close(galleries)
+		known := make(map[string]bool)
 		for _, chap := range chapters {
+			concept := gallery{chapter: chap, templates: true}
+			for _, t := range g.series.Concept {
+				concept.urls = append(concept.urls, galleryURL(base, t, chap))
+			}
+			gals := []gallery{concept}
+			if g.cfg.story {
+				gals = append(gals, gallery{
+					chapter: chap,
+					kind:    kindStory,
+					urls:    []string{galleryURL(base, g.series.Story, chap)},
+				})
+			}
+			if g.cfg.trivia {
+				gals = append(gals, gallery{
+					chapter: chap,
+					kind:    kindTrivia,
+					urls:    []string{galleryURL(base, g.series.Trivia, chap)},
+				})
+			}
+			for _, gal := range gals {
+				for _, u := range gal.urls {
+					known[u] = true
+				}
+				select {
+				case <-ctx.Done():
+					return
+				case galleries <- gal:
+				}
+			}
+		}
+		if g.discover == nil {
+			return
+		}
+		found, err := g.discoverGalleries(ctx, chapters, known)
+		if err != nil {
+			log.Printf("warning: no galleries discovered: %v", err)
+			return
+		}
+		g.infof("discovered %d more galleries in the sitemap", len(found))
+		for _, gal := range found {
 			select {
 			case <-ctx.Done():
 				return
-			default:
+			case galleries <- gal:
 			}
-
-			urls <- fmt.Sprintf(urlConcept, chap)
-			urls <- fmt.Sprintf(urlConcept2, chap)
 		}
 	}()
-	return urls
+	return galleries
 }
 
-func downloadGalleryHTML(ctx context.Context, urls <-chan string) (picURLs <-chan Picture) {
-	picChan := make(chan Picture, 10)
+// downloadGalleryHTML fetches gallery pages from galleries with the configured
+// number of concurrent workers and sends the parsed pictures to the returned channel,
+// which is closed once every fetcher has finished.
+func (g *grabber) downloadGalleryHTML(ctx context.Context, galleries <-chan gallery) (picURLs <-chan Picture) {
+	picChan := make(chan Picture, worker*picsPerWorker)
+	var wg sync.WaitGroup
+	wg.Add(g.cfg.galleryWorkers)
+	for i := 0; i < g.cfg.galleryWorkers; i++ {
+		go func() {
+			defer wg.Done()
+			for gal := range galleries {
+				g.fetchChapter(ctx, gal, picChan)
+			}
+		}()
+	}
 	go func() {
-		defer close(picChan)
-		for url := range urls {
-			req, err := http.NewRequest(http.MethodGet, url, nil)
-			if err != nil {
-				log.Printf("error creating request: %v", err)
+		wg.Wait()
+		close(picChan)
+	}()
+	return picChan
+}
+
+// fetchChapter tries the candidate URLs of gal in order, moving on to the next
+// one only when a page is not found, so that a gallery published under more
+// than one URL is downloaded once. The URLs of concept art templates are tried
+// in the order learned from earlier chapters. If none is found, their archived
+// copies are tried the same way when the Wayback Machine is to be used.
+func (g *grabber) fetchChapter(ctx context.Context, gal gallery, picChan chan<- Picture) {
+	fetchers := []func(ctx context.Context, gal gallery, url string, picChan chan<- Picture) error{g.fetchGallery}
+	if g.cfg.wayback {
+		fetchers = append(fetchers, g.fetchArchivedGallery)
+	}
+	order := make([]int, len(gal.urls))
+	for i := range order {
+		order[i] = i
+	}
+	if gal.templates {
+		order = g.concept.order(len(gal.urls))
+	}
+	for f, fetch := range fetchers {
+		for _, i := range order {
+			url := gal.urls[i]
+			err := fetch(ctx, gal, url, picChan)
+			if ctx.Err() != nil {
+				// The run was stopped; the gallery did not fail.
+				return
+			}
+			if errors.Is(err, errNotFound) {
+				g.debugf("chapter %d: no gallery at %s", gal.chapter, url)
 				continue
 			}
-			err = httpDo(ctx, req, func(resp *http.Response, err error) error {
-				if err != nil {
-					return err
-				}
-				defer resp.Body.Close()
-				doc, err := html.Parse(resp.Body)
-				if err != nil {
-					return err
-				}
-				pics, err := parseForPic(doc)
-				if err != nil {
-					return err
-				}
-				for _, pic := range pics {
-					picChan <- pic
+			if gal.templates && f == 0 {
+				g.concept.found(i)
+				g.debugf("chapter %d: gallery at %s", gal.chapter, url)
+			}
+			switch {
+			case errors.Is(err, grill.ErrNoImages):
+				atomic.AddInt64(&g.missing, 1)
+				log.Printf("warning: no pictures in gallery %s", url)
+			case err != nil:
+				g.galleryFailed(gal, url, err)
+			}
+			if gal.kind != "" {
+				return
+			}
+			g.stats.add(&g.stats.chapters, 1)
+			for {
+				h := atomic.LoadInt64(&g.highest)
+				if int64(gal.chapter) <= h || atomic.CompareAndSwapInt64(&g.highest, h, int64(gal.chapter)) {
+					break
 				}
-				return nil
-			})
-			if err != nil {
-				log.Printf("error downloading gallery html: %v on %s", err, url)
-				continue
 			}
+			return
 		}
-	}()
-	return picChan
+	}
+	atomic.AddInt64(&g.missing, 1)
+	if gal.kind != "" {
+		log.Printf("no %s gallery found for chapter %d", gal.kind, gal.chapter)
+		return
+	}
+	log.Printf("no gallery found for chapter %d", gal.chapter)
 }
 
-var (
-	picDataXpath   = xpath.MustCompile("//div[@id='main']/script")
-	notFoundXpath  = xpath.MustCompile("//div[@id='main']/article[@id='error_page']")
-	picDataPattern = regexp.MustCompile(`this\.Grill\?Grill\.burger=(.*):\(function\(\)`)
-)
+// chapterListing is what was found for a chapter's gallery.
+type chapterListing struct {
+	chapter  int
+	page     string
+	pictures int
+	err      error // errNotFound if no gallery exists
+}
+
+// listChapters loads the gallery of each of chapters without downloading any
+// pictures, returning the listings in chapter order.
+func (g *grabber) listChapters(ctx context.Context, chapters []int) []chapterListing {
+	listings := make([]chapterListing, len(chapters))
+	index := make(map[int]int, len(chapters))
+	for i, chap := range chapters {
+		index[chap] = i
+	}
+	galleries := g.generateGalleryURLs(ctx, chapters)
+	var wg sync.WaitGroup
+	wg.Add(g.cfg.galleryWorkers)
+	for i := 0; i < g.cfg.galleryWorkers; i++ {
+		go func() {
+			defer wg.Done()
+			for gal := range galleries {
+				l := chapterListing{chapter: gal.chapter, err: errNotFound}
+				for _, url := range gal.urls {
+					pics, err := g.loadGalleryPages(ctx, gal, url, nil)
+					if errors.Is(err, errNotFound) {
+						continue
+					}
+					l.page, l.pictures, l.err = url, len(pics), err
+					if len(pics) > 0 {
+						l.page = pics[0].Page
+					}
+					break
+				}
+				listings[index[gal.chapter]] = l
+			}
+		}()
+	}
+	wg.Wait()
+	return listings
+}
 
-func parseForPic(doc *html.Node) ([]Picture, error) {
-	scriptNode := htmlquery.QuerySelector(doc, picDataXpath)
+// fetchGallery downloads and parses the page of gal at url, sending every
+// picture found to picChan. It returns errNotFound if there is no gallery at
+// url.
+func (g *grabber) fetchGallery(ctx context.Context, gal gallery, url string, picChan chan<- Picture) error {
+	pics, err := g.loadGalleryPages(ctx, gal, url, nil)
+	if err != nil {
+		return err
+	}
+	return g.sendPics(ctx, gal, pics, picChan)
+}
 
-	if scriptNode == nil || scriptNode.FirstChild == nil {
-		notFound := htmlquery.QuerySelector(doc, notFoundXpath)
-		if notFound != nil {
-			return nil, nil
+// sendPics sends the pictures of a page of gal to picChan.
+func (g *grabber) sendPics(ctx context.Context, gal gallery, pics []Picture, picChan chan<- Picture) error {
+	g.stats.add(&g.stats.galleries, 1)
+	pics = g.selectVideos(pics)
+	g.stats.add(&g.stats.found, int64(len(pics)))
+	for _, pic := range pics {
+		pic.Chapter = gal.chapter
+		pic.Kind = gal.kind
+		pic = chooseRendition(pic, g.cfg.rendition)
+		if c := normalizeCaption(pic.Caption, g.cfg.asciiCaptions); c != pic.Caption {
+			pic.Caption, pic.RawCaption = c, pic.Caption
+		}
+		select {
+		case picChan <- pic:
+		case <-ctx.Done():
+			return ctx.Err()
 		}
-		return nil, fmt.Errorf("cannot find html node for pictures")
 	}
+	return nil
+}
 
-	captures := picDataPattern.FindSubmatch([]byte(scriptNode.FirstChild.Data))
-	if len(captures) < 2 {
-		return nil, fmt.Errorf("unable to find regex match")
+// loadGallery downloads and parses the gallery page at url, returning its
+// pictures and the link to the next page of the gallery, if it has several. It
+// returns errNotFound if there is no gallery at url, and no pictures if the
+// page was already loaded under another URL. Pages unchanged since they were
+// last parsed are not parsed again, and pages in the HTML cache are not
+// fetched.
+func (g *grabber) loadGallery(ctx context.Context, url string) (pics []Picture, next string, err error) {
+	if page, body, ok := g.html.get(url); ok {
+		g.debugf("using cached html of %s", url)
+		if !g.fetched.add(page) {
+			g.debugf("skipping %s, already fetched as %s", url, page)
+			return nil, "", nil
+		}
+		doc, err := html.Parse(bytes.NewReader(body))
+		if err != nil {
+			return nil, "", err
+		}
+		pics, next, err := parseGalleryPage(doc)
+		if err != nil {
+			return nil, "", err
+		}
+		return setPage(pics, unarchivedURL(page)), next, nil
 	}
 
-	var m map[string]interface{}
-	err := json.Unmarshal(captures[1], &m)
+	header := make(http.Header)
+	if g.html == nil {
+		// A 304 response has no HTML to cache.
+		g.pages.setValidators(url, header)
+	}
+	resp, err := g.fetcher.FetchPage(ctx, url, header)
 	if err != nil {
-		return nil, err
+		return nil, "", err
 	}
-
-	var data struct {
-		Stack []struct {
-			Data []struct {
-				Images []struct {
-					Image   string `mapstructure:"image"`
-					Caption string `mapstructure:"caption"`
-					ID      string `mapstructure:"id"`
-				} `mapstructure:"images"`
-			} `mapstructure:"data"`
-		} `mapstructure:"stack"`
+	if len(resp.Redirects) > 1 {
+		g.debugf("redirected: %s", strings.Join(resp.Redirects, " -> "))
 	}
-	err = mapstructure.Decode(m, &data)
-	if err != nil {
-		return nil, err
+	cached, cachedNext, ok := g.pages.cached(url)
+	switch {
+	case resp.StatusCode == http.StatusNotFound || resp.StatusCode == http.StatusGone:
+		return nil, "", errNotFound
+	case resp.StatusCode == http.StatusNotModified && ok:
+	case resp.StatusCode != http.StatusOK:
+		// Error and throttling pages are not galleries to parse.
+		return nil, "", &statusError{code: resp.StatusCode, status: resp.Status, url: url, retryAfter: resp.Header.Get("Retry-After")}
+	}
+	page := resp.URL
+	if !g.fetched.add(page) {
+		g.debugf("skipping %s, already fetched as %s", url, page)
+		return nil, "", nil
+	}
+	if resp.StatusCode == http.StatusNotModified {
+		pics, next = cached, cachedNext
+	} else {
+		if g.html != nil {
+			if err := g.html.put(url, page, resp.Body); err != nil {
+				log.Printf("unable to cache html of %s: %v", url, err)
+			}
+		}
+		doc, err := html.Parse(bytes.NewReader(resp.Body))
+		if err != nil {
+			return nil, "", err
+		}
+		if pics, next, err = parseGalleryPage(doc); err != nil {
+			return nil, "", err
+		}
+		g.pages.store(url, resp.Header, pics, next)
 	}
+	// Pictures of a copy in the Wayback Machine are given relative to the
+	// page it was taken of.
+	return setPage(pics, unarchivedURL(page)), next, nil
+}
 
-	var pics []Picture
-	defer func() {
-		if e := recover(); e != nil {
-			err = fmt.Errorf("panic: %w", e)
+// setPage records in pics the gallery page they were found on and their
+// position in it. Their URLs, which the gallery may give relative to the page
+// or without a scheme, are resolved against it; pictures left without a URL
+// that can be fetched are dropped with a warning.
+func setPage(pics []Picture, page string) []Picture {
+	base, err := url.Parse(page)
+	if err != nil {
+		base = &url.URL{}
+	}
+	out := make([]Picture, 0, len(pics))
+	for _, p := range pics {
+		u, ok := resolveURL(base, p.URL)
+		if !ok {
+			log.Printf("warning: skipping picture %s of %s, invalid url %q", p.ID, page, p.URL)
+			continue
 		}
-	}()
-	for _, p := range data.Stack[2].Data[0].Images {
-		pics = append(pics, Picture{
-			URL:     p.Image,
-			Caption: p.Caption,
-			ID:      p.ID,
-		})
+		p.URL, p.Page, p.Index = u, page, len(out)
+		if len(p.Variants) > 0 {
+			vs := make([]grill.Variant, 0, len(p.Variants))
+			for _, v := range p.Variants {
+				if v.URL, ok = resolveURL(base, v.URL); ok {
+					vs = append(vs, v)
+				}
+			}
+			p.Variants = vs
+		}
+		out = append(out, p)
+	}
+	return out
+}
+
+// resolveURL returns ref resolved against base, and whether the result is an
+// http or https URL with a host.
+func resolveURL(base *url.URL, ref string) (string, bool) {
+	u, err := url.Parse(strings.TrimSpace(ref))
+	if err != nil {
+		return "", false
+	}
+	u = base.ResolveReference(u)
+	if (u.Scheme != "http" && u.Scheme != "https") || u.Host == "" {
+		return "", false
 	}
-	return pics, nil
+	return u.String(), true
 }
 
-func downloadPic(ctx context.Context, wg *sync.WaitGroup, pics <-chan Picture) {
+// errNotFound is returned when a gallery page does not exist, whether the
+// site answers with a 404 or with its error page.
+var errNotFound = grill.ErrNotFound
+
+// downloadPic downloads pictures from pics into the output until pics is
+// closed or work is done. Requests are bound to ctx, so a download that has
+// started is only cut short when ctx is done. Reads of the response bodies are
+// throttled by the grabber's bandwidth limiter, which is shared by all workers
+// so that the cap is global. Images are written through the grabber's
+// converter.
+func (g *grabber) downloadPic(ctx, work context.Context, wg *sync.WaitGroup, pics <-chan Picture) {
 	defer wg.Done()
 
-	const downloadDir = "download"
-	if err := os.Mkdir(downloadDir, 0700); err != nil && !errors.Is(err, os.ErrExist) {
-		log.Printf("unable to create download directory: %v", err)
-		return
-	}
 	for p := range pics {
 		select {
-		case <-ctx.Done():
+		case <-work.Done():
 			return
 		default:
 		}
-		func() {
-			if len(p.Caption) > 64 {
-				p.Caption = p.Caption[:64+1]
+		if g.state.completed(stateKey(p)) {
+			g.stats.add(&g.stats.skipped, 1)
+			g.completion.complete(p)
+			g.events.send(p, eventSkipped, "", 0, nil)
+			g.infof("skipping %s, already downloaded", p.ID)
+			continue
+		}
+		if err := g.disk.check(false); err != nil {
+			g.halt(err)
+			return
+		}
+		if err := g.budget.check(); err != nil {
+			g.halt(err)
+			return
+		}
+		if !g.limit.reserve() {
+			return
+		}
+		if !g.jitter.sleep(work, g.cfg.requestJitter) {
+			g.limit.release()
+			return
+		}
+		var err error
+		if g.cfg.headCheck {
+			itemCtx, cancel := itemContext(ctx, g.cfg.downloadTimeout)
+			err = g.headPic(itemCtx, p)
+			cancel()
+		} else if g.adaptive != nil {
+			if !g.adaptive.acquire(work) {
+				g.limit.release()
+				return
 			}
-			fname := fmt.Sprintf("download%c%s_%s.jpeg", os.PathSeparator, p.Caption, p.ID)
-			f, err := os.Create(fname)
-			if err != nil {
-				log.Printf("unable to create file: %v", err)
+			start := time.Now()
+			err = g.savePicVerified(ctx, p)
+			g.adaptive.release(err, time.Since(start))
+		} else {
+			err = g.savePicVerified(ctx, p)
+		}
+		if err != nil {
+			g.limit.release()
+		}
+		if errors.Is(err, errBudgetExceeded) {
+			// Aborted part way: the picture is left for a later run.
+			g.halt(err)
+			return
+		}
+		if errors.Is(err, errKept) {
+			g.stats.add(&g.stats.skipped, 1)
+			g.completion.complete(p)
+			g.events.send(p, eventSkipped, g.location(g.picName(p)), 0, nil)
+			g.infof("skipping %s, keeping the existing file", p.ID)
+			continue
+		}
+		if err != nil {
+			g.stats.add(&g.stats.downloadErrors, 1)
+			log.Printf("unable to download file: %v", err)
+			g.failed.add(p, err)
+			g.completion.fail(p, err)
+			g.events.send(p, eventFailed, "", 0, err)
+			if g.cfg.stopOnFirstError {
+				g.halt(fmt.Errorf("-stop-on-first-error: picture %s: %w", p.ID, err))
 				return
 			}
-			defer f.Close()
+			continue
+		}
+		g.completion.complete(p)
+		if g.limit.complete() {
+			log.Printf("stopping: reached the -limit of %d downloads", g.cfg.limit)
+			g.stopWork()
+			return
+		}
+	}
+}
 
-			req, err := http.NewRequestWithContext(ctx, http.MethodGet, p.URL, nil)
-			if err != nil {
-				log.Printf("unable to create download request: %v", err)
-				return
+// savePicVerified saves p, downloading it again up to the configured number of
+// times while it fails the image check, and a few times after pausing every
+// download when the site asks us to back off.
+func (g *grabber) savePicVerified(ctx context.Context, p Picture) error {
+	g.inflight.add(p.URL)
+	defer g.inflight.remove(p.URL)
+	corrupt, limited := 0, 0
+	for {
+		if err := g.paused.wait(ctx); err != nil {
+			return err
+		}
+		itemCtx, cancel := itemContext(ctx, g.cfg.downloadTimeout)
+		err := g.savePic(itemCtx, p)
+		cancel()
+		if ctx.Err() != nil {
+			return err
+		}
+		var se *statusError
+		switch {
+		case errors.Is(err, errCorruptImage) && corrupt < g.cfg.verifyRetries:
+			corrupt++
+			log.Printf("%v, downloading %s again", err, p.URL)
+		case errors.As(err, &se) && rateLimited(se.code) && limited < rateLimitRetries:
+			d, ok := parseRetryAfter(se.retryAfter, time.Now())
+			if !ok {
+				d = rateLimitBackoff << limited
 			}
-			err = httpDo(ctx, req, func(resp *http.Response, err error) error {
-				if err != nil {
-					return err
-				}
-				defer resp.Body.Close()
+			if d > g.cfg.maxRetryWait {
+				d = g.cfg.maxRetryWait
+			}
+			limited++
+			log.Printf("RATE LIMITED: %s for %s, pausing all downloads for %v", se.status, p.URL, d)
+			g.paused.pause(d)
+		default:
+			return err
+		}
+	}
+}
+
+// savePic downloads p into the output directory and records it in the state
+// and manifest.
+func (g *grabber) savePic(ctx context.Context, p Picture) error {
+	fname := g.picName(p)
+	if g.cfg.overwrite == overwriteNever {
+		exists, err := g.store.Exists(ctx, fname)
+		if err != nil {
+			return fmt.Errorf("unable to check for an existing file: %w", err)
+		}
+		if exists {
+			return errKept
+		}
+	}
+	var f io.WriteCloser
+	complete := false
+	defer func() {
+		if f == nil || complete {
+			return
+		}
+		if a, ok := f.(aborter); ok && a.Abort() == nil {
+			log.Printf("removed partial download of %v", g.location(fname))
+		}
+	}()
 
-				_, err = io.Copy(f, resp.Body)
+	// The full resolution variant is tried first, falling back to the
+	// gallery's rendition if there is none.
+	sources := []string{p.URL}
+	if g.cfg.fullRes && !p.Video {
+		if u := fullResURL(p.URL); u != p.URL {
+			sources = []string{u, p.URL}
+		}
+	}
+	hash := sha256.New()
+	var (
+		size    countingWriter
+		content bytes.Buffer // for the thumbnail
+	)
+	var check *decodeCheck
+	if (g.cfg.verifyImages || g.cfg.checkImages) && !p.Video {
+		check = newDecodeCheck(g.cfg.verifyImages)
+		defer check.abort()
+	}
+	var (
+		src string
+		err error
+	)
+	for i := range sources {
+		src = sources[i]
+		fallback := i+1 < len(sources)
+		save := func(resp *http.Response, body io.Reader) error {
+			if resp.StatusCode == http.StatusNotFound && fallback {
+				return errNotFound
+			}
+			if resp.StatusCode != http.StatusOK {
+				return &statusError{code: resp.StatusCode, status: resp.Status, url: src, retryAfter: resp.Header.Get("Retry-After")}
+			}
+			if g.cfg.overwrite == overwriteNewer || g.cfg.overwrite == overwriteLarger {
+				keep, err := keepExisting(ctx, g.store, g.cfg.overwrite, fname, resp)
 				if err != nil {
-					return err
+					return fmt.Errorf("unable to check for an existing file: %w", err)
+				}
+				if keep {
+					return errKept
 				}
-				log.Printf("downloaded %v", fname)
-				return nil
-			})
-			if err != nil {
-				log.Printf("unable to download file: %v", err)
 			}
-		}()
+
+			var err error
+			if f, err = g.store.Create(ctx, fname); err != nil {
+				return fmt.Errorf("unable to create file: %w", err)
+			}
+			w := io.MultiWriter(f, hash, &size)
+			if check != nil {
+				w = io.MultiWriter(w, check)
+			}
+			if g.thumbs != nil && !p.Video {
+				w = io.MultiWriter(w, &content)
+			}
+			if g.budget != nil {
+				w = io.MultiWriter(w, g.budget)
+			}
+			conv := g.conv
+			if p.Video {
+				conv = converter{}
+			}
+			var tagged *metadataWriter
+			if g.cfg.tagMetadata && !p.Video {
+				tagged = &metadataWriter{w: w}
+				tagged.description, tagged.comment = g.metadataTags(p)
+				w = tagged
+			}
+			n, err := conv.convert(w, body)
+			g.stats.add(&g.stats.received, n)
+			if err != nil || tagged == nil {
+				return err
+			}
+			if err := tagged.flush(); err != nil {
+				return err
+			}
+			if tagged.skipped {
+				g.infof("not tagging %s: only JPEG and PNG pictures can be", p.ID)
+			}
+			return nil
+		}
+		segmented := false
+		if head, ok := g.segmentable(ctx, src); ok {
+			err = g.downloadSegmented(ctx, src, head, save)
+			// Servers announcing ranges may still not serve them.
+			if segmented = !errors.Is(err, errRangeIgnored); !segmented {
+				g.debugf("%s: %v, downloading it with a single request", src, err)
+			}
+		}
+		if !segmented {
+			err = g.downloadTo(ctx, src, func(resp *http.Response) error {
+				return save(resp, throttle(ctx, resp.Body, g.bw))
+			})
+		}
+		if !errors.Is(err, errNotFound) {
+			break
+		}
+		g.debugf("no full resolution variant at %s, falling back to %s", src, sources[i+1])
+	}
+	if err != nil {
+		return err
+	}
+	if check != nil {
+		if err := check.result(); err != nil {
+			return fmt.Errorf("%s: %w", p.URL, err)
+		}
+	}
+	complete = true
+	if err := f.Close(); err != nil {
+		return fmt.Errorf("unable to write file: %w", err)
+	}
+	g.infof("downloaded %v", g.location(fname))
+	g.events.send(p, eventDownloaded, g.location(fname), int64(size), nil)
+	g.stats.add(&g.stats.downloaded, 1)
+	g.stats.add(&g.stats.bytes, int64(size))
+
+	sum := hex.EncodeToString(hash.Sum(nil))
+	if g.hashes != nil {
+		fname = g.dedupContent(ctx, fname, sum)
+	}
+	g.state.record(stateKey(p), stateEntry{URL: p.URL, File: fname, SHA256: sum, Size: int64(size), Completed: time.Now()})
+	g.sums.add(fname, sum)
+	if g.thumbs != nil && !p.Video {
+		g.thumbs.add(fname, content.Bytes())
 	}
+
+	entry := manifestEntry{ID: p.ID, Caption: p.Caption, RawCaption: p.RawCaption, URL: p.URL, Source: src, Page: p.Page, Chapter: p.Chapter, Index: p.Index, Archived: p.Archived, Kind: p.Kind, Video: p.Video,
+		Alt: p.Alt, Credit: p.Credit, Description: p.Description, PublishedAt: p.PublishedAt, Width: p.Width, Height: p.Height, Extra: p.Extra, Variants: p.Variants, File: fname, SHA256: sum, Size: int64(size)}
+	if g.cfg.captions {
+		sidecar, err := writeSidecar(ctx, g.store, fname, p)
+		if err != nil {
+			log.Printf("unable to write caption file: %v", err)
+		} else {
+			entry.Sidecar = sidecar
+		}
+	}
+	g.manifest.add(entry)
+	if g.cfg.OnDownloaded != nil {
+		g.cfg.OnDownloaded(p, g.location(fname), int64(size))
+	}
+	return nil
 }
 
-// httpDo makes an HTTP request. It passes the HTTP response to closure f for it to handle.
-func httpDo(ctx context.Context, req *http.Request, f func(*http.Response, error) error) error {
-	c := make(chan error, 1)
-	req = req.WithContext(ctx)
-	go func() {
-		c <- f(http.DefaultClient.Do(req))
-	}()
-	select {
-	case <-ctx.Done():
-		<-c
-		return ctx.Err()
-	case err := <-c:
-		return err
+// downloadTo requests url and hands the response to f, which is responsible
+// for checking its status. The body is closed once f returns.
+func (g *grabber) downloadTo(ctx context.Context, url string, f func(*http.Response) error) error {
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, url, nil)
+	if err != nil {
+		return fmt.Errorf("unable to create download request: %w", err)
+	}
+	return httpDo(ctx, g.client, req, func(resp *http.Response, err error) error {
+		if err != nil {
+			return err
+		}
+		defer resp.Body.Close()
+		return f(resp)
+	})
+}
+
+// location returns where the file name is stored, for logging.
+func (g *grabber) location(name string) string {
+	if isLocalOutput(g.cfg.output) {
+		return filepath.Join(g.cfg.output, filepath.FromSlash(name))
+	}
+	return strings.TrimSuffix(g.cfg.output, "/") + "/" + name
+}
+
+// itemContext returns the context for handling a single gallery page or
+// picture, bounded by timeout unless it is 0. A stalled request then fails on
+// its own instead of holding up the run.
+func itemContext(ctx context.Context, timeout time.Duration) (context.Context, context.CancelFunc) {
+	if timeout <= 0 {
+		return context.WithCancel(ctx)
 	}
+	return context.WithTimeout(ctx, timeout)
+}
+
+// infof logs routine progress, such as each picture handled, unless in quiet
+// mode.
+func (g *grabber) infof(format string, args ...interface{}) {
+	if !g.cfg.quiet {
+		log.Printf(format, args...)
+	}
+}
+
+// debugf logs only in verbose mode.
+func (g *grabber) debugf(format string, args ...interface{}) {
+	if g.cfg.verbose {
+		log.Printf(format, args...)
+	}
+}
+
+// countingWriter counts the bytes written to it.
+type countingWriter int64
+
+func (c *countingWriter) Write(p []byte) (int, error) {
+	*c += countingWriter(len(p))
+	return len(p), nil
 }
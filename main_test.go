@@ -0,0 +1,291 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"flag"
+	"fmt"
+	"image"
+	"image/jpeg"
+	"io"
+	"log"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/z11i/mandalorian-art-grabber/grill"
+)
+
+func TestMain(m *testing.M) {
+	flag.Parse()
+	if !testing.Verbose() {
+		log.SetOutput(io.Discard)
+	}
+	os.Exit(m.Run())
+}
+
+func TestDownloadGalleryHTMLConcurrent(t *testing.T) {
+	const chapters, delay = 6, 100 * time.Millisecond
+	site := newFakeSite(t)
+	site.delay = delay
+	for c := 1; c <= chapters; c++ {
+		site.gallery(conceptPath(c, false), fakeImage{fmt.Sprintf("c%d-a", c), "A"}, fakeImage{fmt.Sprintf("c%d-b", c), "B"})
+	}
+	cfg := testConfig(t, site.URL)
+	cfg.galleryWorkers = 3
+	g := newTestGrabber(t, cfg)
+
+	start := time.Now()
+	pics := collect(t, g.downloadGalleryHTML(context.Background(), galleriesOf(g, 1, 2, 3, 4, 5, 6)), 10*time.Second)
+	elapsed := time.Since(start)
+
+	if sequential := chapters * delay; elapsed >= sequential {
+		t.Errorf("fetching took %v, no faster than one at a time (%v)", elapsed, sequential)
+	}
+	if site.peak() < 2 {
+		t.Errorf("at most %d gallery pages fetched at once", site.peak())
+	}
+	seen := make(map[string]int)
+	for _, p := range pics {
+		seen[p.ID]++
+	}
+	if len(pics) != 2*chapters || len(seen) != 2*chapters {
+		t.Errorf("got %d pictures, %d distinct, want %d: %v", len(pics), len(seen), 2*chapters, seen)
+	}
+}
+
+func TestDownloadGalleryHTMLClosesOnCancel(t *testing.T) {
+	site := newFakeSite(t)
+	site.delay = time.Hour
+	cfg := testConfig(t, site.URL)
+	g := newTestGrabber(t, cfg)
+	ctx, cancel := context.WithCancel(context.Background())
+	pics := g.downloadGalleryHTML(ctx, galleriesOf(g, 1, 2, 3, 4))
+	time.AfterFunc(50*time.Millisecond, cancel)
+	if got := collect(t, pics, 5*time.Second); len(got) != 0 {
+		t.Errorf("got %d pictures from a cancelled fetch", len(got))
+	}
+}
+
+func TestFetchChapterFallsBackToSecondURL(t *testing.T) {
+	site := newFakeSite(t)
+	site.gallery(conceptPath(3, true), fakeImage{"c3", "Three"})
+	site.gallery(conceptPath(4, false), fakeImage{"c4", "Four"})
+	site.gallery(conceptPath(4, true), fakeImage{"c4", "Four"})
+	cfg := testConfig(t, site.URL)
+	cfg.galleryWorkers = 1
+	g := newTestGrabber(t, cfg)
+
+	pics := collect(t, g.downloadGalleryHTML(context.Background(), galleriesOf(g, 3, 4)), 5*time.Second)
+
+	if n := site.hitsOf(conceptPath(3, false)); n != 1 {
+		t.Errorf("first URL of chapter 3 fetched %d times, want 1", n)
+	}
+	if n := site.hitsOf(conceptPath(3, true)); n != 1 {
+		t.Errorf("second URL of chapter 3 fetched %d times, want 1", n)
+	}
+	if n := site.hitsOf(conceptPath(4, false)) + site.hitsOf(conceptPath(4, true)); n != 1 {
+		t.Errorf("chapter 4 fetched %d times, want 1", n)
+	}
+	byChapter := make(map[int][]string)
+	for _, p := range pics {
+		byChapter[p.Chapter] = append(byChapter[p.Chapter], p.ID)
+	}
+	if len(byChapter[3]) != 1 || len(byChapter[4]) != 1 {
+		t.Errorf("pictures by chapter = %v, want one each for chapters 3 and 4", byChapter)
+	}
+}
+
+func TestSavePicRemovesPartialFile(t *testing.T) {
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		// Promise more than is sent, so that the copy fails part way.
+		w.Header().Set("Content-Length", "1000")
+		w.Write(fakeImageData("partial")[:10])
+	}))
+	defer srv.Close()
+	p := pic("partial", srv.URL+"/partial.png")
+	p.Caption = "Partial"
+
+	for _, existing := range []bool{false, true} {
+		t.Run(fmt.Sprintf("existing=%v", existing), func(t *testing.T) {
+			cfg := testConfig(t, srv.URL)
+			g := newTestGrabber(t, cfg)
+			name := filepath.Join(cfg.output, filepath.FromSlash(g.picName(p)))
+			old := fakeImageData("complete")
+			if existing {
+				if err := os.WriteFile(name, old, 0644); err != nil {
+					t.Fatal(err)
+				}
+			}
+
+			if err := g.savePic(context.Background(), p); err == nil {
+				t.Fatal("saving a truncated download did not fail")
+			}
+			got, err := os.ReadFile(name)
+			switch {
+			case existing && err != nil:
+				t.Errorf("existing file lost: %v", err)
+			case existing && !bytes.Equal(got, old):
+				t.Error("existing file replaced by a partial download")
+			case !existing && !errors.Is(err, os.ErrNotExist):
+				t.Errorf("partial download left behind (%d bytes, error %v)", len(got), err)
+			}
+			entries, err := os.ReadDir(cfg.output)
+			if err != nil {
+				t.Fatal(err)
+			}
+			for _, e := range entries {
+				if e.Name() != filepath.Base(name) {
+					t.Errorf("unexpected file %s left in the output", e.Name())
+				}
+			}
+		})
+	}
+}
+
+// jpegData returns a small JPEG picture.
+func jpegData(t *testing.T) []byte {
+	t.Helper()
+	img := image.NewRGBA(image.Rect(0, 0, 128, 128))
+	for i := range img.Pix {
+		img.Pix[i] = uint8(i)
+	}
+	var buf bytes.Buffer
+	if err := jpeg.Encode(&buf, img, nil); err != nil {
+		t.Fatal(err)
+	}
+	return buf.Bytes()
+}
+
+func TestSavePicVerifiedRetriesTruncated(t *testing.T) {
+	full := jpegData(t)
+	tests := []struct {
+		name      string
+		truncated int // number of responses cut short before complete ones
+		wantErr   bool
+	}{
+		{"complete", 0, false},
+		{"retried", 1, false},
+		{"always truncated", 100, true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var served int64
+			srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+				if atomic.AddInt64(&served, 1) <= int64(tt.truncated) {
+					w.Write(full[:len(full)/2])
+					return
+				}
+				w.Write(full)
+			}))
+			defer srv.Close()
+			cfg := testConfig(t, srv.URL)
+			cfg.verifyImages = true
+			g := newTestGrabber(t, cfg)
+			g.state = openState(cfg.stateDir, false)
+			p := pic("truncated", srv.URL+"/truncated.jpg")
+
+			err := g.savePicVerified(context.Background(), p)
+			if tt.wantErr {
+				if !errors.Is(err, errCorruptImage) {
+					t.Errorf("got error %v, want %v", err, errCorruptImage)
+				}
+				if want := int64(cfg.verifyRetries + 1); served != want {
+					t.Errorf("downloaded %d times, want %d", served, want)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatal(err)
+			}
+			if want := int64(tt.truncated + 1); served != want {
+				t.Errorf("downloaded %d times, want %d", served, want)
+			}
+			got, err := os.ReadFile(filepath.Join(cfg.output, filepath.FromSlash(g.picName(p))))
+			if err != nil {
+				t.Fatal(err)
+			}
+			if !bytes.Equal(got, full) {
+				t.Errorf("kept %d bytes, want the complete %d", len(got), len(full))
+			}
+		})
+	}
+}
+
+func TestLoadGalleryStatus(t *testing.T) {
+	site := newFakeSite(t)
+	tests := []struct {
+		code         int
+		wantNotFound bool
+	}{
+		{http.StatusForbidden, false},
+		{http.StatusTooManyRequests, false},
+		{http.StatusInternalServerError, false},
+		{http.StatusServiceUnavailable, false},
+		{http.StatusGone, true},
+		{http.StatusNotFound, true},
+	}
+	cfg := testConfig(t, site.URL)
+	cfg.breakerThreshold = 0
+	g := newTestGrabber(t, cfg)
+	for _, tt := range tests {
+		// The body is a gallery, which must not be parsed.
+		p := fmt.Sprintf("/status/%d", tt.code)
+		site.page(p, galleryHTML(site.URL, []fakeImage{{"a", "A"}}), tt.code)
+
+		pics, _, err := g.loadGallery(context.Background(), site.URL+p)
+		if tt.wantNotFound {
+			if !errors.Is(err, errNotFound) {
+				t.Errorf("%d: got %d pictures, error %v, want %v", tt.code, len(pics), err, errNotFound)
+			}
+			continue
+		}
+		var se *statusError
+		if !errors.As(err, &se) || se.code != tt.code || isParseError(err) {
+			t.Errorf("%d: got %d pictures, error %v, want a status error", tt.code, len(pics), err)
+		}
+	}
+}
+
+func TestSetPage(t *testing.T) {
+	const page = "https://www.starwars.com/series/the-mandalorian/chapter-1-concept-art-gallery"
+	in := []Picture{
+		pic("abs", "https://lumiere-a.akamaihd.net/v1/images/abs.jpeg"),
+		pic("proto", "//lumiere-a.akamaihd.net/v1/images/proto.jpeg"),
+		pic("root", "/content/root.jpeg"),
+		pic("rel", "images/rel.jpeg"),
+		pic("spaced", " https://lumiere-a.akamaihd.net/v1/images/spaced.jpeg "),
+		pic("script", "javascript:alert(1)"),
+		pic("mail", "mailto:art@example.com"),
+		pic("nohost", "https:///nohost.jpeg"),
+		pic("bad", "https://lumiere a.akamaihd.net/%zz"),
+	}
+	in[0].Variants = []grill.Variant{{Name: "large", URL: "//lumiere-a.akamaihd.net/large.jpeg"}, {Name: "bad", URL: "ftp://example.com/x.jpeg"}}
+	want := map[string]string{
+		"abs":    "https://lumiere-a.akamaihd.net/v1/images/abs.jpeg",
+		"proto":  "https://lumiere-a.akamaihd.net/v1/images/proto.jpeg",
+		"root":   "https://www.starwars.com/content/root.jpeg",
+		"rel":    "https://www.starwars.com/series/the-mandalorian/images/rel.jpeg",
+		"spaced": "https://lumiere-a.akamaihd.net/v1/images/spaced.jpeg",
+	}
+	got := setPage(in, page)
+	if len(got) != len(want) {
+		t.Errorf("kept %d pictures, want %d", len(got), len(want))
+	}
+	for i, p := range got {
+		if p.URL != want[p.ID] || p.Page != page || p.Index != i {
+			t.Errorf("picture %s: url %s, page %s, index %d, want %s, %s, %d", p.ID, p.URL, p.Page, p.Index, want[p.ID], page, i)
+		}
+	}
+	if vs := got[0].Variants; len(vs) != 1 || vs[0].URL != "https://lumiere-a.akamaihd.net/large.jpeg" {
+		t.Errorf("variants = %+v, want the large one resolved", vs)
+	}
+	if in[1].URL != "//lumiere-a.akamaihd.net/v1/images/proto.jpeg" {
+		t.Error("setPage changed the pictures it was given")
+	}
+}
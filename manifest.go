@@ -0,0 +1,99 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"os"
+	"path/filepath"
+	"sort"
+	"sync"
+
+	"github.com/z11i/mandalorian-art-grabber/grill"
+)
+
+// manifestFile is the name of the file in the output directory describing
+// the pictures downloaded by the last run.
+const manifestFile = "manifest.json"
+
+type manifestEntry struct {
+	ID          string                 `json:"id"`
+	Caption     string                 `json:"caption"`
+	RawCaption  string                 `json:"raw_caption,omitempty"` // as the gallery gives it, if it differs
+	URL         string                 `json:"url"`                   // as found in the gallery
+	Source      string                 `json:"source"`                // what was downloaded, which differs from URL for full resolution variants
+	Page        string                 `json:"page"`                  // final URL of the gallery page, after redirects
+	Chapter     int                    `json:"chapter"`
+	Index       int                    `json:"index"`              // position in the gallery
+	Archived    bool                   `json:"archived,omitempty"` // downloaded from the Wayback Machine's copy of a removed gallery
+	Kind        string                 `json:"kind,omitempty"`     // kind of gallery, if not concept art
+	Video       bool                   `json:"video,omitempty"`    // a video clip rather than a picture
+	Alt         string                 `json:"alt,omitempty"`
+	Credit      string                 `json:"credit,omitempty"`
+	Description string                 `json:"description,omitempty"`
+	PublishedAt string                 `json:"published_at,omitempty"`
+	Width       int                    `json:"width,omitempty"` // as given by the gallery, not measured
+	Height      int                    `json:"height,omitempty"`
+	Extra       map[string]interface{} `json:"extra,omitempty"`    // fields of the gallery's image entry not otherwise known
+	Variants    []grill.Variant        `json:"variants,omitempty"` // renditions the gallery gives, largest first, if several
+	File        string                 `json:"file"`               // relative to the output
+	SHA256      string                 `json:"sha256"`
+	Size        int64                  `json:"size"`
+	Sidecar     string                 `json:"sidecar,omitempty"` // caption file, relative to the output
+}
+
+// manifest collects an entry for every downloaded picture. It is safe for
+// concurrent use.
+type manifest struct {
+	mu         sync.Mutex
+	Duplicates int64           `json:"duplicates"` // pictures sharing an ID with an earlier one
+	Entries    []manifestEntry `json:"entries"`
+}
+
+func (m *manifest) add(e manifestEntry) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	m.Entries = append(m.Entries, e)
+}
+
+// save writes the manifest to s, with entries by chapter and gallery position
+// whatever order the downloads completed in.
+func (m *manifest) save(ctx context.Context, s Storer) error {
+	m.mu.Lock()
+	sort.SliceStable(m.Entries, func(i, j int) bool {
+		a, b := m.Entries[i], m.Entries[j]
+		if a.Chapter != b.Chapter {
+			return a.Chapter < b.Chapter
+		}
+		if a.Index != b.Index {
+			return a.Index < b.Index
+		}
+		return a.File < b.File
+	})
+	b, err := json.MarshalIndent(m, "", "  ")
+	m.mu.Unlock()
+	if err != nil {
+		return err
+	}
+	return writeFile(ctx, s, manifestFile, b)
+}
+
+// writeFileAtomic writes data to a temporary file next to name and renames
+// it into place, so that readers never see a partially written file.
+func writeFileAtomic(name string, data []byte, perm os.FileMode) error {
+	f, err := os.CreateTemp(filepath.Dir(name), "."+filepath.Base(name)+".*.tmp")
+	if err != nil {
+		return err
+	}
+	defer os.Remove(f.Name())
+	if _, err := f.Write(data); err != nil {
+		f.Close()
+		return err
+	}
+	if err := f.Close(); err != nil {
+		return err
+	}
+	if err := os.Chmod(f.Name(), perm); err != nil {
+		return err
+	}
+	return os.Rename(f.Name(), name)
+}
@@ -0,0 +1,226 @@
+package main
+
+import (
+	"bytes"
+	"encoding/binary"
+	"fmt"
+	"hash/crc32"
+	"io"
+)
+
+// maxTagText is the most bytes of a text written into a picture's metadata,
+// well within the 64KB of a JPEG segment.
+const maxTagText = 4096
+
+// metadataTags returns the description and comment written into the metadata
+// of p with -tag-metadata: its caption, and where it comes from.
+func (g *grabber) metadataTags(p Picture) (description, comment string) {
+	kind := "concept art"
+	if p.Kind != "" {
+		kind = p.Kind
+	}
+	comment = fmt.Sprintf("%s chapter %d, %s gallery, picture %s", g.series.Name, p.Chapter, kind, p.ID)
+	if p.Page != "" {
+		comment += ", " + p.Page
+	}
+	return truncateTag(p.Caption), truncateTag(comment)
+}
+
+// truncateTag cuts s to the longest text written into metadata, without
+// splitting a character.
+func truncateTag(s string) string {
+	return truncateUTF8(s, maxTagText)
+}
+
+// metadataWriter writes a JPEG or PNG image to w with a description and
+// comment added to its metadata: an EXIF segment for JPEG, placed after the
+// JFIF one if there is one, and text chunks after the header for PNG. Other
+// formats are written unchanged. It buffers the start of the image until it
+// knows where the metadata goes, so flush must be called once the image is
+// written.
+type metadataWriter struct {
+	w                    io.Writer
+	description, comment string
+
+	head    []byte
+	done    bool
+	skipped bool // not a format that is tagged
+}
+
+func (m *metadataWriter) Write(p []byte) (int, error) {
+	if m.done {
+		return m.w.Write(p)
+	}
+	m.head = append(m.head, p...)
+	at, tags, ok := m.insertion()
+	if !ok {
+		return len(p), nil
+	}
+	if err := m.writeHead(at, tags); err != nil {
+		return 0, err
+	}
+	return len(p), nil
+}
+
+// insertion returns where tags go in the buffered start of the image, and
+// whether that is known yet.
+func (m *metadataWriter) insertion() (int, []byte, bool) {
+	h := m.head
+	switch {
+	case len(h) >= 2 && h[0] == 0xff && h[1] == 0xd8:
+		if len(h) < 6 {
+			return 0, nil, false
+		}
+		at := 2
+		if h[2] == 0xff && h[3] == 0xe0 {
+			// The JFIF segment has to stay first.
+			at += 2 + int(binary.BigEndian.Uint16(h[4:6]))
+			if len(h) < at {
+				return 0, nil, false
+			}
+		}
+		return at, exifSegment(m.description, m.comment), true
+	case len(h) >= 8 && bytes.Equal(h[:8], pngSignature):
+		const afterIHDR = 8 + 8 + 13 + 4
+		if len(h) < afterIHDR {
+			return 0, nil, false
+		}
+		var tags []byte
+		tags = append(tags, pngTextChunk("Description", m.description)...)
+		tags = append(tags, pngTextChunk("Comment", m.comment)...)
+		return afterIHDR, tags, true
+	case len(h) >= 8:
+		m.skipped = true
+		return len(h), nil, true
+	}
+	return 0, nil, false
+}
+
+func (m *metadataWriter) writeHead(at int, tags []byte) error {
+	m.done = true
+	head := m.head
+	m.head = nil
+	if _, err := m.w.Write(head[:at]); err != nil {
+		return err
+	}
+	if _, err := m.w.Write(tags); err != nil {
+		return err
+	}
+	_, err := m.w.Write(head[at:])
+	return err
+}
+
+// flush writes what is still buffered, untagged, for images too short to
+// be tagged.
+func (m *metadataWriter) flush() error {
+	if m.done {
+		return nil
+	}
+	m.skipped = true
+	return m.writeHead(len(m.head), nil)
+}
+
+var pngSignature = []byte("\x89PNG\r\n\x1a\n")
+
+// pngTextChunk returns an uncompressed iTXt chunk holding text, in UTF-8,
+// under keyword.
+func pngTextChunk(keyword, text string) []byte {
+	var data bytes.Buffer
+	data.WriteString(keyword)
+	data.Write([]byte{0, 0, 0, 0, 0}) // no compression, no language or translated keyword
+	data.WriteString(text)
+	chunk := make([]byte, 8, 12+data.Len())
+	binary.BigEndian.PutUint32(chunk, uint32(data.Len()))
+	copy(chunk[4:], "iTXt")
+	chunk = append(chunk, data.Bytes()...)
+	return appendUint32(chunk, crc32.ChecksumIEEE(chunk[4:]))
+}
+
+// EXIF tags and types written.
+const (
+	exifImageDescription = 0x010e
+	exifIFDPointer       = 0x8769
+	exifUserComment      = 0x9286
+
+	exifASCII     = 2
+	exifLong      = 4
+	exifUndefined = 7
+)
+
+type exifEntry struct {
+	tag, typ uint16
+	count    uint32
+	value    []byte
+}
+
+// exifSegment returns a JPEG APP1 segment with description as the image
+// description, and comment as the user comment.
+func exifSegment(description, comment string) []byte {
+	ifd0 := []exifEntry{
+		{tag: exifImageDescription, typ: exifASCII, count: uint32(len(description) + 1), value: append([]byte(description), 0)},
+		{tag: exifIFDPointer, typ: exifLong, count: 1},
+	}
+	// A user comment starts with its character code; undefined lets readers
+	// take the UTF-8 as it is.
+	userComment := append(make([]byte, 8), comment...)
+	exif := []exifEntry{{tag: exifUserComment, typ: exifUndefined, count: uint32(len(userComment)), value: userComment}}
+
+	const ifd0Offset = 8 // right after the TIFF header
+	exifOffset := ifd0Offset + ifdSize(ifd0)
+	ifd0[1].value = appendUint32(nil, uint32(exifOffset))
+
+	tiff := []byte("MM\x00\x2a\x00\x00\x00\x08")
+	tiff = appendIFD(tiff, ifd0)
+	tiff = appendIFD(tiff, exif)
+
+	seg := []byte{0xff, 0xe1, 0, 0}
+	seg = append(seg, "Exif\x00\x00"...)
+	seg = append(seg, tiff...)
+	binary.BigEndian.PutUint16(seg[2:], uint16(len(seg)-2))
+	return seg
+}
+
+// ifdSize returns the bytes entries take as an IFD, values included.
+func ifdSize(entries []exifEntry) int {
+	n := 2 + 12*len(entries) + 4
+	for _, e := range entries {
+		if len(e.value) > 4 {
+			n += len(e.value) + len(e.value)%2
+		}
+	}
+	return n
+}
+
+// appendIFD appends entries to tiff as an IFD with no next IFD, with the
+// values too large to fit in an entry right after it.
+func appendIFD(tiff []byte, entries []exifEntry) []byte {
+	dataOffset := len(tiff) + 2 + 12*len(entries) + 4
+	var data []byte
+	tiff = appendUint16(tiff, uint16(len(entries)))
+	for _, e := range entries {
+		tiff = appendUint16(tiff, e.tag)
+		tiff = appendUint16(tiff, e.typ)
+		tiff = appendUint32(tiff, e.count)
+		if len(e.value) <= 4 {
+			v := make([]byte, 4)
+			copy(v, e.value)
+			tiff = append(tiff, v...)
+			continue
+		}
+		tiff = appendUint32(tiff, uint32(dataOffset+len(data)))
+		data = append(data, e.value...)
+		if len(data)%2 == 1 {
+			data = append(data, 0)
+		}
+	}
+	tiff = appendUint32(tiff, 0)
+	return append(tiff, data...)
+}
+
+func appendUint16(b []byte, v uint16) []byte {
+	return append(b, byte(v>>8), byte(v))
+}
+
+func appendUint32(b []byte, v uint32) []byte {
+	return append(b, byte(v>>24), byte(v>>16), byte(v>>8), byte(v))
+}
@@ -0,0 +1,205 @@
+package main
+
+import (
+	"bytes"
+	"encoding/binary"
+	"hash/crc32"
+	"image"
+	"image/color"
+	"image/gif"
+	"image/png"
+	"strings"
+	"testing"
+	"unicode/utf8"
+
+	"github.com/z11i/mandalorian-art-grabber/grill"
+)
+
+func TestTruncateTag(t *testing.T) {
+	tests := []struct {
+		s    string
+		want int // length in bytes
+	}{
+		{"short", 5},
+		{strings.Repeat("a", maxTagText+10), maxTagText},
+		// A two-byte character straddling the limit is dropped whole.
+		{strings.Repeat("a", maxTagText-1) + "é", maxTagText - 1},
+		{strings.Repeat("日", maxTagText), maxTagText / 3 * 3},
+	}
+	for _, tt := range tests {
+		got := truncateTag(tt.s)
+		if len(got) != tt.want || !utf8.ValidString(got) || !strings.HasPrefix(tt.s, got) {
+			t.Errorf("truncateTag of %d bytes: got %d bytes, valid %v, want %d", len(tt.s), len(got), utf8.ValidString(got), tt.want)
+		}
+	}
+}
+
+// tagged returns data written through a metadataWriter tagging it with
+// description and comment, a few bytes at a time, and whether it was left
+// untagged.
+func tagged(t *testing.T, data []byte, description, comment string) ([]byte, bool) {
+	t.Helper()
+	var out bytes.Buffer
+	m := &metadataWriter{w: &out, description: description, comment: comment}
+	for len(data) > 0 {
+		n := 3
+		if n > len(data) {
+			n = len(data)
+		}
+		if _, err := m.Write(data[:n]); err != nil {
+			t.Fatal(err)
+		}
+		data = data[n:]
+	}
+	if err := m.flush(); err != nil {
+		t.Fatal(err)
+	}
+	return out.Bytes(), m.skipped
+}
+
+// jpegSegments returns the markers of the segments of the JPEG data before
+// its image data, and their payloads.
+func jpegSegments(t *testing.T, data []byte) ([]byte, [][]byte) {
+	t.Helper()
+	var (
+		markers  []byte
+		payloads [][]byte
+	)
+	for i := 2; i+4 <= len(data) && data[i] == 0xff; {
+		marker, n := data[i+1], int(binary.BigEndian.Uint16(data[i+2:]))
+		markers = append(markers, marker)
+		payloads = append(payloads, data[i+4:i+2+n])
+		if marker == 0xda { // start of scan
+			break
+		}
+		i += 2 + n
+	}
+	return markers, payloads
+}
+
+// exifValue returns the value of tag in the IFD at offset of the big-endian
+// TIFF data.
+func exifValue(t *testing.T, tiff []byte, offset uint32, tag uint16) []byte {
+	t.Helper()
+	n := int(binary.BigEndian.Uint16(tiff[offset:]))
+	for i := 0; i < n; i++ {
+		e := tiff[int(offset)+2+12*i:]
+		if binary.BigEndian.Uint16(e) != tag {
+			continue
+		}
+		count := binary.BigEndian.Uint32(e[4:])
+		if binary.BigEndian.Uint16(e[2:]) == exifLong || count <= 4 {
+			return e[8:12]
+		}
+		at := binary.BigEndian.Uint32(e[8:])
+		return tiff[at : at+count]
+	}
+	t.Fatalf("no tag %#x in the IFD at %d", tag, offset)
+	return nil
+}
+
+func TestMetadataJPEG(t *testing.T) {
+	plain := jpegData(t)
+	// Go's encoder writes no JFIF segment; the site's pictures have one.
+	jfif := append([]byte{0xff, 0xd8, 0xff, 0xe0, 0, 16, 'J', 'F', 'I', 'F', 0, 1, 1, 0, 0, 1, 0, 1, 0, 0}, plain[2:]...)
+	const description, comment = "Grogu & the egg — “Chapter 2”", "The Mandalorian chapter 2, concept art gallery, picture a1"
+	for name, data := range map[string][]byte{"without JFIF": plain, "with JFIF": jfif} {
+		t.Run(name, func(t *testing.T) {
+			out, skipped := tagged(t, data, description, comment)
+			if skipped {
+				t.Fatal("JPEG left untagged")
+			}
+			img, format, err := image.Decode(bytes.NewReader(out))
+			if err != nil || format != "jpeg" || img.Bounds().Dx() != 128 {
+				t.Fatalf("tagged picture decodes as %s, %v", format, err)
+			}
+			markers, payloads := jpegSegments(t, out)
+			exif := 0
+			if data[3] == 0xe0 {
+				if markers[0] != 0xe0 {
+					t.Errorf("JFIF segment not first: markers %x", markers)
+				}
+				exif = 1
+			}
+			if markers[exif] != 0xe1 || !bytes.HasPrefix(payloads[exif], []byte("Exif\x00\x00")) {
+				t.Fatalf("no EXIF segment where expected: markers %x", markers)
+			}
+			tiff := payloads[exif][6:]
+			if got := exifValue(t, tiff, 8, exifImageDescription); string(got) != description+"\x00" {
+				t.Errorf("image description %q, want %q", got, description)
+			}
+			ifd := binary.BigEndian.Uint32(exifValue(t, tiff, 8, exifIFDPointer))
+			got := exifValue(t, tiff, ifd, exifUserComment)
+			if !bytes.Equal(got[:8], make([]byte, 8)) || string(got[8:]) != comment {
+				t.Errorf("user comment %q, want %q", got, comment)
+			}
+			// The image data follows unchanged.
+			if !bytes.HasSuffix(out, plain[2:]) {
+				t.Error("image data changed")
+			}
+		})
+	}
+}
+
+func TestMetadataPNG(t *testing.T) {
+	data := fakeImageData("a1")
+	const description, comment = "Grogu & the egg — “Chapter 2”", "The Mandalorian chapter 2, concept art gallery, picture a1"
+	out, skipped := tagged(t, data, description, comment)
+	if skipped {
+		t.Fatal("PNG left untagged")
+	}
+	if _, err := png.Decode(bytes.NewReader(out)); err != nil {
+		t.Fatalf("tagged picture does not decode: %v", err)
+	}
+	var (
+		chunks []string
+		texts  = make(map[string]string)
+	)
+	for i := len(pngSignature); i+12 <= len(out); {
+		n := int(binary.BigEndian.Uint32(out[i:]))
+		typ, payload := string(out[i+4:i+8]), out[i+8:i+8+n]
+		if crc := binary.BigEndian.Uint32(out[i+8+n:]); crc != crc32.ChecksumIEEE(out[i+4:i+8+n]) {
+			t.Errorf("%s chunk has a bad CRC", typ)
+		}
+		chunks = append(chunks, typ)
+		if typ == "iTXt" {
+			parts := bytes.SplitN(payload, []byte{0}, 2)
+			// Compression flag and method, empty language and translated keyword.
+			texts[string(parts[0])] = string(bytes.TrimPrefix(parts[1], []byte{0, 0, 0, 0}))
+		}
+		i += 12 + n
+	}
+	if strings.Join(chunks[:3], " ") != "IHDR iTXt iTXt" {
+		t.Errorf("chunks %v, want the text chunks right after IHDR", chunks)
+	}
+	if texts["Description"] != description || texts["Comment"] != comment {
+		t.Errorf("texts %q, want the description and comment", texts)
+	}
+}
+
+func TestMetadataUntagged(t *testing.T) {
+	var g bytes.Buffer
+	if err := gif.Encode(&g, image.NewPaletted(image.Rect(0, 0, 2, 2), color.Palette{color.Black}), nil); err != nil {
+		t.Fatal(err)
+	}
+	for name, data := range map[string][]byte{"gif": g.Bytes(), "short": []byte("\xff\xd8\xff")} {
+		out, skipped := tagged(t, data, "caption", "comment")
+		if !skipped || !bytes.Equal(out, data) {
+			t.Errorf("%s: skipped %v, changed %v, want it left untagged", name, skipped, !bytes.Equal(out, data))
+		}
+	}
+}
+
+func TestMetadataTags(t *testing.T) {
+	g := newTestGrabber(t, testConfig(t, "https://www.starwars.com"))
+	p := Picture{Picture: grill.Picture{ID: "a1", Caption: "Grogu"}, Chapter: 2, Kind: kindStory, Page: "https://www.starwars.com/chapter-2"}
+	description, comment := g.metadataTags(p)
+	if description != "Grogu" {
+		t.Errorf("description %q, want the caption", description)
+	}
+	for _, want := range []string{g.series.Name, "chapter 2", kindStory + " gallery", "picture a1", p.Page} {
+		if !strings.Contains(comment, want) {
+			t.Errorf("comment %q lacks %q", comment, want)
+		}
+	}
+}
@@ -0,0 +1,86 @@
+package main
+
+import (
+	"io/fs"
+	"log"
+	"os"
+	"path"
+	"path/filepath"
+	"strings"
+	"sync/atomic"
+)
+
+// mirrorExts are the extensions of files that mirroring may remove, along
+// with their caption files.
+var mirrorExts = map[string]bool{".jpeg": true, ".jpg": true, ".png": true, ".webp": true}
+
+// pruneOutput removes the pictures in a local output that no gallery lists
+// any more, or only logs them in dry run mode. It does nothing unless every
+// gallery of the run was found and parsed in full, so that a page failing to
+// load or left unloaded past -max-pages never costs a chapter its pictures.
+func (g *grabber) pruneOutput(dryRun bool) {
+	if !isLocalOutput(g.cfg.output) {
+		log.Printf("mirror only supports local outputs, not pruning")
+		return
+	}
+	if n := atomic.LoadInt64(&g.stats.galleryErrors) + atomic.LoadInt64(&g.missing); n > 0 {
+		log.Printf("not pruning: %d chapters have no gallery or failed to load", n)
+		return
+	}
+	if n := atomic.LoadInt64(&g.truncated); n > 0 {
+		log.Printf("not pruning: %d galleries have pages past -max-pages", n)
+		return
+	}
+	// Only the pictures of the series fetched are pruned: those of the
+	// default series are at the top of the output, the others each in their
+	// own directory.
+	root := g.cfg.output
+	if !g.series.isDefault() {
+		root = filepath.Join(g.cfg.output, g.series.Name)
+	}
+	var orphans []string
+	err := filepath.WalkDir(root, func(p string, d fs.DirEntry, err error) error {
+		if err != nil {
+			return err
+		}
+		if p == filepath.Join(g.cfg.output, thumbsDir) || d.IsDir() && p != root && isSeriesDir(g.allSeries, g.cfg.output, p) {
+			return filepath.SkipDir
+		}
+		if strings.HasPrefix(d.Name(), ".") && p != root {
+			if d.IsDir() {
+				return filepath.SkipDir
+			}
+			return nil
+		}
+		if d.IsDir() {
+			return nil
+		}
+		rel, err := filepath.Rel(g.cfg.output, p)
+		if err != nil {
+			return err
+		}
+		name := filepath.ToSlash(rel)
+		pic := strings.TrimSuffix(name, ".txt")
+		if !mirrorExts[strings.ToLower(path.Ext(pic))] || g.expected.has(pic) {
+			return nil
+		}
+		orphans = append(orphans, name)
+		return nil
+	})
+	if err != nil {
+		log.Printf("not pruning: %v", err)
+		return
+	}
+	for _, name := range orphans {
+		if dryRun {
+			log.Printf("would remove %s", g.location(name))
+			continue
+		}
+		if err := os.Remove(filepath.Join(g.cfg.output, filepath.FromSlash(name))); err != nil {
+			log.Printf("unable to remove %s: %v", g.location(name), err)
+			continue
+		}
+		log.Printf("removed %s", g.location(name))
+	}
+	log.Printf("mirror: %d files no longer in any gallery", len(orphans))
+}
@@ -0,0 +1,46 @@
+package main
+
+import (
+	"context"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func TestMirror(t *testing.T) {
+	site := newFakeSite(t)
+	site.pagedGallery(conceptPath(1, false), conceptPath(1, false)+"-2", fakeImage{"mando1", "The Mandalorian"})
+	site.gallery(conceptPath(1, false)+"-2", fakeImage{"razor", "Razor Crest"})
+	cfg := testConfig(t, site.URL)
+	cfg.mirror = true
+	ctx := context.Background()
+	if err := newTestGrabber(t, cfg).run(ctx, ctx, []int{1}); err != nil {
+		t.Fatal(err)
+	}
+	second := filepath.Join(cfg.output, "Razor Crest_razor.jpeg")
+	if _, err := os.Stat(second); err != nil {
+		t.Fatalf("picture of the second page not downloaded: %v", err)
+	}
+
+	// Pictures of pages left unloaded past -max-pages are kept.
+	cfg.maxPages = 1
+	if err := newTestGrabber(t, cfg).run(ctx, ctx, []int{1}); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := os.Stat(second); err != nil {
+		t.Errorf("picture of a page past -max-pages pruned: %v", err)
+	}
+
+	// Once the gallery no longer lists it, it is.
+	cfg.maxPages = 0
+	site.gallery(conceptPath(1, false), fakeImage{"mando1", "The Mandalorian"})
+	if err := newTestGrabber(t, cfg).run(ctx, ctx, []int{1}); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := os.Stat(second); !os.IsNotExist(err) {
+		t.Errorf("picture no gallery lists kept: %v", err)
+	}
+	if _, err := os.Stat(filepath.Join(cfg.output, "The Mandalorian_mando1.jpeg")); err != nil {
+		t.Errorf("picture still listed pruned: %v", err)
+	}
+}
@@ -0,0 +1,37 @@
+package main
+
+import (
+	"context"
+	"sort"
+)
+
+// picLess orders pictures by chapter, then by position in the gallery.
+func picLess(a, b Picture) bool {
+	if a.Chapter != b.Chapter {
+		return a.Chapter < b.Chapter
+	}
+	return a.Index < b.Index
+}
+
+// orderPics collects every picture from in and, once in is closed, sends them
+// to the returned channel by chapter and gallery position. Downloads therefore
+// only start once all galleries have been parsed.
+func orderPics(ctx context.Context, in <-chan Picture) <-chan Picture {
+	out := make(chan Picture, cap(in))
+	go func() {
+		defer close(out)
+		var pics []Picture
+		for p := range in {
+			pics = append(pics, p)
+		}
+		sort.SliceStable(pics, func(i, j int) bool { return picLess(pics[i], pics[j]) })
+		for _, p := range pics {
+			select {
+			case out <- p:
+			case <-ctx.Done():
+				return
+			}
+		}
+	}()
+	return out
+}
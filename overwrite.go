@@ -0,0 +1,62 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net/http"
+	"os"
+	"time"
+)
+
+// Overwrite policies, deciding whether a download replaces a file already in
+// the output.
+const (
+	overwriteAlways = "always" // always replace it
+	overwriteNever  = "never"  // keep it without downloading
+	overwriteNewer  = "newer"  // replace it if the remote Last-Modified is later
+	overwriteLarger = "larger" // replace it if the remote Content-Length is bigger
+)
+
+// errKept is returned when a picture is not downloaded because the overwrite
+// policy keeps the existing file.
+var errKept = errors.New("existing file kept")
+
+func validOverwritePolicy(policy string) error {
+	switch policy {
+	case "", overwriteAlways, overwriteNever, overwriteNewer, overwriteLarger:
+		return nil
+	}
+	return fmt.Errorf("invalid -overwrite policy %q", policy)
+}
+
+// keepExisting reports whether the file name in s should be kept rather than
+// replaced by the download in resp, under the newer or larger policy. Files
+// in storers unable to stat them are always replaced.
+func keepExisting(ctx context.Context, s Storer, policy, name string, resp *http.Response) (bool, error) {
+	st, ok := s.(statter)
+	if !ok {
+		return false, nil
+	}
+	size, modTime, err := st.Stat(ctx, name)
+	if errors.Is(err, os.ErrNotExist) {
+		return false, nil
+	}
+	if err != nil {
+		return false, err
+	}
+	switch policy {
+	case overwriteNewer:
+		lm, err := http.ParseTime(resp.Header.Get("Last-Modified"))
+		if err != nil {
+			return true, nil // without a date the existing file wins
+		}
+		return !lm.After(modTime.Truncate(time.Second)), nil
+	case overwriteLarger:
+		if resp.ContentLength < 0 {
+			return true, nil
+		}
+		return resp.ContentLength <= size, nil
+	}
+	return false, nil
+}
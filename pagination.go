@@ -0,0 +1,89 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"log"
+	"net/url"
+	"sync/atomic"
+
+	"github.com/antchfx/htmlquery"
+	"github.com/antchfx/xpath"
+	"golang.org/x/net/html"
+)
+
+var nextPageXpath = xpath.MustCompile("//link[@rel='next'] | //a[@rel='next']")
+
+// nextPage returns the link to the next page of the gallery doc, as written
+// in the page, or "" if it has no next page.
+func nextPage(doc *html.Node) string {
+	n := htmlquery.QuerySelector(doc, nextPageXpath)
+	if n == nil {
+		return ""
+	}
+	return htmlquery.SelectAttr(n, "href")
+}
+
+// resolvePage returns the absolute URL of the link href on page, or "" if it
+// does not lead to a web page.
+func resolvePage(page, href string) string {
+	base, err := url.Parse(page)
+	if href == "" || err != nil {
+		return ""
+	}
+	u, err := base.Parse(href)
+	if err != nil || (u.Scheme != "http" && u.Scheme != "https") || u.String() == page {
+		return ""
+	}
+	return u.String()
+}
+
+// loadGalleryPages loads the gallery at page and, if it is paginated, its
+// following pages, up to the configured maximum and until a page links back
+// to one already loaded. Pictures are indexed by
+// their position in the whole gallery. via, if not nil, maps the URL of each
+// page to the URL it is loaded from. Each page is bounded by the gallery
+// timeout. A page of gal after the first failing to load is counted as a
+// gallery error, and the pictures of the pages before it are returned, as
+// they are when pages past the maximum are left unloaded.
+func (g *grabber) loadGalleryPages(ctx context.Context, gal gallery, page string, via func(string) string) ([]Picture, error) {
+	var all []Picture
+	seen := make(map[string]bool)
+	for n := 1; page != ""; n++ {
+		if seen[page] {
+			g.debugf("not loading %s again, the gallery's pages loop", page)
+			break
+		}
+		seen[page] = true
+		if g.cfg.maxPages > 0 && n > g.cfg.maxPages {
+			log.Printf("warning: not loading %s, past -max-pages %d", page, g.cfg.maxPages)
+			atomic.AddInt64(&g.truncated, 1)
+			break
+		}
+		u := page
+		if via != nil {
+			u = via(page)
+		}
+		itemCtx, cancel := itemContext(ctx, g.cfg.galleryTimeout)
+		pics, next, err := g.loadGallery(itemCtx, u)
+		cancel()
+		if err != nil {
+			if n == 1 {
+				return nil, err
+			}
+			g.galleryFailed(gal, u, fmt.Errorf("page %d of the gallery: %w", n, err))
+			break
+		}
+		for i := range pics {
+			pics[i].Index += len(all)
+		}
+		all = append(all, pics...)
+		if n > 1 {
+			g.debugf("loaded page %d of the gallery, %d pictures, from %s", n, len(pics), u)
+		}
+		// Links are resolved against the page as published, not
+		// against the archive it may be loaded from.
+		page = resolvePage(page, next)
+	}
+	return all, nil
+}
@@ -0,0 +1,73 @@
+package main
+
+import (
+	"context"
+	"sync/atomic"
+	"testing"
+)
+
+func TestLoadGalleryPages(t *testing.T) {
+	site := newFakeSite(t)
+	first := conceptPath(1, false)
+	// The second page is linked relative to the first, and links back to
+	// it.
+	site.pagedGallery(first, "chapter-1-concept-art-gallery-2", fakeImage{"mando1", "The Mandalorian"}, fakeImage{"razor", "Razor Crest"})
+	site.pagedGallery("/series/the-mandalorian/chapter-1-concept-art-gallery-2", first, fakeImage{"kuiil", "Kuiil"})
+	g := newTestGrabber(t, testConfig(t, site.URL))
+
+	pics, err := g.loadGalleryPages(context.Background(), gallery{chapter: 1}, site.URL+first, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := []struct {
+		id, page string
+	}{
+		{"mando1", first},
+		{"razor", first},
+		{"kuiil", "/series/the-mandalorian/chapter-1-concept-art-gallery-2"},
+	}
+	if len(pics) != len(want) {
+		t.Fatalf("got %d pictures, want %d", len(pics), len(want))
+	}
+	for i, w := range want {
+		if p := pics[i]; p.ID != w.id || p.Index != i || p.Page != site.URL+w.page {
+			t.Errorf("picture %d: got %s at %d of %s, want %s at %d of %s", i, p.ID, p.Index, p.Page, w.id, i, site.URL+w.page)
+		}
+	}
+	if n := site.hitsOf(first); n != 1 {
+		t.Errorf("first page requested %d times, want 1", n)
+	}
+}
+
+func TestLoadGalleryPagesMax(t *testing.T) {
+	site := newFakeSite(t)
+	site.pagedGallery("/gallery", "/gallery-2", fakeImage{"a", "A"})
+	site.pagedGallery("/gallery-2", "/gallery-3", fakeImage{"b", "B"})
+	site.gallery("/gallery-3", fakeImage{"c", "C"})
+	cfg := testConfig(t, site.URL)
+	cfg.maxPages = 2
+	g := newTestGrabber(t, cfg)
+
+	pics, err := g.loadGalleryPages(context.Background(), gallery{chapter: 1}, site.URL+"/gallery", nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(pics) != 2 || site.hitsOf("/gallery-3") != 0 {
+		t.Errorf("got %d pictures, third page requested %d times, want 2 and none", len(pics), site.hitsOf("/gallery-3"))
+	}
+	if n := atomic.LoadInt64(&g.truncated); n != 1 {
+		t.Errorf("%d galleries cut short, want 1", n)
+	}
+
+	// A later page failing to load keeps the pictures of those before it.
+	site.page("/gallery-2", "", 500)
+	cfg.maxPages, cfg.breakerThreshold = 0, 0
+	g = newTestGrabber(t, cfg)
+	pics, err = g.loadGalleryPages(context.Background(), gallery{chapter: 1}, site.URL+"/gallery", nil)
+	if err != nil || len(pics) != 1 {
+		t.Errorf("got %d pictures, %v, want the first page's", len(pics), err)
+	}
+	if n := atomic.LoadInt64(&g.stats.galleryErrors); n != 1 {
+		t.Errorf("%d gallery errors, want 1", n)
+	}
+}
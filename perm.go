@@ -0,0 +1,68 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strconv"
+)
+
+// Default permissions of what is written to a local output.
+const (
+	defaultDirMode  = 0755
+	defaultFileMode = 0644
+)
+
+// permMode is a permission mode given in octal, such as 0750. It applies
+// exactly, whatever the umask.
+type permMode os.FileMode
+
+func (m *permMode) String() string {
+	return fmt.Sprintf("%#o", uint32(*m))
+}
+
+func (m *permMode) Set(s string) error {
+	v, err := strconv.ParseUint(s, 8, 32)
+	if err != nil || v > 0777 {
+		return fmt.Errorf("invalid permission mode %q, expected octal such as 0750", s)
+	}
+	*m = permMode(v)
+	return nil
+}
+
+// validPermModes reports whether the owner can still use what is created with
+// the directory mode dir and the file mode file.
+func validPermModes(dir, file permMode) error {
+	if dir&0700 != 0700 {
+		return fmt.Errorf("-dir-mode %v must give the owner read, write and search permission", &dir)
+	}
+	if file&0600 != 0600 {
+		return fmt.Errorf("-file-mode %v must give the owner read and write permission", &file)
+	}
+	return nil
+}
+
+// mkdirAllMode creates dir along with any missing parents, giving those it
+// creates exactly mode. It succeeds when another goroutine creates the same
+// directory concurrently.
+func mkdirAllMode(dir string, mode os.FileMode) error {
+	if fi, err := os.Stat(dir); err == nil {
+		if !fi.IsDir() {
+			return fmt.Errorf("%s is not a directory", dir)
+		}
+		return nil
+	}
+	if parent := filepath.Dir(dir); parent != dir {
+		if err := mkdirAllMode(parent, mode); err != nil {
+			return err
+		}
+	}
+	if err := os.Mkdir(dir, mode); err != nil {
+		if errors.Is(err, os.ErrExist) {
+			return nil
+		}
+		return err
+	}
+	return os.Chmod(dir, mode)
+}
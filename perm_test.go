@@ -0,0 +1,56 @@
+package main
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"sync"
+	"testing"
+)
+
+func TestMkdirAllModeConcurrent(t *testing.T) {
+	root := t.TempDir()
+	const mode = 0750
+	var dirs []string
+	for i := 0; i < 4; i++ {
+		dirs = append(dirs, filepath.Join(root, "a", "b", fmt.Sprintf("c%d", i), "d"))
+	}
+	start := make(chan struct{})
+	errs := make(chan error, 64)
+	var wg sync.WaitGroup
+	for i := 0; i < 64; i++ {
+		wg.Add(1)
+		go func(dir string) {
+			defer wg.Done()
+			<-start
+			errs <- mkdirAllMode(dir, mode)
+		}(dirs[i%len(dirs)])
+	}
+	close(start)
+	wg.Wait()
+	close(errs)
+	for err := range errs {
+		if err != nil {
+			t.Error(err)
+		}
+	}
+	for _, dir := range dirs {
+		for d := dir; d != root; d = filepath.Dir(d) {
+			fi, err := os.Stat(d)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if !fi.IsDir() || fi.Mode().Perm() != mode {
+				t.Errorf("%s: mode %v, want a directory of %v", d, fi.Mode(), os.FileMode(mode))
+			}
+		}
+	}
+
+	file := filepath.Join(root, "file")
+	if err := os.WriteFile(file, nil, 0644); err != nil {
+		t.Fatal(err)
+	}
+	if err := mkdirAllMode(filepath.Join(file, "d"), mode); err == nil {
+		t.Error("directory created under a file")
+	}
+}
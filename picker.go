@@ -0,0 +1,170 @@
+package main
+
+import (
+	"bufio"
+	"context"
+	"fmt"
+	"io"
+	"sort"
+	"strings"
+)
+
+// pickPics collects every picture from in and, once in is closed, lists them
+// on w by chapter and reads from s which to download, sending only those to
+// the returned channel. s is shared by every call of a run, as a scanner may
+// buffer input past the answer it returns. An answer is a list of numbers and ranges, such as
+// 1,4-7, all, or none; an answer starting with / lists only the pictures whose
+// caption or ID contains the rest, and asks again.
+func (g *grabber) pickPics(ctx context.Context, in <-chan Picture, s *bufio.Scanner, w io.Writer) <-chan Picture {
+	out := make(chan Picture, cap(in))
+	go func() {
+		defer close(out)
+		var pics []Picture
+		for p := range in {
+			pics = append(pics, p)
+		}
+		sort.SliceStable(pics, func(i, j int) bool { return picLess(pics[i], pics[j]) })
+		picked := pickFrom(ctx, pics, s, w)
+		for i, p := range pics {
+			if !picked[i] {
+				if g.cfg.mirror {
+					// Keep what was downloaded before.
+					g.expected.add(g.picName(p))
+				}
+				continue
+			}
+			select {
+			case out <- p:
+			case <-ctx.Done():
+				return
+			}
+		}
+	}()
+	return out
+}
+
+// pickFrom asks on w which of pics to download until an answer read from s is
+// a selection, returning the indexes of pics selected. Nothing is selected if
+// s is exhausted or ctx is done first.
+func pickFrom(ctx context.Context, pics []Picture, s *bufio.Scanner, w io.Writer) map[int]bool {
+	if len(pics) == 0 {
+		fmt.Fprintln(w, "no pictures found")
+		return nil
+	}
+	shown := make([]int, len(pics))
+	for i := range shown {
+		shown[i] = i
+	}
+	listPics(w, pics, shown)
+	for {
+		fmt.Fprint(w, "download which pictures? (e.g. 1,4-7, all, none, /filter): ")
+		answer, ok := readLine(ctx, s)
+		if !ok {
+			fmt.Fprintln(w)
+			return nil
+		}
+		answer = strings.TrimSpace(answer)
+		if strings.HasPrefix(answer, "/") {
+			shown = filterShown(pics, strings.TrimSpace(answer[1:]))
+			if len(shown) == 0 {
+				fmt.Fprintln(w, "no pictures match")
+			}
+			listPics(w, pics, shown)
+			continue
+		}
+		picked, err := parsePicks(answer, shown, len(pics))
+		if err != nil {
+			fmt.Fprintln(w, err)
+			continue
+		}
+		fmt.Fprintf(w, "downloading %d of %d pictures\n", len(picked), len(pics))
+		return picked
+	}
+}
+
+// readLine returns the next line of s, or false once s is exhausted or ctx is
+// done.
+func readLine(ctx context.Context, s *bufio.Scanner) (string, bool) {
+	type line struct {
+		text string
+		ok   bool
+	}
+	c := make(chan line, 1)
+	go func() {
+		ok := s.Scan()
+		c <- line{s.Text(), ok}
+	}()
+	select {
+	case l := <-c:
+		return l.text, l.ok
+	case <-ctx.Done():
+		return "", false
+	}
+}
+
+// listPics writes the pictures of pics at the indexes shown to w, numbered
+// from 1 by their index and under a heading per chapter and kind of gallery.
+func listPics(w io.Writer, pics []Picture, shown []int) {
+	heading := ""
+	for _, i := range shown {
+		p := pics[i]
+		h := fmt.Sprintf("chapter %d", p.Chapter)
+		if p.Kind != "" {
+			h += " " + p.Kind
+		}
+		if h != heading {
+			heading = h
+			fmt.Fprintln(w, heading)
+		}
+		caption := p.Caption
+		if caption == "" {
+			caption = "(no caption)"
+		}
+		if p.Video {
+			caption += " [video]"
+		}
+		fmt.Fprintf(w, "%5d  %s  %s\n", i+1, caption, p.ID)
+	}
+}
+
+// filterShown returns the indexes of the pictures of pics whose caption or ID
+// contains text, ignoring case, or of every picture if text is empty.
+func filterShown(pics []Picture, text string) []int {
+	text = strings.ToLower(text)
+	var shown []int
+	for i, p := range pics {
+		if strings.Contains(strings.ToLower(p.Caption), text) || strings.Contains(strings.ToLower(p.ID), text) {
+			shown = append(shown, i)
+		}
+	}
+	return shown
+}
+
+// parsePicks parses answer, a selection of n pictures of which those at the
+// indexes shown are listed. all selects those listed.
+func parsePicks(answer string, shown []int, n int) (map[int]bool, error) {
+	picked := make(map[int]bool)
+	switch strings.ToLower(answer) {
+	case "none":
+		return picked, nil
+	case "all":
+		for _, i := range shown {
+			picked[i] = true
+		}
+		return picked, nil
+	case "":
+		return nil, fmt.Errorf("nothing selected, answer none to download nothing")
+	}
+	for _, spec := range strings.Split(answer, ",") {
+		// The bounds are checked before the range is walked, so that a
+		// typo cannot make it huge.
+		from, to, err := parseRange(strings.TrimSpace(spec))
+		if err != nil || to > n {
+			return nil, fmt.Errorf("invalid selection %q, pictures are numbered 1 to %d", strings.TrimSpace(spec), n)
+		}
+		for k := from; k <= to; k++ {
+			picked[k-1] = true
+		}
+	}
+	return picked, nil
+}
@@ -0,0 +1,121 @@
+package main
+
+import (
+	"bufio"
+	"context"
+	"reflect"
+	"strings"
+	"testing"
+
+	"github.com/z11i/mandalorian-art-grabber/grill"
+)
+
+// pickIDs runs pickPics on pics, answering from s, and returns the IDs of
+// the pictures picked.
+func pickIDs(t *testing.T, g *grabber, s *bufio.Scanner, w *strings.Builder, pics ...Picture) []string {
+	t.Helper()
+	in := make(chan Picture, len(pics))
+	for _, p := range pics {
+		in <- p
+	}
+	close(in)
+	var ids []string
+	for p := range g.pickPics(context.Background(), in, s, w) {
+		ids = append(ids, p.ID)
+	}
+	return ids
+}
+
+func chapterPic(chapter, index int, id, caption string) Picture {
+	return Picture{Picture: grill.Picture{ID: id, Caption: caption}, Chapter: chapter, Index: index}
+}
+
+func TestPickPics(t *testing.T) {
+	pics := []Picture{
+		chapterPic(2, 0, "c", "Mando on Nevarro"),
+		chapterPic(1, 1, "b", "Grogu eating frogs"),
+		chapterPic(1, 0, "a", "The Razor Crest"),
+		chapterPic(2, 1, "d", "Grogu and the egg"),
+	}
+	tests := []struct {
+		answers string
+		want    []string
+	}{
+		{"all\n", []string{"a", "b", "c", "d"}},
+		{"none\n", nil},
+		{"1,3-4\n", []string{"a", "c", "d"}},
+		{"\n9\n2\n", []string{"b"}},
+		{"/grogu\nall\n", []string{"b", "d"}},
+		{"", nil},
+	}
+	for _, tt := range tests {
+		var w strings.Builder
+		got := pickIDs(t, &grabber{}, bufio.NewScanner(strings.NewReader(tt.answers)), &w, pics...)
+		if !reflect.DeepEqual(got, tt.want) {
+			t.Errorf("answers %q picked %v, want %v\n%s", tt.answers, got, tt.want, w.String())
+		}
+	}
+
+	var w strings.Builder
+	pickIDs(t, &grabber{}, bufio.NewScanner(strings.NewReader("none\n")), &w, pics...)
+	for _, want := range []string{"chapter 1\n", "    1  The Razor Crest  a\n", "chapter 2\n", "    4  Grogu and the egg  d\n"} {
+		if !strings.Contains(w.String(), want) {
+			t.Errorf("listing lacks %q:\n%s", want, w.String())
+		}
+	}
+}
+
+// TestPickPicsSharedScanner checks that pictures picked chapter by chapter,
+// as with -sequential-chapters, each get their own answer although the first
+// read buffers them all.
+func TestPickPicsSharedScanner(t *testing.T) {
+	s := bufio.NewScanner(strings.NewReader("2\nall\n1\n"))
+	var w strings.Builder
+	groups := [][]Picture{
+		{chapterPic(1, 0, "a", ""), chapterPic(1, 1, "b", "")},
+		{chapterPic(2, 0, "c", ""), chapterPic(2, 1, "d", "")},
+		{chapterPic(3, 0, "e", ""), chapterPic(3, 1, "f", "")},
+	}
+	want := [][]string{{"b"}, {"c", "d"}, {"e"}}
+	for i, pics := range groups {
+		if got := pickIDs(t, &grabber{}, s, &w, pics...); !reflect.DeepEqual(got, want[i]) {
+			t.Errorf("chapter %d: picked %v, want %v", i+1, got, want[i])
+		}
+	}
+}
+
+func TestParsePicks(t *testing.T) {
+	shown := []int{0, 1, 2, 3, 4}
+	tests := []struct {
+		answer string
+		want   []int // indexes picked, nil for an invalid answer
+	}{
+		{"1", []int{0}},
+		{"2-4, 5", []int{1, 2, 3, 4}},
+		{"5-5", []int{4}},
+		{"0", nil},
+		{"6", nil},
+		{"4-2", nil},
+		{"1-9999999999", nil},
+		{"1-99999999999999999999", nil},
+		{"a", nil},
+	}
+	for _, tt := range tests {
+		picked, err := parsePicks(tt.answer, shown, len(shown))
+		if tt.want == nil {
+			if err == nil {
+				t.Errorf("%q: picked %v, want an error", tt.answer, picked)
+			}
+			continue
+		}
+		var got []int
+		for i := range shown {
+			if picked[i] {
+				got = append(got, i)
+			}
+		}
+		if err != nil || !reflect.DeepEqual(got, tt.want) {
+			t.Errorf("%q: got %v, %v, want %v", tt.answer, got, err, tt.want)
+		}
+	}
+}
@@ -0,0 +1,153 @@
+package main
+
+import (
+	"bufio"
+	"errors"
+	"fmt"
+	"log"
+	"net/http"
+	"net/url"
+	"os"
+	"strings"
+	"sync"
+	"time"
+)
+
+// proxyBench is how long a proxy that failed a request is left out of the
+// rotation.
+const proxyBench = 5 * time.Minute
+
+type proxy struct {
+	url       *url.URL
+	transport *http.Transport
+
+	requests     int
+	failures     int
+	benchedUntil time.Time
+}
+
+// proxyRotator is a RoundTripper sending requests through a list of proxies
+// in turn. A proxy failing to connect or answering 403 is benched for a while
+// and the request is tried again through the next one. It is safe for
+// concurrent use.
+type proxyRotator struct {
+	verbose bool
+
+	mu      sync.Mutex
+	proxies []*proxy
+	next    int
+}
+
+// loadProxyList reads the proxy URLs in name, one per line. Blank lines and
+// lines starting with # are ignored.
+func loadProxyList(name string) (*proxyRotator, error) {
+	f, err := os.Open(name)
+	if err != nil {
+		return nil, fmt.Errorf("unable to read proxy list: %w", err)
+	}
+	defer f.Close()
+	r := &proxyRotator{}
+	s := bufio.NewScanner(f)
+	for s.Scan() {
+		line := strings.TrimSpace(s.Text())
+		if line == "" || strings.HasPrefix(line, "#") {
+			continue
+		}
+		u, err := url.Parse(line)
+		if err != nil || u.Host == "" {
+			return nil, fmt.Errorf("invalid proxy %q in %s", line, name)
+		}
+		r.proxies = append(r.proxies, &proxy{url: u})
+	}
+	if err := s.Err(); err != nil {
+		return nil, fmt.Errorf("unable to read proxy list: %w", err)
+	}
+	if len(r.proxies) == 0 {
+		return nil, fmt.Errorf("no proxies in %s", name)
+	}
+	return r, nil
+}
+
+// use gives every proxy a transport of its own, cloned from base.
+func (r *proxyRotator) use(base *http.Transport) {
+	for _, p := range r.proxies {
+		p.transport = base.Clone()
+		p.transport.Proxy = http.ProxyURL(p.url)
+	}
+}
+
+// pick returns the next proxy in the rotation that is not benched, or the one
+// coming off the bench soonest if they all are.
+func (r *proxyRotator) pick() *proxy {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	now := time.Now()
+	var soonest *proxy
+	for i := 0; i < len(r.proxies); i++ {
+		p := r.proxies[(r.next+i)%len(r.proxies)]
+		if !now.Before(p.benchedUntil) {
+			r.next = (r.next + i + 1) % len(r.proxies)
+			p.requests++
+			return p
+		}
+		if soonest == nil || p.benchedUntil.Before(soonest.benchedUntil) {
+			soonest = p
+		}
+	}
+	soonest.requests++
+	return soonest
+}
+
+func (r *proxyRotator) bench(p *proxy, reason string) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	p.failures++
+	p.benchedUntil = time.Now().Add(proxyBench)
+	if r.verbose {
+		log.Printf("proxy %s benched for %v: %s", p.url.Redacted(), proxyBench, reason)
+	}
+}
+
+func (r *proxyRotator) RoundTrip(req *http.Request) (*http.Response, error) {
+	var (
+		resp *http.Response
+		err  error
+	)
+	for attempt := 0; attempt < len(r.proxies); attempt++ {
+		if attempt > 0 && req.Body != nil && req.Body != http.NoBody {
+			break // the body is spent
+		}
+		p := r.pick()
+		resp, err = p.transport.RoundTrip(req)
+		switch {
+		case err != nil && !errors.Is(err, req.Context().Err()):
+			r.bench(p, err.Error())
+		case err == nil && resp.StatusCode == http.StatusForbidden:
+			r.bench(p, resp.Status)
+		default:
+			return resp, err
+		}
+		if req.Context().Err() != nil {
+			break
+		}
+		if resp != nil && attempt+1 < len(r.proxies) {
+			resp.Body.Close()
+		}
+	}
+	return resp, err
+}
+
+// logStatus logs whether each proxy is healthy or benched, and how many
+// requests it made and failed.
+func (r *proxyRotator) logStatus() {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	now := time.Now()
+	for _, p := range r.proxies {
+		status := "healthy"
+		if now.Before(p.benchedUntil) {
+			status = "benched"
+		}
+		log.Printf("proxy %s: %s, %d requests, %d failed", p.url.Redacted(), status, p.requests, p.failures)
+	}
+}
@@ -0,0 +1,27 @@
+package main
+
+import "fmt"
+
+// Renditions of a picture to download when its gallery gives several.
+const (
+	renditionLargest  = "largest"
+	renditionSmallest = "smallest"
+)
+
+func validRendition(r string) error {
+	switch r {
+	case "", renditionLargest, renditionSmallest:
+		return nil
+	}
+	return fmt.Errorf("invalid -rendition %q", r)
+}
+
+// chooseRendition returns p set to download the rendition r of it, when its
+// gallery gives several. Parsed pictures are set to the largest.
+func chooseRendition(p Picture, r string) Picture {
+	if r == renditionSmallest && len(p.Variants) > 1 {
+		v := p.Variants[len(p.Variants)-1]
+		p.URL, p.Width, p.Height = v.URL, v.Width, v.Height
+	}
+	return p
+}
@@ -0,0 +1,38 @@
+package main
+
+import (
+	"testing"
+
+	"github.com/z11i/mandalorian-art-grabber/grill"
+)
+
+func TestChooseRendition(t *testing.T) {
+	variants := []grill.Variant{
+		{Name: "retina", URL: "https://example.com/a@2x.jpeg", Width: 2560, Height: 1440},
+		{Name: "image", URL: "https://example.com/a.jpeg", Width: 1280, Height: 720},
+		{Name: "thumb", URL: "https://example.com/a-thumb.jpeg", Width: 320, Height: 180},
+	}
+	p := Picture{Picture: grill.Picture{ID: "a", URL: variants[0].URL, Width: 2560, Height: 1440, Variants: variants}}
+	tests := []struct {
+		rendition string
+		want      grill.Variant
+	}{
+		{"", variants[0]},
+		{renditionLargest, variants[0]},
+		{renditionSmallest, variants[2]},
+	}
+	for _, tt := range tests {
+		got := chooseRendition(p, tt.rendition)
+		if got.URL != tt.want.URL || got.Width != tt.want.Width || got.Height != tt.want.Height {
+			t.Errorf("%q rendition: %s %dx%d, want %s", tt.rendition, got.URL, got.Width, got.Height, tt.want.URL)
+		}
+	}
+
+	single := Picture{Picture: grill.Picture{ID: "b", URL: "https://example.com/b.jpeg"}}
+	if got := chooseRendition(single, renditionSmallest); got.URL != single.URL {
+		t.Errorf("picture without variants set to %s", got.URL)
+	}
+	if err := validRendition("medium"); err == nil {
+		t.Error("validRendition accepts medium")
+	}
+}
@@ -0,0 +1,84 @@
+package main
+
+import (
+	"context"
+	"net/http"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+const (
+	// rateLimitRetries is how many more times a download answered with 429
+	// or 503 is attempted.
+	rateLimitRetries = 3
+	// rateLimitBackoff is the first pause after a 429 or 503 without a
+	// Retry-After header, doubled on every further attempt.
+	rateLimitBackoff = 5 * time.Second
+)
+
+// rateLimited reports whether status asks the client to back off.
+func rateLimited(code int) bool {
+	return code == http.StatusTooManyRequests || code == http.StatusServiceUnavailable
+}
+
+// parseRetryAfter returns how long the Retry-After header value v asks to
+// wait from now, in either its delta-seconds or HTTP-date form.
+func parseRetryAfter(v string, now time.Time) (time.Duration, bool) {
+	v = strings.TrimSpace(v)
+	if v == "" {
+		return 0, false
+	}
+	if secs, err := strconv.Atoi(v); err == nil {
+		if secs < 0 {
+			return 0, false
+		}
+		return time.Duration(secs) * time.Second, true
+	}
+	t, err := http.ParseTime(v)
+	if err != nil {
+		return 0, false
+	}
+	if d := t.Sub(now); d > 0 {
+		return d, true
+	}
+	return 0, true
+}
+
+// pauser holds back every download of a run until a deadline, since rate
+// limits usually apply to the whole client rather than a single request. It
+// is safe for concurrent use.
+type pauser struct {
+	mu    sync.Mutex
+	until time.Time
+}
+
+// pause holds back downloads for d, unless they are already held back for
+// longer.
+func (p *pauser) pause(d time.Duration) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	if until := time.Now().Add(d); until.After(p.until) {
+		p.until = until
+	}
+}
+
+// wait returns once downloads are no longer held back, or ctx is done.
+func (p *pauser) wait(ctx context.Context) error {
+	for {
+		p.mu.Lock()
+		d := time.Until(p.until)
+		p.mu.Unlock()
+		if d <= 0 {
+			return ctx.Err()
+		}
+		t := time.NewTimer(d)
+		select {
+		case <-ctx.Done():
+			t.Stop()
+			return ctx.Err()
+		case <-t.C:
+		}
+	}
+}
@@ -0,0 +1,92 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+func TestParseRetryAfter(t *testing.T) {
+	now := time.Date(2021, 3, 4, 12, 0, 0, 0, time.UTC)
+	tests := []struct {
+		v      string
+		want   time.Duration
+		wantOK bool
+	}{
+		{"", 0, false},
+		{"120", 2 * time.Minute, true},
+		{" 7 ", 7 * time.Second, true},
+		{"0", 0, true},
+		{"-5", 0, false},
+		{"Thu, 04 Mar 2021 12:00:30 GMT", 30 * time.Second, true},
+		{"Thursday, 04-Mar-21 12:01:00 GMT", time.Minute, true},
+		{"Thu, 04 Mar 2021 11:59:00 GMT", 0, true},
+		{"soon", 0, false},
+	}
+	for _, tt := range tests {
+		got, ok := parseRetryAfter(tt.v, now)
+		if got != tt.want || ok != tt.wantOK {
+			t.Errorf("parseRetryAfter(%q) = %v, %v, want %v, %v", tt.v, got, ok, tt.want, tt.wantOK)
+		}
+	}
+}
+
+func TestSavePicVerifiedRateLimited(t *testing.T) {
+	tests := []struct {
+		name       string
+		code       int
+		retryAfter string
+		minWait    time.Duration
+	}{
+		{"delta seconds", http.StatusTooManyRequests, "0", 0},
+		{"http date in the past", http.StatusServiceUnavailable, "Thu, 04 Mar 2021 11:59:00 GMT", 0},
+		// Without the header, the backoff is used, capped by the
+		// longest wait.
+		{"absent", http.StatusTooManyRequests, "", 50 * time.Millisecond},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var served int64
+			srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+				if atomic.AddInt64(&served, 1) == 1 {
+					if tt.retryAfter != "" {
+						w.Header().Set("Retry-After", tt.retryAfter)
+					}
+					w.WriteHeader(tt.code)
+					return
+				}
+				w.Write(fakeImageData("limited"))
+			}))
+			defer srv.Close()
+			cfg := testConfig(t, srv.URL)
+			cfg.maxRetryWait = 50 * time.Millisecond
+			g := newTestGrabber(t, cfg)
+			g.state = openState(cfg.stateDir, false)
+
+			start := time.Now()
+			if err := g.savePicVerified(context.Background(), pic("limited", srv.URL+"/limited.png")); err != nil {
+				t.Fatal(err)
+			}
+			if served != 2 {
+				t.Errorf("requested %d times, want 2", served)
+			}
+			if elapsed := time.Since(start); elapsed < tt.minWait || elapsed > time.Second {
+				t.Errorf("took %v, want at least %v", elapsed, tt.minWait)
+			}
+		})
+	}
+}
+
+func TestPauserWaitCancelled(t *testing.T) {
+	var p pauser
+	p.pause(time.Hour)
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Millisecond)
+	defer cancel()
+	if err := p.wait(ctx); !errors.Is(err, context.DeadlineExceeded) {
+		t.Errorf("got %v, want %v", err, context.DeadlineExceeded)
+	}
+}
@@ -0,0 +1,159 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"os"
+	"strings"
+	"sync"
+)
+
+// segmentRetries is how many more times a segment that failed to download is
+// requested again, before the whole download fails.
+const segmentRetries = 3
+
+// errRangeIgnored is returned when a server answers a ranged request with
+// the whole picture.
+var errRangeIgnored = errors.New("server ignored the byte range requested")
+
+// segmentable reports whether url is to be downloaded in segments: whether
+// segments are enabled and a HEAD request shows that the server accepts byte
+// ranges and that the picture is at least the segment threshold. The HEAD
+// response is returned for its headers; its body is closed. If the HEAD
+// request fails, the picture is downloaded with a single GET, which reports
+// the failure.
+func (g *grabber) segmentable(ctx context.Context, url string) (*http.Response, bool) {
+	if g.cfg.segments < 2 {
+		return nil, false
+	}
+	req, err := http.NewRequestWithContext(ctx, http.MethodHead, url, nil)
+	if err != nil {
+		return nil, false
+	}
+	var head *http.Response
+	httpDo(ctx, g.client, req, func(resp *http.Response, err error) error {
+		if err != nil {
+			return err
+		}
+		resp.Body.Close()
+		head = resp
+		return nil
+	})
+	if head == nil || head.StatusCode != http.StatusOK ||
+		!strings.EqualFold(head.Header.Get("Accept-Ranges"), "bytes") ||
+		head.ContentLength < int64(g.cfg.segmentThreshold) || head.ContentLength < int64(g.cfg.segments) {
+		return nil, false
+	}
+	return head, true
+}
+
+// downloadSegmented downloads url, of the size given by its HEAD response, in
+// segments requested concurrently into a temporary file, and then hands head
+// and the reassembled content to save. A segment failing to download is
+// requested again on its own.
+func (g *grabber) downloadSegmented(ctx context.Context, url string, head *http.Response, save func(*http.Response, io.Reader) error) error {
+	size := head.ContentLength
+	tmp, err := os.CreateTemp("", "grabber-*.part")
+	if err != nil {
+		return fmt.Errorf("unable to create temporary file: %w", err)
+	}
+	defer os.Remove(tmp.Name())
+	defer tmp.Close()
+	if err := tmp.Truncate(size); err != nil {
+		return fmt.Errorf("unable to allocate temporary file: %w", err)
+	}
+
+	ctx, cancel := context.WithCancel(ctx)
+	defer cancel()
+	n := int64(g.cfg.segments)
+	segLen := (size + n - 1) / n
+	var (
+		wg   sync.WaitGroup
+		once sync.Once
+		fail error
+	)
+	for start := int64(0); start < size; start += segLen {
+		end := start + segLen
+		if end > size {
+			end = size
+		}
+		wg.Add(1)
+		go func(start, end int64) {
+			defer wg.Done()
+			if err := g.downloadSegment(ctx, url, tmp, start, end); err != nil {
+				once.Do(func() {
+					fail = err
+					cancel()
+				})
+			}
+		}(start, end)
+	}
+	wg.Wait()
+	if fail != nil {
+		return fail
+	}
+	g.debugf("downloaded %s in %d segments", url, n)
+	return save(head, io.NewSectionReader(tmp, 0, size))
+}
+
+// downloadSegment downloads the bytes of url from start up to end into f at
+// the same offsets, trying again on failure.
+func (g *grabber) downloadSegment(ctx context.Context, url string, f *os.File, start, end int64) error {
+	var err error
+	for attempt := 0; attempt <= segmentRetries; attempt++ {
+		err = g.downloadRange(ctx, url, f, start, end)
+		if err == nil || ctx.Err() != nil || errors.Is(err, errRangeIgnored) {
+			break
+		}
+		g.debugf("segment %d-%d of %s failed, trying again: %v", start, end-1, url, err)
+	}
+	if err != nil {
+		return fmt.Errorf("segment %d-%d of %s: %w", start, end-1, url, err)
+	}
+	return nil
+}
+
+// downloadRange makes a single request for the bytes of url from start up to
+// end, writing them to f at the same offsets.
+func (g *grabber) downloadRange(ctx context.Context, url string, f *os.File, start, end int64) error {
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, url, nil)
+	if err != nil {
+		return fmt.Errorf("unable to create download request: %w", err)
+	}
+	req.Header.Set("Range", fmt.Sprintf("bytes=%d-%d", start, end-1))
+	return httpDo(ctx, g.client, req, func(resp *http.Response, err error) error {
+		if err != nil {
+			return err
+		}
+		defer resp.Body.Close()
+		switch {
+		case resp.StatusCode == http.StatusOK:
+			return errRangeIgnored
+		case resp.StatusCode != http.StatusPartialContent:
+			return &statusError{code: resp.StatusCode, status: resp.Status, url: url, retryAfter: resp.Header.Get("Retry-After")}
+		case !strings.HasPrefix(resp.Header.Get("Content-Range"), fmt.Sprintf("bytes %d-%d/", start, end-1)):
+			return fmt.Errorf("unexpected Content-Range %q", resp.Header.Get("Content-Range"))
+		}
+		want := end - start
+		n, err := io.Copy(&offsetWriter{f: f, off: start}, throttle(ctx, io.LimitReader(resp.Body, want), g.bw))
+		if err == nil && n < want {
+			err = io.ErrUnexpectedEOF
+		}
+		return err
+	})
+}
+
+// offsetWriter writes to f sequentially from off.
+type offsetWriter struct {
+	f   *os.File
+	off int64
+}
+
+func (w *offsetWriter) Write(p []byte) (int, error) {
+	n, err := w.f.WriteAt(p, w.off)
+	w.off += int64(n)
+	return n, err
+}
@@ -0,0 +1,100 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"crypto/sha256"
+	"fmt"
+	"image"
+	"image/png"
+	"math/rand"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"sync"
+	"testing"
+	"time"
+)
+
+func TestSavePicSegmented(t *testing.T) {
+	// A noisy picture, which compresses poorly.
+	img := image.NewRGBA(image.Rect(0, 0, 256, 256))
+	rand.New(rand.NewSource(1)).Read(img.Pix)
+	var buf bytes.Buffer
+	if err := png.Encode(&buf, img); err != nil {
+		t.Fatal(err)
+	}
+	data := buf.Bytes()
+	segLen := (len(data) + 3) / 4
+	second := fmt.Sprintf("bytes=%d-%d", segLen, 2*segLen-1)
+	tests := []struct {
+		name        string
+		ignoreRange bool // answer ranged requests with the whole picture
+		failOnce    bool // fail the first request for the second segment
+		wantRanged  int  // ranged requests made, or at least made if ranges are ignored
+		wantWhole   int
+	}{
+		{"ranges", false, false, 4, 0},
+		{"ranges ignored", true, false, 4, 1},
+		{"segment retried", false, true, 5, 0},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var (
+				mu            sync.Mutex
+				ranged, whole int
+				failed        bool
+			)
+			srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+				if r.Method == http.MethodGet {
+					mu.Lock()
+					rng := r.Header.Get("Range")
+					if rng == "" {
+						whole++
+					} else {
+						ranged++
+					}
+					fail := tt.failOnce && !failed && rng == second
+					failed = failed || fail
+					mu.Unlock()
+					if fail {
+						http.Error(w, "try again", http.StatusServiceUnavailable)
+						return
+					}
+					if tt.ignoreRange {
+						r.Header.Del("Range")
+					}
+				}
+				w.Header().Set("Accept-Ranges", "bytes")
+				http.ServeContent(w, r, "a.png", time.Time{}, bytes.NewReader(data))
+			}))
+			defer srv.Close()
+			cfg := testConfig(t, srv.URL)
+			cfg.segments = 4
+			cfg.segmentThreshold = 1 << 10
+			cfg.breakerThreshold = 0
+			g := newTestGrabber(t, cfg)
+			g.state = openState(cfg.stateDir, false)
+			p := pic("a", srv.URL+"/a.png")
+
+			if err := g.savePic(context.Background(), p); err != nil {
+				t.Fatal(err)
+			}
+			got, err := os.ReadFile(filepath.Join(cfg.output, filepath.FromSlash(g.picName(p))))
+			if err != nil {
+				t.Fatal(err)
+			}
+			if sha256.Sum256(got) != sha256.Sum256(data) {
+				t.Errorf("downloaded %d bytes differing from the %d served", len(got), len(data))
+			}
+			if whole != tt.wantWhole {
+				t.Errorf("got %d whole requests, want %d", whole, tt.wantWhole)
+			}
+			// Once a range is ignored, segments not requested yet are not.
+			if tt.ignoreRange && (ranged < 1 || ranged > tt.wantRanged) || !tt.ignoreRange && ranged != tt.wantRanged {
+				t.Errorf("got %d ranged requests, want %d", ranged, tt.wantRanged)
+			}
+		})
+	}
+}
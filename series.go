@@ -0,0 +1,163 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/url"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+)
+
+// chapterPlaceholder is replaced by the chapter number in URL templates.
+const chapterPlaceholder = "{chapter}"
+
+// series is a show whose chapters have galleries on the site. Its URL
+// templates are paths resolved against -base-url, or absolute URLs, containing
+// chapterPlaceholder.
+type series struct {
+	Name    string   `json:"name"`             // as in the site's URLs, such as the-mandalorian
+	First   int      `json:"first"`            // first chapter
+	Last    int      `json:"last"`             // last chapter
+	Concept []string `json:"concept"`          // concept art gallery, tried in order until one is found
+	Story   string   `json:"story,omitempty"`  // story gallery, if the series has one
+	Trivia  string   `json:"trivia,omitempty"` // trivia gallery, if the series has one
+}
+
+// builtinSeries are the series that can be fetched without -sources. The
+// first is the default.
+var builtinSeries = []series{
+	{
+		Name:  "the-mandalorian",
+		First: 1,
+		Last:  16,
+		Concept: []string{
+			"/series/the-mandalorian/chapter-{chapter}-concept-art-gallery",
+			"/chapter-{chapter}-concept-art-gallery",
+		},
+		Story:  "/series/the-mandalorian/chapter-{chapter}-story-gallery",
+		Trivia: "/series/the-mandalorian/chapter-{chapter}-trivia-gallery",
+	},
+	{
+		Name:    "the-book-of-boba-fett",
+		First:   1,
+		Last:    7,
+		Concept: []string{"/series/the-book-of-boba-fett/chapter-{chapter}-concept-art-gallery"},
+		Story:   "/series/the-book-of-boba-fett/chapter-{chapter}-story-gallery",
+		Trivia:  "/series/the-book-of-boba-fett/chapter-{chapter}-trivia-gallery",
+	},
+}
+
+// loadSeries returns the built-in series along with those defined in the
+// JSON file name, if not empty. A series of the file replaces the built-in one
+// of the same name.
+func loadSeries(name string) ([]series, error) {
+	all := append([]series(nil), builtinSeries...)
+	if name == "" {
+		return all, nil
+	}
+	b, err := os.ReadFile(name)
+	if err != nil {
+		return nil, fmt.Errorf("unable to read sources: %w", err)
+	}
+	var defined []series
+	if err := json.Unmarshal(b, &defined); err != nil {
+		return nil, fmt.Errorf("unable to parse sources %s: %w", name, err)
+	}
+	for _, s := range defined {
+		if err := s.validate(); err != nil {
+			return nil, fmt.Errorf("%s: %w", name, err)
+		}
+		replaced := false
+		for i := range all {
+			if all[i].Name == s.Name {
+				all[i], replaced = s, true
+			}
+		}
+		if !replaced {
+			all = append(all, s)
+		}
+	}
+	return all, nil
+}
+
+// validate reports whether s can be fetched.
+func (s series) validate() error {
+	if s.Name == "" || s.Name == "." || s.Name == ".." || strings.HasPrefix(s.Name, ".") || strings.ContainsAny(s.Name, `/\`) {
+		return fmt.Errorf("invalid series name %q", s.Name)
+	}
+	if s.First < 1 || s.Last < s.First {
+		return fmt.Errorf("series %s: invalid chapters %d-%d", s.Name, s.First, s.Last)
+	}
+	if len(s.Concept) == 0 {
+		return fmt.Errorf("series %s: no concept art gallery", s.Name)
+	}
+	for _, t := range append(append([]string(nil), s.Concept...), s.Story, s.Trivia) {
+		if t == "" {
+			continue
+		}
+		if !strings.Contains(t, chapterPlaceholder) {
+			return fmt.Errorf("series %s: URL template %q has no %s", s.Name, t, chapterPlaceholder)
+		}
+		if strings.HasPrefix(t, "/") {
+			continue
+		}
+		if u, err := url.Parse(t); err != nil || (u.Scheme != "http" && u.Scheme != "https") || u.Host == "" {
+			return fmt.Errorf("series %s: URL template %q is neither a path nor an absolute URL", s.Name, t)
+		}
+	}
+	return nil
+}
+
+// lookupSeries returns the series of all with name, or the default one if
+// name is empty.
+func lookupSeries(all []series, name string) (series, error) {
+	if name == "" {
+		return all[0], nil
+	}
+	var names []string
+	for _, s := range all {
+		if s.Name == name {
+			return s, nil
+		}
+		names = append(names, s.Name)
+	}
+	return series{}, fmt.Errorf("unknown -series %q, expected one of %s", name, strings.Join(names, ", "))
+}
+
+// isDefault reports whether s is the default series, whose pictures are kept
+// at the top of the output for compatibility with earlier runs.
+func (s series) isDefault() bool {
+	return s.Name == builtinSeries[0].Name
+}
+
+// chapterRange returns every chapter of s.
+func (s series) chapterRange() []int {
+	var chapters []int
+	for i := s.First; i <= s.Last; i++ {
+		chapters = append(chapters, i)
+	}
+	return chapters
+}
+
+// galleryURL returns the URL of template for chapter, resolving paths against
+// base.
+func galleryURL(base, template string, chapter int) string {
+	u := strings.ReplaceAll(template, chapterPlaceholder, strconv.Itoa(chapter))
+	if strings.HasPrefix(u, "/") {
+		return strings.TrimSuffix(base, "/") + u
+	}
+	return u
+}
+
+// isSeriesDir reports whether dir is where the pictures of a series of all
+// other than the default are stored in output.
+func isSeriesDir(all []series, output, dir string) bool {
+	for _, s := range all {
+		if !s.isDefault() && dir == filepath.Join(output, s.Name) {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,95 @@
+package main
+
+import (
+	"context"
+	"os"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+// galleryStream returns the URLs of every gallery g generates for chapters,
+// in order, each prefixed with its kind.
+func galleryStream(g *grabber, chapters ...int) []string {
+	var urls []string
+	for gal := range g.generateGalleryURLs(context.Background(), chapters) {
+		for _, u := range gal.urls {
+			urls = append(urls, strings.TrimSpace(gal.kind+" "+u))
+		}
+	}
+	return urls
+}
+
+// writeSources writes a -sources file holding content, returning its name.
+func writeSources(t *testing.T, content string) string {
+	t.Helper()
+	name := filepath.Join(t.TempDir(), "sources.json")
+	if err := os.WriteFile(name, []byte(content), 0644); err != nil {
+		t.Fatal(err)
+	}
+	return name
+}
+
+func TestGalleryURLTemplates(t *testing.T) {
+	const base = "https://www.starwars.com"
+	cfg := testConfig(t, base)
+	if got, want := galleryStream(newTestGrabber(t, cfg), 1, 2), []string{
+		base + "/series/the-mandalorian/chapter-1-concept-art-gallery",
+		base + "/chapter-1-concept-art-gallery",
+		base + "/series/the-mandalorian/chapter-2-concept-art-gallery",
+		base + "/chapter-2-concept-art-gallery",
+	}; !reflect.DeepEqual(got, want) {
+		t.Errorf("built-in templates: got %q, want %q", got, want)
+	}
+
+	cfg.sourcesFile = writeSources(t, `[{
+		"name": "andor",
+		"first": 1,
+		"last": 12,
+		"concept": ["/series/andor/episode-{chapter}-art", "https://art.example.com/andor/{chapter}/{chapter}.html"],
+		"story": "/series/andor/episode-{chapter}-story"
+	}]`)
+	cfg.series = "andor"
+	cfg.story = true
+	g := newTestGrabber(t, cfg)
+	if got, want := galleryStream(g, 3, 4), []string{
+		base + "/series/andor/episode-3-art",
+		"https://art.example.com/andor/3/3.html",
+		kindStory + " " + base + "/series/andor/episode-3-story",
+		base + "/series/andor/episode-4-art",
+		"https://art.example.com/andor/4/4.html",
+		kindStory + " " + base + "/series/andor/episode-4-story",
+	}; !reflect.DeepEqual(got, want) {
+		t.Errorf("custom templates: got %q, want %q", got, want)
+	}
+	if got := g.series.chapterRange(); len(got) != 12 || got[0] != 1 {
+		t.Errorf("chapters %v, want 1 to 12", got)
+	}
+}
+
+func TestLoadSeriesInvalid(t *testing.T) {
+	tests := []string{
+		`[{"name": "andor", "first": 1, "last": 12, "concept": ["/series/andor/episode-art"]}]`,
+		`[{"name": "andor", "first": 1, "last": 12, "concept": ["series/andor/episode-{chapter}-art"]}]`,
+		`[{"name": "andor", "first": 1, "last": 12, "concept": ["/a-{chapter}"], "trivia": "/trivia"}]`,
+		`[{"name": "andor", "first": 1, "last": 12, "concept": []}]`,
+		`[{"name": "andor", "first": 5, "last": 2, "concept": ["/a-{chapter}"]}]`,
+		`[{"name": "../andor", "first": 1, "last": 12, "concept": ["/a-{chapter}"]}]`,
+		`{"name": "andor"}`,
+	}
+	for _, content := range tests {
+		if _, err := loadSeries(writeSources(t, content)); err == nil {
+			t.Errorf("%s accepted", content)
+		}
+	}
+
+	// A series of the file replaces the built-in one of its name.
+	all, err := loadSeries(writeSources(t, `[{"name": "the-mandalorian", "first": 1, "last": 24, "concept": ["/mando/{chapter}"]}]`))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if s, err := lookupSeries(all, ""); err != nil || s.Last != 24 || len(all) != len(builtinSeries) {
+		t.Errorf("got default series %+v, %v among %d, want the file's", s, err, len(all))
+	}
+}
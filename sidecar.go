@@ -0,0 +1,30 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"strings"
+)
+
+// writeSidecar writes the untruncated caption of p, with its description,
+// credit, date, ID and source URL, to a text file next to the image fname in
+// s. It returns the sidecar's name.
+func writeSidecar(ctx context.Context, s Storer, fname string, p Picture) (string, error) {
+	var b strings.Builder
+	fmt.Fprintln(&b, p.Caption)
+	fmt.Fprintln(&b)
+	if p.Description != "" {
+		fmt.Fprintln(&b, p.Description)
+		fmt.Fprintln(&b)
+	}
+	if p.Credit != "" {
+		fmt.Fprintf(&b, "Credit: %s\n", p.Credit)
+	}
+	if p.PublishedAt != "" {
+		fmt.Fprintf(&b, "Published: %s\n", p.PublishedAt)
+	}
+	fmt.Fprintf(&b, "ID: %s\n", p.ID)
+	fmt.Fprintf(&b, "Source: %s\n", p.URL)
+	name := fname + ".txt"
+	return name, writeFile(ctx, s, name, []byte(b.String()))
+}
@@ -0,0 +1,232 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"hash/fnv"
+	"image"
+	"image/color"
+	"image/png"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"path"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+)
+
+// errorPageHTML is the page the site serves for galleries that do not exist.
+const errorPageHTML = `<!DOCTYPE html><html><head><title>Page Not Found</title></head><body><div id="main"><article id="error_page"><h1>These aren't the droids you're looking for.</h1></article></div></body></html>`
+
+// fakeImage is a picture of a gallery served by a fakeSite.
+type fakeImage struct {
+	id, caption string
+}
+
+// fakeSite serves gallery pages and the images they list, counting requests.
+// Paths without a page answer with a 404 and the site's error page; images
+// are served under /img/.
+type fakeSite struct {
+	*httptest.Server
+	mu          sync.Mutex
+	pages       map[string]string // HTML by path
+	status      map[string]int    // status of the pages not answering 200, by path
+	hits        map[string]int    // requests by path
+	inflight    int
+	maxInflight int
+	delay       time.Duration // before answering each request
+}
+
+func newFakeSite(t testing.TB) *fakeSite {
+	s := &fakeSite{pages: make(map[string]string), status: make(map[string]int), hits: make(map[string]int)}
+	s.Server = httptest.NewServer(http.HandlerFunc(s.serve))
+	t.Cleanup(s.Close)
+	return s
+}
+
+func (s *fakeSite) serve(w http.ResponseWriter, r *http.Request) {
+	s.mu.Lock()
+	s.hits[r.URL.Path]++
+	s.inflight++
+	if s.inflight > s.maxInflight {
+		s.maxInflight = s.inflight
+	}
+	page, ok := s.pages[r.URL.Path]
+	status, delay := s.status[r.URL.Path], s.delay
+	s.mu.Unlock()
+	defer func() {
+		s.mu.Lock()
+		s.inflight--
+		s.mu.Unlock()
+	}()
+	select {
+	case <-time.After(delay):
+	case <-r.Context().Done():
+		return
+	}
+	switch {
+	case strings.HasPrefix(r.URL.Path, "/img/"):
+		w.Header().Set("Content-Type", "image/png")
+		w.Write(fakeImageData(path.Base(r.URL.Path)))
+	case !ok:
+		w.WriteHeader(http.StatusNotFound)
+		io.WriteString(w, errorPageHTML)
+	default:
+		if status != 0 {
+			w.WriteHeader(status)
+		}
+		io.WriteString(w, page)
+	}
+}
+
+// gallery serves at p a gallery page listing images.
+func (s *fakeSite) gallery(p string, images ...fakeImage) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.pages[p] = galleryHTML(s.URL, images)
+}
+
+// pagedGallery serves at p a gallery page listing images, linking to next as
+// its next page.
+func (s *fakeSite) pagedGallery(p, next string, images ...fakeImage) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.pages[p] = strings.Replace(galleryHTML(s.URL, images), "<head>", `<head><link rel="next" href="`+next+`">`, 1)
+}
+
+// page serves html at p, answering with code.
+func (s *fakeSite) page(p, html string, code int) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.pages[p] = html
+	s.status[p] = code
+}
+
+// hitsOf returns the number of requests for p.
+func (s *fakeSite) hitsOf(p string) int {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.hits[p]
+}
+
+// peak returns the largest number of requests that were in flight at once.
+func (s *fakeSite) peak() int {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.maxInflight
+}
+
+// conceptPath returns the path of the concept art gallery of chapter of The
+// Mandalorian under the first of its URL templates, or the second if second.
+func conceptPath(chapter int, second bool) string {
+	if second {
+		return fmt.Sprintf("/chapter-%d-concept-art-gallery", chapter)
+	}
+	return fmt.Sprintf("/series/the-mandalorian/chapter-%d-concept-art-gallery", chapter)
+}
+
+// imageURL returns the URL of the image of id served by the site.
+func (s *fakeSite) imageURL(id string) string {
+	return s.URL + "/img/" + id + ".png"
+}
+
+// galleryHTML returns a gallery page laid out like the site's, listing
+// images served from base.
+func galleryHTML(base string, images []fakeImage) string {
+	type entry struct {
+		Image   string `json:"image"`
+		Caption string `json:"caption"`
+		ID      string `json:"id"`
+	}
+	entries := make([]entry, 0, len(images))
+	for _, img := range images {
+		entries = append(entries, entry{Image: base + "/img/" + img.id + ".png", Caption: img.caption, ID: img.id})
+	}
+	data, err := json.Marshal(map[string]interface{}{
+		"stack": []interface{}{
+			map[string]interface{}{"data": []interface{}{map[string]interface{}{"title": "Chapter"}}},
+			map[string]interface{}{"data": []interface{}{}},
+			map[string]interface{}{"data": []interface{}{map[string]interface{}{"images": entries}}},
+		},
+	})
+	if err != nil {
+		panic(err)
+	}
+	return `<!DOCTYPE html><html><head><title>Concept Art Gallery</title><script src="/analytics.js"></script></head><body>` +
+		`<div id="main"><script>window.dataLayer = window.dataLayer || [];</script>` +
+		`<script>this.Grill?Grill.burger=` + string(data) + `:(function(){console.log("no grill")})();</script></div></body></html>`
+}
+
+// fakeImageData returns a tiny PNG, different for every name.
+func fakeImageData(name string) []byte {
+	h := fnv.New32a()
+	io.WriteString(h, name)
+	sum := h.Sum32()
+	img := image.NewRGBA(image.Rect(0, 0, 2, 2))
+	img.Set(0, 0, color.RGBA{R: uint8(sum), G: uint8(sum >> 8), B: uint8(sum >> 16), A: 255})
+	var buf bytes.Buffer
+	if err := png.Encode(&buf, img); err != nil {
+		panic(err)
+	}
+	return buf.Bytes()
+}
+
+// testConfig returns the default configuration, fetching from base into a
+// temporary directory without the delays meant for the real site.
+func testConfig(t testing.TB, base string) config {
+	cfg := defaultConfig()
+	cfg.baseURL = base
+	cfg.output = t.TempDir()
+	cfg.startJitter = 0
+	cfg.retryDelay = 0
+	cfg.minFree = 0
+	cfg.quiet = true
+	return cfg
+}
+
+// newTestGrabber returns a grabber for cfg, failing t if there is none.
+func newTestGrabber(t testing.TB, cfg config) *grabber {
+	t.Helper()
+	g, err := newGrabber(cfg)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return g
+}
+
+// galleriesOf returns a closed channel of the concept art galleries of
+// chapters, as generated for g's series.
+func galleriesOf(g *grabber, chapters ...int) <-chan gallery {
+	c := make(chan gallery, len(chapters))
+	for _, chap := range chapters {
+		gal := gallery{chapter: chap, templates: true}
+		for _, t := range g.series.Concept {
+			gal.urls = append(gal.urls, galleryURL(g.cfg.baseURL, t, chap))
+		}
+		c <- gal
+	}
+	close(c)
+	return c
+}
+
+// collect returns every picture sent on pics until it is closed, failing t
+// if that takes longer than timeout.
+func collect(t *testing.T, pics <-chan Picture, timeout time.Duration) []Picture {
+	t.Helper()
+	var all []Picture
+	deadline := time.After(timeout)
+	for {
+		select {
+		case p, ok := <-pics:
+			if !ok {
+				return all
+			}
+			all = append(all, p)
+		case <-deadline:
+			t.Fatalf("channel not closed after %v, %d pictures received", timeout, len(all))
+		}
+	}
+}
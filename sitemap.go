@@ -0,0 +1,167 @@
+package main
+
+import (
+	"bufio"
+	"compress/gzip"
+	"context"
+	"encoding/xml"
+	"fmt"
+	"io"
+	"log"
+	"net/http"
+	"net/url"
+	"regexp"
+	"strconv"
+	"strings"
+	"sync"
+)
+
+// sitemapDepth is how many levels of sitemap indexes are followed below the
+// site's sitemap.
+const sitemapDepth = 3
+
+// chapterInURL finds the chapter number in a gallery URL.
+var chapterInURL = regexp.MustCompile(`chapter-(\d+)`)
+
+// sitemap is a sitemap or a sitemap index: only one of its lists is set.
+type sitemap struct {
+	Sitemaps []struct {
+		Loc string `xml:"loc"`
+	} `xml:"sitemap"`
+	URLs []struct {
+		Loc string `xml:"loc"`
+	} `xml:"url"`
+}
+
+// sitemapPages are the page URLs listed in the site's sitemap, read once per
+// run however many times galleries are generated.
+type sitemapPages struct {
+	once  sync.Once
+	pages []string
+	err   error
+}
+
+// discoverPattern returns the regular expression that gallery URLs listed in
+// the sitemap must match: -discover-pattern, or by default the concept art
+// galleries of the series.
+func (g *grabber) discoverPattern() (*regexp.Regexp, error) {
+	if g.cfg.discoverPattern != "" {
+		re, err := regexp.Compile(g.cfg.discoverPattern)
+		if err != nil {
+			return nil, fmt.Errorf("invalid -discover-pattern: %w", err)
+		}
+		return re, nil
+	}
+	return regexp.MustCompile(regexp.QuoteMeta(g.series.Name) + `/.*concept-art-gallery`), nil
+}
+
+// discoverGalleries returns the galleries of chapters listed in the site's
+// sitemap that match the discovery pattern, leaving out those at any URL of
+// known. Galleries without a chapter number in their URL, such as specials,
+// are only returned when every chapter is selected. Their URLs are rooted at
+// the configured base URL.
+func (g *grabber) discoverGalleries(ctx context.Context, chapters []int, known map[string]bool) ([]gallery, error) {
+	g.sitemap.once.Do(func() {
+		g.sitemap.pages, g.sitemap.err = g.readSitemap(ctx, g.cfg.sitemapURL, sitemapDepth, make(map[string]bool))
+	})
+	if g.sitemap.err != nil {
+		return nil, g.sitemap.err
+	}
+	selected := make(map[int]bool, len(chapters))
+	for _, chap := range chapters {
+		selected[chap] = true
+	}
+	var gals []gallery
+	seen := make(map[string]bool)
+	for _, page := range g.sitemap.pages {
+		if !g.discover.MatchString(page) {
+			continue
+		}
+		u, err := url.Parse(page)
+		if err != nil {
+			g.debugf("ignoring %s in sitemap: %v", page, err)
+			continue
+		}
+		rooted := strings.TrimSuffix(g.cfg.baseURL, "/") + u.RequestURI()
+		if known[rooted] || seen[rooted] {
+			continue
+		}
+		seen[rooted] = true
+		chap := 0
+		if m := chapterInURL.FindStringSubmatch(u.Path); m != nil {
+			chap, _ = strconv.Atoi(m[1])
+		}
+		if chap == 0 && !g.cfg.allChapters || chap != 0 && !selected[chap] {
+			continue
+		}
+		gals = append(gals, gallery{chapter: chap, urls: []string{rooted}})
+	}
+	return gals, nil
+}
+
+// readSitemap returns the page URLs listed in the sitemap at loc, following
+// sitemap indexes down to depth levels. Sitemaps already in visited are
+// skipped.
+func (g *grabber) readSitemap(ctx context.Context, loc string, depth int, visited map[string]bool) ([]string, error) {
+	if visited[loc] {
+		return nil, nil
+	}
+	visited[loc] = true
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, loc, nil)
+	if err != nil {
+		return nil, fmt.Errorf("error creating request: %w", err)
+	}
+	req.Header.Set("Accept-Encoding", acceptEncoding)
+	var sm sitemap
+	err = httpDo(ctx, g.client, req, func(resp *http.Response, err error) error {
+		if err != nil {
+			return err
+		}
+		defer resp.Body.Close()
+		if resp.StatusCode != http.StatusOK {
+			return &statusError{code: resp.StatusCode, status: resp.Status, url: loc, retryAfter: resp.Header.Get("Retry-After")}
+		}
+		body, err := decodedBody(resp)
+		if err != nil {
+			return err
+		}
+		if body, err = gunzipped(body); err != nil {
+			return err
+		}
+		return xml.NewDecoder(body).Decode(&sm)
+	})
+	if err != nil {
+		return nil, fmt.Errorf("unable to read sitemap %s: %w", loc, err)
+	}
+	var pages []string
+	for _, u := range sm.URLs {
+		pages = append(pages, strings.TrimSpace(u.Loc))
+	}
+	for _, s := range sm.Sitemaps {
+		if depth == 0 {
+			g.debugf("not following sitemap %s, too deep", s.Loc)
+			continue
+		}
+		p, err := g.readSitemap(ctx, strings.TrimSpace(s.Loc), depth-1, visited)
+		if err != nil {
+			if ctx.Err() != nil {
+				return nil, err
+			}
+			// One broken sitemap of an index should not hide the others.
+			log.Printf("warning: %v", err)
+			continue
+		}
+		pages = append(pages, p...)
+	}
+	return pages, nil
+}
+
+// gunzipped returns r decompressed if it is gzip data, as sitemaps ending in
+// .xml.gz are, or r as it is otherwise.
+func gunzipped(r io.Reader) (io.Reader, error) {
+	br := bufio.NewReader(r)
+	if magic, err := br.Peek(2); err == nil && magic[0] == 0x1f && magic[1] == 0x8b {
+		return gzip.NewReader(br)
+	}
+	return br, nil
+}
@@ -0,0 +1,144 @@
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log"
+	"os"
+	"path/filepath"
+	"time"
+)
+
+// stateFile is the name of the file in the state directory recording every
+// completed download, so that later runs can skip them even if the files were
+// moved or renamed.
+const stateFile = ".grabber-state.json"
+
+// stateFlushInterval is how often recorded downloads are written to disk.
+const stateFlushInterval = 5 * time.Second
+
+type stateEntry struct {
+	URL       string    `json:"url"`
+	File      string    `json:"file,omitempty"` // relative to the output
+	SHA256    string    `json:"sha256"`
+	Size      int64     `json:"size"`
+	Completed time.Time `json:"completed"`
+}
+
+type stateUpdate struct {
+	id    string
+	entry stateEntry
+}
+
+// stateStore tracks completed downloads by picture ID. Updates are serialized
+// through a single goroutine which flushes them periodically and on close.
+type stateStore struct {
+	path    string
+	done    map[string]stateEntry // completed before this run, read-only
+	updates chan stateUpdate
+	closed  chan error
+}
+
+// openState loads the state of dir and starts its writer. With ignore set,
+// previously completed downloads are not reported as completed, but are
+// still kept in the file.
+func openState(dir string, ignore bool) *stateStore {
+	s := &stateStore{
+		path:    filepath.Join(dir, stateFile),
+		done:    make(map[string]stateEntry),
+		updates: make(chan stateUpdate, 16),
+		closed:  make(chan error, 1),
+	}
+	if done, err := readState(dir); err != nil {
+		log.Printf("ignoring state file: %v", err)
+	} else {
+		s.done = done
+	}
+	all := make(map[string]stateEntry, len(s.done))
+	for id, e := range s.done {
+		all[id] = e
+	}
+	if ignore {
+		s.done = map[string]stateEntry{}
+	}
+	go s.write(all)
+	return s
+}
+
+// readState reads the completed downloads recorded in dir. A missing state
+// file records none.
+func readState(dir string) (map[string]stateEntry, error) {
+	done := make(map[string]stateEntry)
+	b, err := os.ReadFile(filepath.Join(dir, stateFile))
+	if errors.Is(err, os.ErrNotExist) {
+		return done, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	if err := json.Unmarshal(b, &done); err != nil {
+		return nil, fmt.Errorf("corrupt state file: %w", err)
+	}
+	return done, nil
+}
+
+// completed reports whether the picture with id was downloaded by an earlier
+// run.
+func (s *stateStore) completed(id string) bool {
+	if id == "" {
+		return false
+	}
+	_, ok := s.done[id]
+	return ok
+}
+
+// record marks the picture with id as downloaded.
+func (s *stateStore) record(id string, e stateEntry) {
+	if id == "" {
+		return
+	}
+	s.updates <- stateUpdate{id: id, entry: e}
+}
+
+// close flushes pending updates and stops the writer. record must not be
+// called afterwards.
+func (s *stateStore) close() error {
+	close(s.updates)
+	return <-s.closed
+}
+
+func (s *stateStore) write(entries map[string]stateEntry) {
+	ticker := time.NewTicker(stateFlushInterval)
+	defer ticker.Stop()
+	dirty := false
+	flush := func() error {
+		if !dirty {
+			return nil
+		}
+		b, err := json.MarshalIndent(entries, "", "  ")
+		if err != nil {
+			return err
+		}
+		if err := writeFileAtomic(s.path, b, 0600); err != nil {
+			return err
+		}
+		dirty = false
+		return nil
+	}
+	for {
+		select {
+		case u, ok := <-s.updates:
+			if !ok {
+				s.closed <- flush()
+				return
+			}
+			entries[u.id] = u.entry
+			dirty = true
+		case <-ticker.C:
+			if err := flush(); err != nil {
+				log.Printf("unable to save state: %v", err)
+			}
+		}
+	}
+}
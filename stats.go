@@ -0,0 +1,93 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"log"
+	"net/http"
+	"os"
+	"sync/atomic"
+	"time"
+)
+
+// stats counts what a run has done. All fields are accessed atomically.
+type stats struct {
+	chapters       int64 // chapters with a gallery
+	galleries      int64 // gallery pages parsed
+	galleryErrors  int64 // gallery pages that failed to download or parse
+	found          int64 // pictures found on gallery pages
+	downloaded     int64 // pictures downloaded
+	downloadErrors int64 // failed download attempts
+	skipped        int64 // pictures skipped as already downloaded
+	bytes          int64 // bytes written to downloaded files
+	received       int64 // bytes of pictures received, including failed downloads
+	reachable      int64 // pictures found downloadable by a head check
+	unreachable    int64 // pictures found not downloadable by a head check
+	expectedBytes  int64 // sizes reported for reachable pictures
+}
+
+func (s *stats) add(field *int64, n int64) {
+	atomic.AddInt64(field, n)
+}
+
+// writeMetrics writes s in the Prometheus text exposition format.
+func (s *stats) writeMetrics(w io.Writer) error {
+	metrics := []struct {
+		name, help string
+		value      *int64
+	}{
+		{"grabber_chapters_found_total", "Chapters with a gallery.", &s.chapters},
+		{"grabber_galleries_parsed_total", "Gallery pages parsed.", &s.galleries},
+		{"grabber_gallery_errors_total", "Gallery pages that failed to download or parse.", &s.galleryErrors},
+		{"grabber_pictures_found_total", "Pictures found on gallery pages.", &s.found},
+		{"grabber_pictures_downloaded_total", "Pictures downloaded.", &s.downloaded},
+		{"grabber_download_errors_total", "Failed picture download attempts.", &s.downloadErrors},
+		{"grabber_pictures_skipped_total", "Pictures skipped because they were already downloaded.", &s.skipped},
+		{"grabber_downloaded_bytes_total", "Bytes written to downloaded files.", &s.bytes},
+		{"grabber_received_bytes_total", "Bytes of pictures received, including failed downloads.", &s.received},
+	}
+	for _, m := range metrics {
+		_, err := fmt.Fprintf(w, "# HELP %s %s\n# TYPE %s counter\n%s %d\n", m.name, m.help, m.name, m.name, atomic.LoadInt64(m.value))
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// serveMetrics serves the run's counters at /metrics on addr until ctx is
+// done.
+func (g *grabber) serveMetrics(ctx context.Context, addr string) {
+	mux := http.NewServeMux()
+	mux.HandleFunc("/metrics", func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "text/plain; version=0.0.4")
+		g.stats.writeMetrics(w)
+	})
+	srv := &http.Server{Addr: addr, Handler: mux}
+	go func() {
+		<-ctx.Done()
+		shutdownCtx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+		defer cancel()
+		srv.Shutdown(shutdownCtx)
+	}()
+	go func() {
+		if err := srv.ListenAndServe(); err != nil && !errors.Is(err, http.ErrServerClosed) {
+			log.Printf("unable to serve metrics: %v", err)
+		}
+	}()
+}
+
+// saveMetrics writes a snapshot of the run's counters to name.
+func (g *grabber) saveMetrics(name string) error {
+	f, err := os.Create(name)
+	if err != nil {
+		return err
+	}
+	if err := g.stats.writeMetrics(f); err != nil {
+		f.Close()
+		return err
+	}
+	return f.Close()
+}
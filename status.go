@@ -0,0 +1,94 @@
+package main
+
+import (
+	"fmt"
+	"io"
+	"os"
+	"sort"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+// inflight tracks the pictures being downloaded, by URL, with when each
+// download started. It is safe for concurrent use.
+type inflight struct {
+	mu    sync.Mutex
+	start map[string]time.Time
+}
+
+func (f *inflight) add(url string) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	if f.start == nil {
+		f.start = make(map[string]time.Time)
+	}
+	f.start[url] = time.Now()
+}
+
+func (f *inflight) remove(url string) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	delete(f.start, url)
+}
+
+// list returns the URLs being downloaded, longest running first, with how long
+// each has been running at now.
+func (f *inflight) list(now time.Time) ([]string, []time.Duration) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	urls := make([]string, 0, len(f.start))
+	for url := range f.start {
+		urls = append(urls, url)
+	}
+	sort.Slice(urls, func(i, j int) bool { return f.start[urls[i]].Before(f.start[urls[j]]) })
+	running := make([]time.Duration, len(urls))
+	for i, url := range urls {
+		running[i] = now.Sub(f.start[url])
+	}
+	return urls, running
+}
+
+// pending returns how many expected pictures are neither complete nor failed:
+// those waiting for a download worker and those being downloaded.
+func (c *completion) pending() int {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	n := 0
+	for key := range c.pics {
+		if !c.done[key] && c.lastErr[key] == nil {
+			n++
+		}
+	}
+	return n
+}
+
+// writeStatus writes a snapshot of the progress of the run to w.
+func (g *grabber) writeStatus(w io.Writer, now time.Time) {
+	urls, running := g.inflight.list(now)
+	queued := g.completion.pending() - len(urls)
+	if queued < 0 {
+		queued = 0
+	}
+	fmt.Fprintf(w, "status after %v:\n", now.Sub(g.started).Round(time.Second))
+	fmt.Fprintf(w, "  chapters: %d found, highest %d, %d missing\n",
+		atomic.LoadInt64(&g.stats.chapters), atomic.LoadInt64(&g.highest), atomic.LoadInt64(&g.missing))
+	fmt.Fprintf(w, "  gallery pages: %d parsed, %d failed\n",
+		atomic.LoadInt64(&g.stats.galleries), atomic.LoadInt64(&g.stats.galleryErrors))
+	fmt.Fprintf(w, "  pictures: %d found, %d queued, %d downloading, %d downloaded, %d skipped, %d failed\n",
+		atomic.LoadInt64(&g.stats.found), queued, len(urls), atomic.LoadInt64(&g.stats.downloaded),
+		atomic.LoadInt64(&g.stats.skipped), len(g.failed.list()))
+	if g.adaptive != nil {
+		fmt.Fprintf(w, "  adaptive: download concurrency %d of %d\n", g.adaptive.current(), worker)
+	}
+	fmt.Fprintf(w, "  received %s, written %s\n",
+		formatBytes(atomic.LoadInt64(&g.stats.received)), formatBytes(atomic.LoadInt64(&g.stats.bytes)))
+	for i, url := range urls {
+		fmt.Fprintf(w, "  downloading %s for %v\n", url, running[i].Round(time.Second))
+	}
+}
+
+// dumpStatus writes a snapshot of the progress of the run to standard error.
+func (g *grabber) dumpStatus() {
+	g.writeStatus(os.Stderr, time.Now())
+}
@@ -0,0 +1,65 @@
+package main
+
+import (
+	"bytes"
+	"errors"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestWriteStatusAdaptive(t *testing.T) {
+	g := newTestGrabber(t, testConfig(t, "https://example.com"))
+	g.started = time.Now()
+	var buf bytes.Buffer
+	g.writeStatus(&buf, g.started)
+	if strings.Contains(buf.String(), "adaptive") {
+		t.Errorf("adaptive level shown without -adaptive:\n%s", buf.String())
+	}
+
+	g.adaptive = newAdaptiveLimiter(worker)
+	g.adaptive.level = 2
+	buf.Reset()
+	g.writeStatus(&buf, g.started)
+	if want := "  adaptive: download concurrency 2 of 5\n"; !strings.Contains(buf.String(), want) {
+		t.Errorf("status\n%s\nlacks %q", buf.String(), want)
+	}
+}
+
+func TestWriteStatus(t *testing.T) {
+	g := newTestGrabber(t, testConfig(t, "https://example.com"))
+	now := time.Date(2021, 3, 4, 12, 0, 0, 0, time.UTC)
+	g.started = now.Add(-90 * time.Second)
+	g.stats.chapters, g.highest, g.missing = 3, 4, 1
+	g.stats.galleries, g.stats.galleryErrors = 5, 1
+	g.stats.found, g.stats.downloaded, g.stats.skipped = 9, 3, 1
+	g.stats.received, g.stats.bytes = 3<<20, 2<<20
+	pics := []Picture{pic("1", "https://example.com/1.jpeg"), pic("2", "https://example.com/2.jpeg"),
+		pic("3", "https://example.com/3.jpeg"), pic("4", "https://example.com/4.jpeg"), pic("5", "https://example.com/5.jpeg"),
+		pic("6", "https://example.com/6.jpeg"), pic("7", "https://example.com/7.jpeg")}
+	for _, p := range pics {
+		g.completion.expect(p)
+	}
+	g.completion.complete(pics[0])
+	g.completion.complete(pics[1])
+	g.completion.fail(pics[2], errors.New("unexpected status 500"))
+	g.failed.add(pics[2], errors.New("unexpected status 500"))
+	g.inflight.start = map[string]time.Time{
+		pics[3].URL: now.Add(-2 * time.Second),
+		pics[4].URL: now.Add(-20 * time.Second),
+	}
+
+	var buf bytes.Buffer
+	g.writeStatus(&buf, now)
+	want := `status after 1m30s:
+  chapters: 3 found, highest 4, 1 missing
+  gallery pages: 5 parsed, 1 failed
+  pictures: 9 found, 2 queued, 2 downloading, 3 downloaded, 1 skipped, 1 failed
+  received 3.0 MiB, written 2.0 MiB
+  downloading https://example.com/5.jpeg for 20s
+  downloading https://example.com/4.jpeg for 2s
+`
+	if got := buf.String(); got != want {
+		t.Errorf("status =\n%s\nwant\n%s", got, want)
+	}
+}
@@ -0,0 +1,9 @@
+//go:build !linux && !darwin
+
+package main
+
+// handleStatusSignal does nothing: there is no SIGUSR1 on this platform. The
+// metrics served with -metrics-addr give the progress of a run instead.
+func (g *grabber) handleStatusSignal() (stop func()) {
+	return func() {}
+}
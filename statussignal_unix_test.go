@@ -0,0 +1,57 @@
+//go:build linux || darwin
+
+package main
+
+import (
+	"bufio"
+	"os"
+	"strings"
+	"syscall"
+	"testing"
+	"time"
+)
+
+func TestHandleStatusSignal(t *testing.T) {
+	r, w, err := os.Pipe()
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer r.Close()
+	stderr := os.Stderr
+	os.Stderr = w
+	defer func() { os.Stderr = stderr }()
+
+	g := newTestGrabber(t, testConfig(t, "https://example.com"))
+	g.started = time.Now()
+	g.stats.found = 42
+	stop := g.handleStatusSignal()
+	defer stop()
+	if err := syscall.Kill(syscall.Getpid(), syscall.SIGUSR1); err != nil {
+		t.Fatal(err)
+	}
+
+	lines := make(chan string, 100)
+	go func() {
+		s := bufio.NewScanner(r)
+		for s.Scan() {
+			lines <- s.Text()
+		}
+		close(lines)
+	}()
+	timeout := time.After(5 * time.Second)
+	for {
+		select {
+		case line, ok := <-lines:
+			if !ok {
+				t.Fatal("no status written")
+			}
+			if strings.HasPrefix(line, "  pictures: 42 found,") {
+				w.Close()
+				return
+			}
+		case <-timeout:
+			w.Close()
+			t.Fatal("no status written on SIGUSR1")
+		}
+	}
+}
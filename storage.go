@@ -0,0 +1,284 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"mime"
+	"net/url"
+	"os"
+	"path"
+	"path/filepath"
+	"strings"
+	"time"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+	awshttp "github.com/aws/aws-sdk-go-v2/aws/transport/http"
+	awsconfig "github.com/aws/aws-sdk-go-v2/config"
+	"github.com/aws/aws-sdk-go-v2/feature/s3/manager"
+	"github.com/aws/aws-sdk-go-v2/service/s3"
+)
+
+// Storer stores downloaded files. Names are slash-separated paths relative to
+// the root of the storage. Remote storers give up on a call once its ctx is
+// done.
+type Storer interface {
+	// Create returns a writer for the file name. The file only appears under
+	// name once the writer is closed without error; if the writer also
+	// implements aborter, calling Abort discards everything written.
+	Create(ctx context.Context, name string) (io.WriteCloser, error)
+	// Exists reports whether the file name exists.
+	Exists(ctx context.Context, name string) (bool, error)
+	// Remove deletes the file name.
+	Remove(ctx context.Context, name string) error
+}
+
+// aborter is implemented by writers whose partial content can be discarded.
+type aborter interface {
+	Abort() error
+}
+
+// statter is implemented by storers able to report the size and modification
+// time of a file. Stat returns an error wrapping os.ErrNotExist if there is no
+// file name.
+type statter interface {
+	Stat(ctx context.Context, name string) (size int64, modTime time.Time, err error)
+}
+
+// linker is implemented by storers able to hard link files.
+type linker interface {
+	Link(oldname, newname string) error
+}
+
+// newStorer returns the storer for output, which is either a local directory,
+// whose subdirectories and files are given dirMode and fileMode, or a URL of
+// the form s3://bucket/prefix.
+func newStorer(ctx context.Context, output string, dirMode, fileMode os.FileMode) (Storer, error) {
+	if strings.HasPrefix(output, "s3://") {
+		u, err := url.Parse(output)
+		if err != nil {
+			return nil, fmt.Errorf("invalid output %q: %w", output, err)
+		}
+		return newS3Storer(ctx, u.Host, strings.Trim(u.Path, "/"))
+	}
+	return localStorer{dir: output, dirMode: dirMode, fileMode: fileMode}, nil
+}
+
+// isLocalOutput reports whether output names a local directory.
+func isLocalOutput(output string) bool {
+	return !strings.HasPrefix(output, "s3://")
+}
+
+// localStorer stores files in a directory on the local file system.
+type localStorer struct {
+	dir      string
+	dirMode  os.FileMode
+	fileMode os.FileMode
+}
+
+func (s localStorer) path(name string) string {
+	return filepath.Join(s.dir, filepath.FromSlash(name))
+}
+
+// mkdirFor creates the directory file p goes in. The output directory itself
+// is created before any worker starts; this only creates subdirectories, such
+// as those of -group-by.
+func (s localStorer) mkdirFor(p string) error {
+	return mkdirAllMode(filepath.Dir(p), s.dirMode)
+}
+
+// Create writes to a temporary file next to name, renamed into place on
+// Close, so that a failed download neither leaves a partial file behind nor
+// clobbers a complete one from an earlier run.
+func (s localStorer) Create(_ context.Context, name string) (io.WriteCloser, error) {
+	p := s.path(name)
+	if err := s.mkdirFor(p); err != nil {
+		return nil, err
+	}
+	f, err := os.CreateTemp(filepath.Dir(p), "."+filepath.Base(p)+".*.part")
+	if err != nil {
+		return nil, err
+	}
+	return &atomicFile{File: f, path: p, mode: s.fileMode}, nil
+}
+
+func (s localStorer) Exists(_ context.Context, name string) (bool, error) {
+	_, err := os.Stat(s.path(name))
+	if errors.Is(err, os.ErrNotExist) {
+		return false, nil
+	}
+	return err == nil, err
+}
+
+func (s localStorer) Stat(_ context.Context, name string) (int64, time.Time, error) {
+	fi, err := os.Stat(s.path(name))
+	if err != nil {
+		return 0, time.Time{}, err
+	}
+	return fi.Size(), fi.ModTime(), nil
+}
+
+func (s localStorer) Remove(_ context.Context, name string) error {
+	return os.Remove(s.path(name))
+}
+
+func (s localStorer) Link(oldname, newname string) error {
+	p := s.path(newname)
+	if err := s.mkdirFor(p); err != nil {
+		return err
+	}
+	return os.Link(s.path(oldname), p)
+}
+
+// atomicFile is a temporary file that replaces path, with mode, when closed.
+type atomicFile struct {
+	*os.File
+	path string
+	mode os.FileMode
+}
+
+func (f *atomicFile) Close() error {
+	err := f.File.Close()
+	if err == nil {
+		err = os.Chmod(f.Name(), f.mode)
+	}
+	if err == nil {
+		err = os.Rename(f.Name(), f.path)
+	}
+	if err != nil {
+		os.Remove(f.Name())
+	}
+	return err
+}
+
+func (f *atomicFile) Abort() error {
+	f.File.Close()
+	return os.Remove(f.Name())
+}
+
+// s3Storer stores files as objects under a key prefix in an S3 bucket.
+// Credentials and region come from the usual AWS environment variables and
+// shared configuration files.
+type s3Storer struct {
+	client   *s3.Client
+	uploader *manager.Uploader
+	bucket   string
+	prefix   string
+}
+
+func newS3Storer(ctx context.Context, bucket, prefix string) (*s3Storer, error) {
+	if bucket == "" {
+		return nil, errors.New("missing bucket in s3 output")
+	}
+	cfg, err := awsconfig.LoadDefaultConfig(ctx)
+	if err != nil {
+		return nil, fmt.Errorf("unable to load AWS configuration: %w", err)
+	}
+	client := s3.NewFromConfig(cfg)
+	return &s3Storer{
+		client:   client,
+		uploader: manager.NewUploader(client),
+		bucket:   bucket,
+		prefix:   prefix,
+	}, nil
+}
+
+func (s *s3Storer) key(name string) string {
+	return path.Join(s.prefix, name)
+}
+
+// Create streams the file to S3 while it is written. S3 only creates the
+// object once the upload completes, so an aborted write, or one whose ctx is
+// done before Close, leaves nothing behind.
+func (s *s3Storer) Create(ctx context.Context, name string) (io.WriteCloser, error) {
+	pr, pw := io.Pipe()
+	w := &s3Writer{pw: pw, done: make(chan error, 1)}
+	input := &s3.PutObjectInput{
+		Bucket: aws.String(s.bucket),
+		Key:    aws.String(s.key(name)),
+		Body:   pr,
+	}
+	if ct := mime.TypeByExtension(path.Ext(name)); ct != "" {
+		input.ContentType = aws.String(ct)
+	}
+	go func() {
+		_, err := s.uploader.Upload(ctx, input)
+		pr.CloseWithError(err)
+		w.done <- err
+	}()
+	return w, nil
+}
+
+func (s *s3Storer) Exists(ctx context.Context, name string) (bool, error) {
+	_, err := s.client.HeadObject(ctx, &s3.HeadObjectInput{
+		Bucket: aws.String(s.bucket),
+		Key:    aws.String(s.key(name)),
+	})
+	var re *awshttp.ResponseError
+	if errors.As(err, &re) && re.HTTPStatusCode() == 404 {
+		return false, nil
+	}
+	return err == nil, err
+}
+
+func (s *s3Storer) Stat(ctx context.Context, name string) (int64, time.Time, error) {
+	out, err := s.client.HeadObject(ctx, &s3.HeadObjectInput{
+		Bucket: aws.String(s.bucket),
+		Key:    aws.String(s.key(name)),
+	})
+	var re *awshttp.ResponseError
+	if errors.As(err, &re) && re.HTTPStatusCode() == 404 {
+		return 0, time.Time{}, fmt.Errorf("%s: %w", name, os.ErrNotExist)
+	}
+	if err != nil {
+		return 0, time.Time{}, err
+	}
+	return out.ContentLength, aws.ToTime(out.LastModified), nil
+}
+
+func (s *s3Storer) Remove(ctx context.Context, name string) error {
+	_, err := s.client.DeleteObject(ctx, &s3.DeleteObjectInput{
+		Bucket: aws.String(s.bucket),
+		Key:    aws.String(s.key(name)),
+	})
+	return err
+}
+
+// errAborted fails an upload whose writer was aborted.
+var errAborted = errors.New("upload aborted")
+
+type s3Writer struct {
+	pw   *io.PipeWriter
+	done chan error
+}
+
+func (w *s3Writer) Write(p []byte) (int, error) {
+	return w.pw.Write(p)
+}
+
+func (w *s3Writer) Close() error {
+	w.pw.Close()
+	return <-w.done
+}
+
+func (w *s3Writer) Abort() error {
+	w.pw.CloseWithError(errAborted)
+	<-w.done
+	return nil
+}
+
+// writeFile stores data as name in s.
+func writeFile(ctx context.Context, s Storer, name string, data []byte) error {
+	w, err := s.Create(ctx, name)
+	if err != nil {
+		return err
+	}
+	if _, err := w.Write(data); err != nil {
+		if a, ok := w.(aborter); ok {
+			a.Abort()
+		}
+		return err
+	}
+	return w.Close()
+}
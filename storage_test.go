@@ -0,0 +1,77 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/aws/aws-sdk-go-v2/feature/s3/manager"
+	"github.com/aws/aws-sdk-go-v2/service/s3"
+)
+
+// TestS3StorerCancelled checks that every S3 call gives up as soon as its
+// context is cancelled, against an endpoint taking seconds to answer.
+func TestS3StorerCancelled(t *testing.T) {
+	stop := make(chan struct{})
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		select {
+		case <-r.Context().Done():
+		case <-stop:
+		case <-time.After(5 * time.Second):
+		}
+	}))
+	defer srv.Close()
+	defer close(stop)
+	client := s3.New(s3.Options{
+		Region:           "us-east-1",
+		Credentials:      aws.AnonymousCredentials{},
+		EndpointResolver: s3.EndpointResolverFromURL(srv.URL),
+		UsePathStyle:     true,
+	})
+	s := &s3Storer{client: client, uploader: manager.NewUploader(client), bucket: "mando", prefix: "art"}
+
+	tests := []struct {
+		name string
+		call func(ctx context.Context) error
+	}{
+		{"exists", func(ctx context.Context) error {
+			_, err := s.Exists(ctx, "razor-crest.jpeg")
+			return err
+		}},
+		{"stat", func(ctx context.Context) error {
+			_, _, err := s.Stat(ctx, "razor-crest.jpeg")
+			return err
+		}},
+		{"remove", func(ctx context.Context) error {
+			return s.Remove(ctx, "razor-crest.jpeg")
+		}},
+		{"create", func(ctx context.Context) error {
+			w, err := s.Create(ctx, "razor-crest.jpeg")
+			if err != nil {
+				return err
+			}
+			if _, err := w.Write(fakeImageData("razor-crest.jpeg")); err != nil {
+				return err
+			}
+			return w.Close()
+		}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			ctx, cancel := context.WithCancel(context.Background())
+			defer cancel()
+			time.AfterFunc(50*time.Millisecond, cancel)
+			start := time.Now()
+			if err := tt.call(ctx); !errors.Is(err, context.Canceled) {
+				t.Errorf("error = %v, want %v", err, context.Canceled)
+			}
+			if d := time.Since(start); d > 2*time.Second {
+				t.Errorf("returned after %v, want promptly after the cancellation", d)
+			}
+		})
+	}
+}
@@ -0,0 +1,77 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"log"
+	"sort"
+	"sync"
+)
+
+// sumsFile is the name of the file in the output listing the SHA-256 of every
+// downloaded file, in the format read by sha256sum -c.
+const sumsFile = "SHA256SUMS"
+
+// checksums collects the SHA-256 of downloaded files by name. It is safe for
+// concurrent use.
+type checksums struct {
+	mu    sync.Mutex
+	sums  map[string]string
+	fresh map[string]bool // written by this run
+}
+
+func newChecksums() *checksums {
+	return &checksums{sums: make(map[string]string), fresh: make(map[string]bool)}
+}
+
+// add records the sum of the file name written by this run.
+func (c *checksums) add(name, sum string) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.sums[name] = sum
+	c.fresh[name] = true
+}
+
+// addEarlier records the files downloaded by earlier runs in done, unless
+// this run wrote them again.
+func (c *checksums) addEarlier(done map[string]stateEntry) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	for _, e := range done {
+		if e.File != "" && e.SHA256 != "" && !c.fresh[e.File] {
+			c.sums[e.File] = e.SHA256
+		}
+	}
+}
+
+// save writes the sums to s, sorted by name. Files from earlier runs no longer
+// in s are left out, so that the list always checks out.
+func (c *checksums) save(ctx context.Context, s Storer) error {
+	c.mu.Lock()
+	names := make([]string, 0, len(c.sums))
+	for name := range c.sums {
+		names = append(names, name)
+	}
+	c.mu.Unlock()
+	sort.Strings(names)
+
+	var buf bytes.Buffer
+	for _, name := range names {
+		c.mu.Lock()
+		sum, fresh := c.sums[name], c.fresh[name]
+		c.mu.Unlock()
+		if !fresh {
+			exists, err := s.Exists(ctx, name)
+			if err != nil {
+				log.Printf("leaving %s out of %s: %v", name, sumsFile, err)
+				continue
+			}
+			if !exists {
+				continue
+			}
+		}
+		fmt.Fprintf(&buf, "%s  %s\n", sum, name)
+	}
+	return writeFile(ctx, s, sumsFile, buf.Bytes())
+}
@@ -0,0 +1,93 @@
+package main
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"testing"
+)
+
+func sha256Hex(b []byte) string {
+	sum := sha256.Sum256(b)
+	return hex.EncodeToString(sum[:])
+}
+
+func TestChecksumsSave(t *testing.T) {
+	dir := t.TempDir()
+	s, err := newStorer(context.Background(), dir, defaultDirMode, defaultFileMode)
+	if err != nil {
+		t.Fatal(err)
+	}
+	files := map[string][]byte{
+		"b.jpeg":         []byte("fresh"),
+		"a.jpeg":         []byte("earlier"),
+		"story/c d.jpeg": []byte("nested, with a space"),
+	}
+	for name, b := range files {
+		if err := writeFile(context.Background(), s, name, b); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	c := newChecksums()
+	c.add("b.jpeg", sha256Hex(files["b.jpeg"]))
+	c.add("story/c d.jpeg", sha256Hex(files["story/c d.jpeg"]))
+	c.addEarlier(map[string]stateEntry{
+		"a":    {File: "a.jpeg", SHA256: sha256Hex(files["a.jpeg"])},
+		"b":    {File: "b.jpeg", SHA256: sha256Hex([]byte("replaced since"))},
+		"gone": {File: "gone.jpeg", SHA256: sha256Hex([]byte("deleted"))},
+		"old":  {File: "old.jpeg"}, // recorded before sums were kept
+	})
+	if err := c.save(context.Background(), s); err != nil {
+		t.Fatal(err)
+	}
+
+	got, err := os.ReadFile(filepath.Join(dir, sumsFile))
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := sha256Hex(files["a.jpeg"]) + "  a.jpeg\n" +
+		sha256Hex(files["b.jpeg"]) + "  b.jpeg\n" +
+		sha256Hex(files["story/c d.jpeg"]) + "  story/c d.jpeg\n"
+	if string(got) != want {
+		t.Errorf("%s =\n%s\nwant\n%s", sumsFile, got, want)
+	}
+
+	if _, err := exec.LookPath("sha256sum"); err != nil {
+		t.Skip("sha256sum not available")
+	}
+	cmd := exec.Command("sha256sum", "-c", "--quiet", sumsFile)
+	cmd.Dir = dir
+	if out, err := cmd.CombinedOutput(); err != nil {
+		t.Errorf("sha256sum -c: %v\n%s", err, out)
+	}
+}
+
+func TestSavePicChecksum(t *testing.T) {
+	site := newFakeSite(t)
+	cfg := testConfig(t, site.URL)
+	cfg.tagMetadata = true // the sum is of what is written, not what was received
+	g := newTestGrabber(t, cfg)
+	g.state = openState(cfg.stateDir, false)
+	p := pic("sum", site.imageURL("sum"))
+	p.Caption = "Checksum"
+	if err := g.savePic(context.Background(), p); err != nil {
+		t.Fatal(err)
+	}
+
+	name := g.picName(p)
+	written, err := os.ReadFile(filepath.Join(cfg.output, filepath.FromSlash(name)))
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := sha256Hex(written)
+	if got := g.sums.sums[name]; got != want {
+		t.Errorf("sum of %s = %s, want %s", name, got, want)
+	}
+	if got := g.manifest.Entries[0].SHA256; got != want {
+		t.Errorf("manifest sum of %s = %s, want %s", name, got, want)
+	}
+}
@@ -0,0 +1,113 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"strconv"
+	"strings"
+
+	"golang.org/x/time/rate"
+)
+
+// maxThrottleChunk caps the number of bytes a throttled read asks the limiter
+// for at once, so that a high limit still releases data in small steps.
+const maxThrottleChunk = 32 * 1024
+
+// newBandwidthLimiter returns a token bucket over bytes allowing bps bytes per
+// second. It returns nil when bps is not positive, meaning unlimited.
+func newBandwidthLimiter(bps int64) *rate.Limiter {
+	if bps <= 0 {
+		return nil
+	}
+	burst := int(bps)
+	if bps > maxThrottleChunk {
+		burst = maxThrottleChunk
+	}
+	return rate.NewLimiter(rate.Limit(bps), burst)
+}
+
+// throttle wraps r so that reads wait for lim before returning. Waiting stops
+// as soon as ctx is done. If lim is nil, r is returned unchanged.
+func throttle(ctx context.Context, r io.Reader, lim *rate.Limiter) io.Reader {
+	if lim == nil {
+		return r
+	}
+	return &throttledReader{ctx: ctx, r: r, lim: lim}
+}
+
+type throttledReader struct {
+	ctx context.Context
+	r   io.Reader
+	lim *rate.Limiter
+}
+
+func (t *throttledReader) Read(p []byte) (int, error) {
+	if b := t.lim.Burst(); len(p) > b {
+		p = p[:b]
+	}
+	n, err := t.r.Read(p)
+	if n > 0 {
+		if werr := t.lim.WaitN(t.ctx, n); werr != nil {
+			return n, werr
+		}
+	}
+	return n, err
+}
+
+// byteSize is a flag.Value accepting sizes like 512, 64KB, 1.5MB or 2GiB.
+// Units are powers of 1024.
+type byteSize int64
+
+func (b *byteSize) String() string {
+	return strconv.FormatInt(int64(*b), 10)
+}
+
+func (b *byteSize) Set(s string) error {
+	v, err := parseByteSize(s)
+	if err != nil {
+		return err
+	}
+	*b = byteSize(v)
+	return nil
+}
+
+// formatBytes formats n with the largest binary unit it has at least one of.
+func formatBytes(n int64) string {
+	const unit = 1 << 10
+	if n < unit {
+		return fmt.Sprintf("%d B", n)
+	}
+	v, i := float64(n)/unit, 0
+	for v >= unit && i < 2 {
+		v /= unit
+		i++
+	}
+	return fmt.Sprintf("%.1f %s", v, []string{"KiB", "MiB", "GiB"}[i])
+}
+
+func parseByteSize(s string) (int64, error) {
+	units := []struct {
+		suffix string
+		mult   float64
+	}{
+		{"KIB", 1 << 10}, {"MIB", 1 << 20}, {"GIB", 1 << 30},
+		{"KB", 1 << 10}, {"MB", 1 << 20}, {"GB", 1 << 30},
+		{"K", 1 << 10}, {"M", 1 << 20}, {"G", 1 << 30},
+		{"B", 1},
+	}
+	str := strings.ToUpper(strings.TrimSpace(s))
+	mult := 1.0
+	for _, u := range units {
+		if strings.HasSuffix(str, u.suffix) {
+			str = strings.TrimSpace(strings.TrimSuffix(str, u.suffix))
+			mult = u.mult
+			break
+		}
+	}
+	v, err := strconv.ParseFloat(str, 64)
+	if err != nil || v < 0 {
+		return 0, fmt.Errorf("invalid size %q", s)
+	}
+	return int64(v * mult), nil
+}
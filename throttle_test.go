@@ -0,0 +1,40 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"io"
+	"testing"
+	"time"
+)
+
+func TestThrottleCancelled(t *testing.T) {
+	src := bytes.NewReader(make([]byte, 1<<20))
+	if r := throttle(context.Background(), src, nil); r != src {
+		t.Error("reader wrapped without a limiter")
+	}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	// The first read takes the whole burst; the next one waits a second for
+	// the limiter unless ctx is cancelled.
+	r := throttle(ctx, src, newBandwidthLimiter(1024))
+	buf := make([]byte, 4096)
+	if n, err := r.Read(buf); n != 1024 || err != nil {
+		t.Fatalf("first read = %d, %v, want the burst of 1024 bytes", n, err)
+	}
+	time.AfterFunc(50*time.Millisecond, cancel)
+	start := time.Now()
+	_, err := r.Read(buf)
+	if !errors.Is(err, context.Canceled) {
+		t.Errorf("read error = %v, want %v", err, context.Canceled)
+	}
+	if d := time.Since(start); d > 500*time.Millisecond {
+		t.Errorf("read returned %v after the cancellation, want promptly", d)
+	}
+
+	if _, err := io.ReadAll(r); !errors.Is(err, context.Canceled) {
+		t.Errorf("reading after cancellation: %v, want %v", err, context.Canceled)
+	}
+}
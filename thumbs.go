@@ -0,0 +1,109 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"fmt"
+	"image"
+	"image/jpeg"
+	"log"
+	"path"
+	"runtime"
+	"strings"
+	"sync"
+
+	"golang.org/x/image/draw"
+)
+
+// thumbsDir is the directory of the output thumbnails are stored in.
+const thumbsDir = "thumbs"
+
+type thumbJob struct {
+	name string // of the picture in the output
+	data []byte
+}
+
+// thumbnailer makes JPEG thumbnails of downloaded pictures in a pool of
+// workers of its own, so that decoding and scaling do not hold up downloads
+// unless the queue fills up.
+type thumbnailer struct {
+	ctx     context.Context // stops the thumbnails still to be stored
+	store   Storer
+	size    int // maximum width and height
+	quality int
+	jobs    chan thumbJob
+	wg      sync.WaitGroup
+}
+
+func newThumbnailer(ctx context.Context, s Storer, size, quality int) *thumbnailer {
+	t := &thumbnailer{
+		ctx:     ctx,
+		store:   s,
+		size:    size,
+		quality: quality,
+		jobs:    make(chan thumbJob, worker*picsPerWorker),
+	}
+	n := runtime.NumCPU()
+	t.wg.Add(n)
+	for i := 0; i < n; i++ {
+		go func() {
+			defer t.wg.Done()
+			for j := range t.jobs {
+				err := t.make(j)
+				if errors.Is(err, image.ErrFormat) {
+					// Neither JPEG, PNG nor WebP: nothing to preview.
+					log.Printf("no thumbnail of %s: unsupported image format", j.name)
+				} else if err != nil {
+					log.Printf("unable to make thumbnail of %s: %v", j.name, err)
+				}
+			}
+		}()
+	}
+	return t
+}
+
+// add queues a thumbnail of the picture name, whose content is data.
+func (t *thumbnailer) add(name string, data []byte) {
+	t.jobs <- thumbJob{name: name, data: data}
+}
+
+// close waits for the queued thumbnails. add must not be called afterwards.
+func (t *thumbnailer) close() {
+	close(t.jobs)
+	t.wg.Wait()
+}
+
+// thumbName returns the name of the thumbnail of the picture name.
+func thumbName(name string) string {
+	return path.Join(thumbsDir, strings.TrimSuffix(name, path.Ext(name))+".jpeg")
+}
+
+func (t *thumbnailer) make(j thumbJob) error {
+	src, _, err := image.Decode(bytes.NewReader(j.data))
+	if err != nil {
+		return fmt.Errorf("decode image: %w", err)
+	}
+	b := src.Bounds()
+	w, h := b.Dx(), b.Dy()
+	if w > t.size || h > t.size {
+		if w >= h {
+			w, h = t.size, h*t.size/w
+		} else {
+			w, h = w*t.size/h, t.size
+		}
+		if w < 1 {
+			w = 1
+		}
+		if h < 1 {
+			h = 1
+		}
+	}
+	dst := image.NewRGBA(image.Rect(0, 0, w, h))
+	draw.CatmullRom.Scale(dst, dst.Bounds(), src, b, draw.Src, nil)
+	var buf bytes.Buffer
+	if err := jpeg.Encode(&buf, dst, &jpeg.Options{Quality: t.quality}); err != nil {
+		return fmt.Errorf("encode thumbnail: %w", err)
+	}
+	return writeFile(t.ctx, t.store, thumbName(j.name), buf.Bytes())
+}
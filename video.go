@@ -0,0 +1,26 @@
+package main
+
+import (
+	"log"
+
+	"github.com/z11i/mandalorian-art-grabber/grill"
+)
+
+// selectVideos drops the videos from pics unless they are wanted. HLS
+// playlists, which cannot be downloaded as a single file, are dropped with a
+// warning.
+func (g *grabber) selectVideos(pics []Picture) []Picture {
+	out := pics[:0]
+	for _, p := range pics {
+		switch {
+		case !p.Video:
+		case !g.cfg.videos:
+			continue
+		case grill.VideoExt(p.URL) == grill.ExtHLS:
+			log.Printf("warning: skipping video %s, HLS streams are not supported", p.URL)
+			continue
+		}
+		out = append(out, p)
+	}
+	return out
+}
@@ -0,0 +1,57 @@
+package main
+
+import (
+	"context"
+	"log"
+	"sync/atomic"
+	"time"
+)
+
+// watchProbeAhead is how many chapters past the highest one found are looked
+// for on every poll.
+const watchProbeAhead = 2
+
+// watch runs the grabber over chapters every poll interval until work is
+// done, each time also looking for chapters past the highest one found so
+// far. Chapters whose gallery was missing are looked for again on every poll,
+// and completed downloads are skipped through the state file.
+func watch(ctx, work context.Context, cfg config, chapters []int) (*grabber, error) {
+	highest := 0
+	for poll := 1; ; poll++ {
+		g, err := newGrabber(cfg)
+		if err != nil {
+			return nil, err
+		}
+		if err := g.run(ctx, work, watchChapters(chapters, highest)); err != nil {
+			return g, err
+		}
+		if h := int(atomic.LoadInt64(&g.highest)); h > highest {
+			highest = h
+		}
+		log.Printf("poll %d: %d new pictures, %d failed, highest chapter %d, next poll at %s",
+			poll, atomic.LoadInt64(&g.stats.downloaded), len(g.failed.list()), highest,
+			time.Now().Add(cfg.pollInterval).Format(time.Kitchen))
+
+		t := time.NewTimer(cfg.pollInterval)
+		select {
+		case <-work.Done():
+			t.Stop()
+			return g, nil
+		case <-t.C:
+		}
+	}
+}
+
+// watchChapters returns chapters followed by those up to watchProbeAhead past
+// highest.
+func watchChapters(chapters []int, highest int) []int {
+	all := append([]int(nil), chapters...)
+	last := 0
+	if len(all) > 0 {
+		last = all[len(all)-1]
+	}
+	for c := last + 1; c <= highest+watchProbeAhead; c++ {
+		all = append(all, c)
+	}
+	return all
+}
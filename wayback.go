@@ -0,0 +1,114 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"log"
+	"net/http"
+	"net/url"
+	"strings"
+
+	"github.com/z11i/mandalorian-art-grabber/grill"
+)
+
+// Wayback Machine endpoints, for galleries removed from the site.
+const (
+	waybackAvailableURL = "https://archive.org/wayback/available"
+	waybackURL          = "https://web.archive.org/web/"
+)
+
+// archiveURL returns the URL of the copy of raw the Wayback Machine took at
+// timestamp, as it was originally served, without the archive's toolbar or
+// rewritten links.
+func archiveURL(timestamp, raw string) string {
+	return waybackURL + timestamp + "id_/" + raw
+}
+
+// unarchivedURL returns the URL the Wayback Machine copy at u was taken of, or
+// u itself if it is not a copy.
+func unarchivedURL(u string) string {
+	rest := strings.TrimPrefix(u, waybackURL)
+	if rest == u {
+		return u
+	}
+	i := strings.Index(rest, "/")
+	if i < 0 {
+		return u
+	}
+	return rest[i+1:]
+}
+
+// waybackSnapshot returns the timestamp of the most recent copy of page the
+// Wayback Machine has, or errNotFound if it has none.
+func (g *grabber) waybackSnapshot(ctx context.Context, page string) (string, error) {
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, waybackAvailableURL+"?url="+url.QueryEscape(page), nil)
+	if err != nil {
+		return "", fmt.Errorf("error creating request: %w", err)
+	}
+	var avail struct {
+		Snapshots struct {
+			Closest struct {
+				Available bool   `json:"available"`
+				Status    string `json:"status"`
+				Timestamp string `json:"timestamp"`
+			} `json:"closest"`
+		} `json:"archived_snapshots"`
+	}
+	err = httpDo(ctx, g.client, req, func(resp *http.Response, err error) error {
+		if err != nil {
+			return err
+		}
+		defer resp.Body.Close()
+		if resp.StatusCode != http.StatusOK {
+			return &statusError{code: resp.StatusCode, status: resp.Status, url: req.URL.String(), retryAfter: resp.Header.Get("Retry-After")}
+		}
+		return json.NewDecoder(resp.Body).Decode(&avail)
+	})
+	if err != nil {
+		return "", fmt.Errorf("unable to look up %s in the Wayback Machine: %w", page, err)
+	}
+	closest := avail.Snapshots.Closest
+	if !closest.Available || closest.Status != "200" || closest.Timestamp == "" {
+		return "", errNotFound
+	}
+	return closest.Timestamp, nil
+}
+
+// fetchArchivedGallery is fetchGallery for the Wayback Machine's most recent
+// copy of the gallery page at page. The pictures are downloaded from the
+// archive too, and marked as archived. It returns errNotFound if there is no
+// copy.
+func (g *grabber) fetchArchivedGallery(ctx context.Context, gal gallery, page string, picChan chan<- Picture) error {
+	itemCtx, cancel := itemContext(ctx, g.cfg.galleryTimeout)
+	timestamp, err := g.waybackSnapshot(itemCtx, page)
+	cancel()
+	if err != nil {
+		return err
+	}
+	// The pictures' URLs are resolved against the page as published, and
+	// are only wrapped if the copy did not link to the archive already.
+	archived := func(u string) string {
+		if unarchivedURL(u) != u {
+			return u
+		}
+		return archiveURL(timestamp, u)
+	}
+	pics, err := g.loadGalleryPages(ctx, gal, page, archived)
+	if err != nil {
+		return err
+	}
+	log.Printf("%s is gone, using the Wayback Machine's copy from %s", page, timestamp)
+	for i := range pics {
+		pics[i].URL = archived(pics[i].URL)
+		if vs := pics[i].Variants; len(vs) > 0 {
+			pics[i].Variants = make([]grill.Variant, len(vs))
+			for j, v := range vs {
+				v.URL = archived(v.URL)
+				pics[i].Variants[j] = v
+			}
+		}
+		pics[i].Archived = true
+	}
+	return g.sendPics(ctx, gal, pics, picChan)
+}
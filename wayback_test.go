@@ -0,0 +1,99 @@
+package main
+
+import (
+	"context"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestUnarchivedURL(t *testing.T) {
+	tests := []struct{ u, want string }{
+		{"https://web.archive.org/web/20210101000000id_/https://www.starwars.com/a", "https://www.starwars.com/a"},
+		{"https://web.archive.org/web/20210101000000im_/https://cdn.example.com/a.jpeg?w=1", "https://cdn.example.com/a.jpeg?w=1"},
+		{"https://www.starwars.com/a", "https://www.starwars.com/a"},
+		{"https://web.archive.org/web/20210101000000", "https://web.archive.org/web/20210101000000"},
+	}
+	for _, tt := range tests {
+		if got := unarchivedURL(tt.u); got != tt.want {
+			t.Errorf("unarchivedURL(%q) = %q, want %q", tt.u, got, tt.want)
+		}
+	}
+}
+
+// hostRewriter sends every request to target instead, keeping the host it
+// was meant for in the path, so that a test server can stand in for several
+// sites.
+type hostRewriter struct {
+	target *url.URL
+}
+
+func (h hostRewriter) RoundTrip(req *http.Request) (*http.Response, error) {
+	out := req.Clone(req.Context())
+	out.URL.Path = "/" + req.URL.Host + req.URL.Path
+	out.URL.Scheme, out.URL.Host = h.target.Scheme, h.target.Host
+	resp, err := http.DefaultTransport.RoundTrip(out)
+	if err != nil {
+		return nil, err
+	}
+	resp.Request = req
+	return resp, nil
+}
+
+func TestFetchArchivedGallery(t *testing.T) {
+	const (
+		page     = "https://www.starwars.com/series/the-mandalorian/chapter-1-concept-art-gallery"
+		snapshot = "20210101000000"
+	)
+	images := `[
+		{"id": "rel", "image": "/content/rel.jpeg"},
+		{"id": "abs", "image": "https://lumiere-a.akamaihd.net/abs.jpeg"},
+		{"id": "proto", "image": "//lumiere-a.akamaihd.net/proto.jpeg"},
+		{"id": "arch", "image": "https://web.archive.org/web/` + snapshot + `im_/https://lumiere-a.akamaihd.net/arch.jpeg"}
+	]`
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		switch {
+		case r.URL.Path == "/archive.org/wayback/available":
+			io.WriteString(w, `{"archived_snapshots": {"closest": {"available": true, "status": "200", "timestamp": "`+snapshot+`"}}}`)
+		case strings.HasPrefix(r.URL.Path, "/web.archive.org/web/"+snapshot+"id_/"):
+			io.WriteString(w, `<html><body><div id="main"><script>this.Grill?Grill.burger={"stack":[{"data":[]},{"data":[]},{"data":[{"images":`+images+`}]}]}:(function(){})();</script></div></body></html>`)
+		default:
+			http.NotFound(w, r)
+		}
+	}))
+	defer srv.Close()
+	target, _ := url.Parse(srv.URL)
+	cfg := testConfig(t, "https://www.starwars.com")
+	cfg.wayback = true
+	g := newTestGrabber(t, cfg)
+	g.client = &http.Client{Transport: hostRewriter{target}}
+	g.fetcher = httpFetcher{client: g.client}
+
+	picChan := make(chan Picture, 10)
+	if err := g.fetchArchivedGallery(context.Background(), gallery{chapter: 1}, page, picChan); err != nil {
+		t.Fatal(err)
+	}
+	close(picChan)
+	want := map[string]string{
+		"rel":   "https://web.archive.org/web/" + snapshot + "id_/https://www.starwars.com/content/rel.jpeg",
+		"abs":   "https://web.archive.org/web/" + snapshot + "id_/https://lumiere-a.akamaihd.net/abs.jpeg",
+		"proto": "https://web.archive.org/web/" + snapshot + "id_/https://lumiere-a.akamaihd.net/proto.jpeg",
+		"arch":  "https://web.archive.org/web/" + snapshot + "im_/https://lumiere-a.akamaihd.net/arch.jpeg",
+	}
+	pics := collect(t, picChan, time.Second)
+	if len(pics) != len(want) {
+		t.Fatalf("got %d pictures, want %d", len(pics), len(want))
+	}
+	for _, p := range pics {
+		if p.URL != want[p.ID] {
+			t.Errorf("picture %s: url %s, want %s", p.ID, p.URL, want[p.ID])
+		}
+		if p.Page != page || !p.Archived {
+			t.Errorf("picture %s: page %s, archived %v, want %s, archived", p.ID, p.Page, p.Archived, page)
+		}
+	}
+}